@@ -0,0 +1,34 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// requireAPIKey rejects a write without "Authorization: Bearer <key>" when
+// VECVIZ_API_KEY sets a key. GET and HEAD pass, so progress reads such as
+// GET /reembed stay public, and with no key configured everything passes.
+func (s *Server) requireAPIKey(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.apiKey == "" || r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next(w, r)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || !keysEqual(token, s.apiKey) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="vecviz"`)
+			writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// keysEqual compares two keys in constant time. Hashing first keeps the
+// comparison from revealing the key's length as well as its contents.
+func keysEqual(a, b string) bool {
+	ha, hb := sha256.Sum256([]byte(a)), sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
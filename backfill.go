@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/tlehman/vecviz/db"
+)
+
+// POST /tsne/backfill - Place prompts that have an embedding but no projection
+//
+// Each missing point is placed the way an incremental run seeds a new one,
+// at the weighted average of its nearest projected neighbors, and stored
+// alongside the existing projections, which are left as they are. This is
+// far cheaper than a recompute and keeps the view complete, but positions
+// are only approximate until the next /tsne/compute, so needs_tsne_update
+// stays true. A point whose nearest neighbors are all unprojected too can't
+// be placed and is listed in "skipped". Placed points have only x, y and z,
+// even if the stored projections have more dimensions.
+func (s *Server) handleTSNEBackfill(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// A projection run replaces what is stored, so wait for it rather than
+	// write under it
+	s.projectionMu.Lock()
+	defer s.projectionMu.Unlock()
+
+	ids, err := s.store.FindUnprojected(r.Context())
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to find unprojected prompts: "+err.Error())
+		return
+	}
+
+	projections := make([]db.Projection, 0, len(ids))
+	skipped := []int64{}
+	for _, id := range ids {
+		embedding, err := s.store.GetEmbedding(r.Context(), id)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to get embedding: "+err.Error())
+			return
+		}
+		x, y, z, err := s.store.ProjectNewPoint(r.Context(), embedding)
+		if errors.Is(err, db.ErrNoProjectedNeighbors) {
+			skipped = append(skipped, id)
+			continue
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to project: "+err.Error())
+			return
+		}
+		projections = append(projections, db.Projection{PromptID: id, X: x, Y: y, Z: z, Norm: vectorNorm(embedding)})
+	}
+
+	if len(projections) > 0 {
+		// The points join the current layout rather than starting a new run
+		runID, err := s.store.ProjectionRunID()
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to get projection run ID: "+err.Error())
+			return
+		}
+		if err := s.store.InsertProjections(projections, runID); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to store projections: "+err.Error())
+			return
+		}
+		s.events.publish("projection_updated", map[string]interface{}{"method": "backfill", "points_processed": len(projections)})
+	}
+
+	needsUpdate, err := s.projectionsStale(r.Context())
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to check projections: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"backfilled":        len(projections),
+		"skipped":           skipped,
+		"needs_tsne_update": needsUpdate,
+	})
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+
+	"github.com/tlehman/vecviz/db"
+)
+
+// boundingBox keeps points inside a range on each axis. Bounds that weren't
+// given are open, so the zero-argument box contains everything.
+type boundingBox struct {
+	min, max [3]float64
+}
+
+// boundingBoxParams are the query parameters for each axis's bounds
+var boundingBoxParams = [3][2]string{
+	{"xmin", "xmax"},
+	{"ymin", "ymax"},
+	{"zmin", "zmax"},
+}
+
+// parseBoundingBox reads ?xmin=&xmax=&ymin=&ymax=&zmin=&zmax= from q
+func parseBoundingBox(q url.Values) (boundingBox, error) {
+	var b boundingBox
+	for axis, names := range boundingBoxParams {
+		b.min[axis], b.max[axis] = math.Inf(-1), math.Inf(1)
+		for i, name := range names {
+			s := q.Get(name)
+			if s == "" {
+				continue
+			}
+			v, err := strconv.ParseFloat(s, 64)
+			if err != nil || math.IsNaN(v) {
+				return boundingBox{}, fmt.Errorf("%s must be a number", name)
+			}
+			if i == 0 {
+				b.min[axis] = v
+			} else {
+				b.max[axis] = v
+			}
+		}
+		if b.min[axis] > b.max[axis] {
+			return boundingBox{}, fmt.Errorf("%s must not be greater than %s", names[0], names[1])
+		}
+	}
+	return b, nil
+}
+
+// contains reports whether p lies inside the box, bounds included
+func (b boundingBox) contains(p db.Projection) bool {
+	for axis, v := range [3]float64{p.X, p.Y, p.Z} {
+		if v < b.min[axis] || v > b.max[axis] {
+			return false
+		}
+	}
+	return true
+}
+
+// extent returns the min and max corner and the centroid of projections as
+// {"x", "y", "z"} objects for /points, or nils for no projections
+func extent(projections []db.Projection) (bounds, centroid interface{}) {
+	if len(projections) == 0 {
+		return nil, nil
+	}
+	lo := [3]float64{math.Inf(1), math.Inf(1), math.Inf(1)}
+	hi := [3]float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+	var sum [3]float64
+	for _, p := range projections {
+		for axis, v := range [3]float64{p.X, p.Y, p.Z} {
+			lo[axis] = math.Min(lo[axis], v)
+			hi[axis] = math.Max(hi[axis], v)
+			sum[axis] += v
+		}
+	}
+	n := float64(len(projections))
+	xyz := func(v [3]float64) map[string]float64 {
+		return map[string]float64{"x": v[0], "y": v[1], "z": v[2]}
+	}
+	return map[string]interface{}{"min": xyz(lo), "max": xyz(hi)},
+		xyz([3]float64{sum[0] / n, sum[1] / n, sum[2] / n})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/tlehman/vecviz/cluster"
+	"github.com/tlehman/vecviz/tsne"
+)
+
+const (
+	// defaultClusterK is the number of clusters /cluster makes when k is not given
+	defaultClusterK = 5
+	// defaultAutoMaxK is the largest k /cluster?auto=true tries when max_k is not given
+	defaultAutoMaxK = 10
+	// maxAutoMaxK bounds max_k, since every k tried is a full k-means run
+	maxAutoMaxK = 20
+)
+
+// POST /cluster?k=5&seed=42 - Group the projected points with k-means
+// POST /cluster?auto=true&max_k=10 - Pick k from 2 to max_k by silhouette score
+//
+// Clustering runs on the layout coordinates, so clusters match what is drawn.
+// Each point's cluster is stored and shown in /points until the next /cluster
+// or projection run. With fewer points than k, each point gets its own cluster.
+// With auto, the response also has the silhouette score of every k tried.
+func (s *Server) handleCluster(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	auto := r.URL.Query().Get("auto") == "true"
+	if auto && r.URL.Query().Get("k") != "" {
+		writeJSONError(w, http.StatusBadRequest, "k cannot be combined with auto; use max_k")
+		return
+	}
+
+	k := defaultClusterK
+	if v := r.URL.Query().Get("k"); v != "" {
+		var err error
+		k, err = strconv.Atoi(v)
+		if err != nil || k < 1 {
+			writeJSONError(w, http.StatusBadRequest, "Invalid k")
+			return
+		}
+	}
+
+	maxK := defaultAutoMaxK
+	if v := r.URL.Query().Get("max_k"); v != "" {
+		var err error
+		maxK, err = strconv.Atoi(v)
+		if err != nil || maxK < 2 || maxK > maxAutoMaxK {
+			writeJSONError(w, http.StatusBadRequest, "max_k must be between 2 and "+strconv.Itoa(maxAutoMaxK))
+			return
+		}
+	}
+
+	seed := int64(tsne.DefaultRandomSeed)
+	if v := r.URL.Query().Get("seed"); v != "" {
+		var err error
+		seed, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid seed")
+			return
+		}
+	}
+
+	// A projection run replaces every row, which would drop the clusters
+	s.projectionMu.Lock()
+	defer s.projectionMu.Unlock()
+
+	projections, err := s.store.GetAllProjections(r.Context(), false)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to get projections: "+err.Error())
+		return
+	}
+
+	points := make([]cluster.Point, len(projections))
+	for i, p := range projections {
+		points[i] = cluster.Point{p.X, p.Y, p.Z}
+	}
+	var result cluster.Result
+	var scores []cluster.Score
+	if auto {
+		result, scores = cluster.Auto(points, maxK, seed)
+	} else {
+		result = cluster.KMeans(points, k, seed)
+	}
+
+	labels := make(map[int64]int, len(projections))
+	for i, p := range projections {
+		labels[p.PromptID] = result.Labels[i]
+	}
+	if err := s.store.SetClusters(labels); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to store clusters: "+err.Error())
+		return
+	}
+
+	clusters := make([]map[string]interface{}, result.K)
+	for c := range clusters {
+		clusters[c] = map[string]interface{}{
+			"id":       c,
+			"centroid": result.Centroids[c],
+			"size":     result.Sizes[c],
+		}
+	}
+
+	response := map[string]interface{}{
+		"k":          result.K,
+		"clusters":   clusters,
+		"iterations": result.Iterations,
+	}
+	if auto {
+		response["scores"] = scores
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,168 @@
+// Package cluster groups projected points. It works on the 2D/3D layout
+// coordinates rather than the embeddings, so clusters match what is drawn.
+package cluster
+
+import (
+	"math"
+	"math/rand"
+)
+
+// maxIterations bounds Lloyd's algorithm if assignments keep changing
+const maxIterations = 100
+
+// Point is a projected position
+type Point = [3]float64
+
+// Result is the outcome of KMeans
+type Result struct {
+	// K is the number of clusters found, at most the number of distinct points
+	K int
+	// Labels[i] is the cluster of the i-th input point
+	Labels []int
+	// Centroids[c] is the mean position of cluster c
+	Centroids []Point
+	// Sizes[c] is how many points are in cluster c
+	Sizes []int
+	// Iterations is how many assignment rounds ran before converging
+	Iterations int
+}
+
+// KMeans splits points into k clusters with Lloyd's algorithm, seeded by
+// k-means++. k is lowered to the number of distinct points if there are
+// fewer, so no cluster is empty. The same points, k and seed always give the
+// same result.
+func KMeans(points []Point, k int, seed int64) Result {
+	k = min(k, distinct(points))
+	if k <= 0 {
+		return Result{Labels: make([]int, len(points)), Centroids: []Point{}, Sizes: []int{}}
+	}
+
+	rng := rand.New(rand.NewSource(seed))
+	centroids := seedCentroids(points, k, rng)
+	labels := make([]int, len(points))
+	for i := range labels {
+		labels[i] = -1
+	}
+
+	iterations := 0
+	for iterations < maxIterations {
+		iterations++
+		changed := false
+		for i, p := range points {
+			if c := nearest(p, centroids); c != labels[i] {
+				labels[i] = c
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+		updateCentroids(points, labels, centroids)
+	}
+
+	sizes := make([]int, k)
+	for _, c := range labels {
+		sizes[c]++
+	}
+	return Result{K: k, Labels: labels, Centroids: centroids, Sizes: sizes, Iterations: iterations}
+}
+
+// distinct counts the different positions among points
+func distinct(points []Point) int {
+	seen := make(map[Point]struct{}, len(points))
+	for _, p := range points {
+		seen[p] = struct{}{}
+	}
+	return len(seen)
+}
+
+// seedCentroids picks k starting centroids with k-means++: each one is a
+// point chosen with probability proportional to its squared distance from
+// the centroids picked so far, which spreads them out
+func seedCentroids(points []Point, k int, rng *rand.Rand) []Point {
+	centroids := make([]Point, 0, k)
+	centroids = append(centroids, points[rng.Intn(len(points))])
+
+	dist := make([]float64, len(points))
+	for len(centroids) < k {
+		var total float64
+		for i, p := range points {
+			dist[i] = squaredDistance(p, centroids[nearest(p, centroids)])
+			total += dist[i]
+		}
+		// Rounding can leave target just above zero at the end, so fall back
+		// to the last point that isn't already a centroid
+		target := rng.Float64() * total
+		chosen := -1
+		for i, d := range dist {
+			if d == 0 {
+				continue
+			}
+			chosen = i
+			target -= d
+			if target < 0 {
+				break
+			}
+		}
+		centroids = append(centroids, points[chosen])
+	}
+	return centroids
+}
+
+// updateCentroids moves each centroid to the mean of its points. A cluster
+// left empty takes over the point farthest from its own centroid.
+func updateCentroids(points []Point, labels []int, centroids []Point) {
+	sums := make([]Point, len(centroids))
+	counts := make([]int, len(centroids))
+	for i, p := range points {
+		c := labels[i]
+		counts[c]++
+		for axis := range p {
+			sums[c][axis] += p[axis]
+		}
+	}
+	for c := range centroids {
+		if counts[c] == 0 {
+			continue
+		}
+		for axis := range sums[c] {
+			centroids[c][axis] = sums[c][axis] / float64(counts[c])
+		}
+	}
+	for c := range centroids {
+		if counts[c] == 0 {
+			centroids[c] = points[farthest(points, labels, centroids)]
+		}
+	}
+}
+
+// farthest returns the index of the point farthest from its assigned centroid
+func farthest(points []Point, labels []int, centroids []Point) int {
+	best, bestDist := 0, -1.0
+	for i, p := range points {
+		if d := squaredDistance(p, centroids[labels[i]]); d > bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return best
+}
+
+// nearest returns the index of the centroid closest to p
+func nearest(p Point, centroids []Point) int {
+	best, bestDist := 0, math.Inf(1)
+	for c, centroid := range centroids {
+		if d := squaredDistance(p, centroid); d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	return best
+}
+
+func squaredDistance(a, b Point) float64 {
+	var sum float64
+	for axis := range a {
+		d := a[axis] - b[axis]
+		sum += d * d
+	}
+	return sum
+}
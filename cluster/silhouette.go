@@ -0,0 +1,98 @@
+package cluster
+
+import (
+	"math"
+	"math/rand"
+)
+
+// maxSilhouetteSample bounds how many points silhouette scores are computed
+// on. Scoring is quadratic, so larger sets are scored on a random sample.
+const maxSilhouetteSample = 1000
+
+// Score is the silhouette of one k tried by Auto
+type Score struct {
+	K          int     `json:"k"`
+	Silhouette float64 `json:"silhouette"`
+}
+
+// Auto runs KMeans for every k from 2 to maxK and keeps the clustering with
+// the highest mean silhouette, returning it with the score of each k tried.
+// k stops short of the number of distinct points, where silhouette is
+// undefined; with fewer than 3 distinct points every point is its own
+// cluster and no scores are returned.
+func Auto(points []Point, maxK int, seed int64) (Result, []Score) {
+	maxK = min(maxK, distinct(points)-1)
+	if maxK < 2 {
+		return KMeans(points, len(points), seed), []Score{}
+	}
+
+	// Score every k on the same sample so the scores are comparable
+	rng := rand.New(rand.NewSource(seed))
+	sample := rng.Perm(len(points))
+	if len(sample) > maxSilhouetteSample {
+		sample = sample[:maxSilhouetteSample]
+	}
+	sampled := make([]Point, len(sample))
+	for i, idx := range sample {
+		sampled[i] = points[idx]
+	}
+
+	var best Result
+	bestScore := math.Inf(-1)
+	scores := make([]Score, 0, maxK-1)
+	labels := make([]int, len(sample))
+	for k := 2; k <= maxK; k++ {
+		result := KMeans(points, k, seed)
+		for i, idx := range sample {
+			labels[i] = result.Labels[idx]
+		}
+		score := Silhouette(sampled, labels, result.K)
+		scores = append(scores, Score{K: result.K, Silhouette: score})
+		if score > bestScore {
+			best, bestScore = result, score
+		}
+	}
+	return best, scores
+}
+
+// Silhouette returns the mean silhouette coefficient of points labelled with
+// k clusters, from -1 (points sit closer to another cluster) to 1 (tight,
+// well-separated clusters). A point alone in its cluster scores 0.
+func Silhouette(points []Point, labels []int, k int) float64 {
+	if len(points) == 0 {
+		return 0
+	}
+
+	sizes := make([]int, k)
+	for _, c := range labels {
+		sizes[c]++
+	}
+
+	var total float64
+	sums := make([]float64, k)
+	for i, p := range points {
+		clear(sums)
+		for j, q := range points {
+			if i != j {
+				sums[labels[j]] += math.Sqrt(squaredDistance(p, q))
+			}
+		}
+
+		own := labels[i]
+		if sizes[own] == 1 {
+			continue
+		}
+		// a is the mean distance within the point's cluster, b to the nearest other one
+		a := sums[own] / float64(sizes[own]-1)
+		b := math.Inf(1)
+		for c := range sums {
+			if c != own && sizes[c] > 0 {
+				b = math.Min(b, sums[c]/float64(sizes[c]))
+			}
+		}
+		if m := math.Max(a, b); m > 0 && !math.IsInf(b, 1) {
+			total += (b - a) / m
+		}
+	}
+	return total / float64(len(points))
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/tlehman/vecviz/db"
+	"github.com/tlehman/vecviz/ollama"
+)
+
+// combinedSeparator joins prompt texts for /embed/combined
+const combinedSeparator = "\n\n"
+
+// maxCombinedPrompts bounds how many prompts /embed/combined joins
+const maxCombinedPrompts = 100
+
+// POST /embed/combined - Embed several prompts joined into one document
+// Body: {"ids": [1, 2, 3], "k": 10, "store": false}
+//
+// The referenced prompt texts are concatenated (separated by blank lines)
+// and embedded as a single text, and the nearest stored prompts to that
+// embedding are returned. This is not the centroid of the prompts' vectors,
+// as used by /stats/centroid-trajectory: the model reads the joined text as
+// a whole, so the result reflects how the ideas interact rather than their
+// average position. With "store": true the joined text is saved as a new
+// prompt and excluded from its own neighbors.
+func (s *Server) handleEmbedCombined(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req struct {
+		IDs   []int64 `json:"ids"`
+		K     int     `json:"k"`
+		Store bool    `json:"store"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	if len(req.IDs) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "ids is required")
+		return
+	}
+	if len(req.IDs) > maxCombinedPrompts {
+		writeJSONError(w, http.StatusBadRequest, "Too many ids")
+		return
+	}
+	k, err := s.checkK(req.K)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	req.K = k
+
+	texts := make([]string, len(req.IDs))
+	for i, id := range req.IDs {
+		text, err := s.store.GetPromptText(r.Context(), id)
+		if errors.Is(err, db.ErrPromptNotFound) {
+			writeJSONError(w, http.StatusNotFound, "Prompt not found")
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to get prompt: "+err.Error())
+			return
+		}
+		texts[i] = text
+	}
+	combined := strings.Join(texts, combinedSeparator)
+
+	var embedding []float32
+	var storedID int64
+	if req.Store {
+		storedID, err = s.store.InsertPrompt(combined)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to store prompt: "+err.Error())
+			return
+		}
+		var result *ollama.EmbeddingResult
+		if result, err = s.embedAndStore(r.Context(), s.embedder, storedID, combined); err == nil {
+			embedding = result.Embedding
+		}
+	} else {
+		embedding, err = s.embedder.Embed(r.Context(), combined)
+	}
+	if err != nil {
+		slog.Error("Embed failed", "prompt_ids", req.IDs, "stored_id", storedID, "chars", len(combined), "err", err)
+		writeJSONError(w, embedErrorStatus(err), "Failed to embed prompt: "+err.Error())
+		return
+	}
+
+	// Ask for one extra so the stored prompt can be dropped from its own neighbors
+	matches, err := s.store.SearchNearest(r.Context(), embedding, req.K+1)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Search failed: "+err.Error())
+		return
+	}
+
+	neighbors := []map[string]interface{}{}
+	for _, m := range matches {
+		if (req.Store && m.PromptID == storedID) || len(neighbors) == req.K {
+			continue
+		}
+		neighbors = append(neighbors, map[string]interface{}{
+			"id":       m.PromptID,
+			"text":     m.Text,
+			"distance": m.Distance,
+		})
+	}
+
+	resp := map[string]interface{}{
+		"text":      combined,
+		"id":        nil,
+		"metric":    s.store.Metric,
+		"neighbors": neighbors,
+	}
+	if req.Store {
+		resp["id"] = storedID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
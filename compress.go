@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// gzipMinSize is the smallest response compressResponses compresses; below
+// it gzip's overhead outweighs the savings
+const gzipMinSize = 1024
+
+// compressResponses gzips responses of gzipMinSize bytes or more for clients
+// that send Accept-Encoding: gzip, which shrinks large, mostly numeric JSON
+// such as /points and /export several times over. Smaller responses, and
+// streams such as /tsne/progress that flush before reaching the threshold,
+// are sent as they are.
+func compressResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == http.MethodHead || !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(gw, r)
+		// Not deferred: after a panic, recoverPanics answers on the
+		// underlying writer, which is only clean if nothing was sent yet
+		gw.Close()
+	})
+}
+
+// acceptsGzip reports whether r's Accept-Encoding allows gzip
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(part, ";")
+		if strings.TrimSpace(name) != "gzip" {
+			continue
+		}
+		// gzip;q=0 refuses it
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			weight, err := strconv.ParseFloat(q, 64)
+			return err != nil || weight > 0
+		}
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter holds back the status and the start of the body until
+// either gzipMinSize bytes have been written, when it starts compressing, or
+// the handler flushes or finishes, when it sends them uncompressed
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status int
+	buf    []byte
+	// sent is set once the status has gone out, compressed or not
+	sent     bool
+	gz       *gzip.Writer
+	hijacked bool
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if !g.sent && g.status == 0 {
+		g.status = status
+	}
+}
+
+func (g *gzipResponseWriter) Write(b []byte) (int, error) {
+	if g.sent {
+		if g.gz != nil {
+			return g.gz.Write(b)
+		}
+		return g.ResponseWriter.Write(b)
+	}
+	g.buf = append(g.buf, b...)
+	if len(g.buf) >= gzipMinSize {
+		if err := g.send(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// send writes the status and the buffered body, compressing it and the rest
+// if compress is set and the response is one that can be
+func (g *gzipResponseWriter) send(compress bool) error {
+	g.sent = true
+	if g.status == 0 {
+		g.status = http.StatusOK
+	}
+	h := g.Header()
+	// Ranges, and bodies a handler already encoded, must go out as they are
+	compressible := g.status >= 200 && g.status < 300 && g.status != http.StatusNoContent && g.status != http.StatusPartialContent &&
+		h.Get("Content-Encoding") == "" && h.Get("Content-Range") == ""
+	if compress && compressible {
+		// net/http would otherwise sniff the compressed bytes
+		if h.Get("Content-Type") == "" {
+			h.Set("Content-Type", http.DetectContentType(g.buf))
+		}
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		g.gz = gzip.NewWriter(g.ResponseWriter)
+	}
+	g.ResponseWriter.WriteHeader(g.status)
+
+	buf := g.buf
+	g.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := g.Write(buf)
+	return err
+}
+
+// Flush sends what is buffered, uncompressed if compression hasn't started,
+// so streaming handlers reach the client as they write
+func (g *gzipResponseWriter) Flush() {
+	if !g.sent {
+		g.send(false)
+	}
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close sends a response too small to compress and finishes a compressed one
+func (g *gzipResponseWriter) Close() error {
+	if g.hijacked {
+		return nil
+	}
+	if !g.sent {
+		if err := g.send(false); err != nil {
+			return err
+		}
+	}
+	if g.gz != nil {
+		return g.gz.Close()
+	}
+	return nil
+}
+
+// Hijack lets /ws take over the connection, after which nothing is sent here
+func (g *gzipResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := g.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		g.hijacked = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// corsPolicy controls which browser origins may call a group of routes.
+// With no origins configured it adds no headers, so only same-origin pages work.
+type corsPolicy struct {
+	origins          map[string]bool
+	allowAny         bool
+	allowCredentials bool
+	methods          string
+}
+
+// corsFromEnv builds the read and write route policies from
+// VECVIZ_CORS_READ_ORIGINS, VECVIZ_CORS_WRITE_ORIGINS and VECVIZ_CORS_CREDENTIALS
+func corsFromEnv() (read, write *corsPolicy, err error) {
+	allowCredentials, _ := strconv.ParseBool(os.Getenv("VECVIZ_CORS_CREDENTIALS"))
+	read, err = newCORSPolicy(os.Getenv("VECVIZ_CORS_READ_ORIGINS"), allowCredentials, "GET, POST, OPTIONS")
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid VECVIZ_CORS_READ_ORIGINS: %w", err)
+	}
+	write, err = newCORSPolicy(os.Getenv("VECVIZ_CORS_WRITE_ORIGINS"), allowCredentials, "POST, PATCH, DELETE, OPTIONS")
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid VECVIZ_CORS_WRITE_ORIGINS: %w", err)
+	}
+	return read, write, nil
+}
+
+// newCORSPolicy builds a policy from a comma-separated origin allowlist.
+// "*" allows any origin, but cannot be combined with credentials.
+func newCORSPolicy(origins string, allowCredentials bool, methods string) (*corsPolicy, error) {
+	p := &corsPolicy{
+		origins:          make(map[string]bool),
+		allowCredentials: allowCredentials,
+		methods:          methods,
+	}
+	for _, origin := range strings.Split(origins, ",") {
+		origin = strings.TrimSpace(origin)
+		switch origin {
+		case "":
+		case "*":
+			p.allowAny = true
+		default:
+			p.origins[origin] = true
+		}
+	}
+
+	if p.allowAny && allowCredentials {
+		return nil, fmt.Errorf("wildcard origin cannot be used with credentials")
+	}
+	return p, nil
+}
+
+// allows reports whether the policy lets origin call its routes. A nil
+// policy allows no cross-origin callers.
+func (p *corsPolicy) allows(origin string) bool {
+	return p != nil && (p.allowAny || p.origins[origin])
+}
+
+// wrap adds CORS headers for allowed origins and answers preflight requests.
+// A nil policy passes every request straight through.
+func (p *corsPolicy) wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if p == nil || origin == "" {
+			next(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		allowed := p.allows(origin)
+		if allowed {
+			if p.allowAny {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+			if p.allowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+
+		// Preflight
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			if !allowed {
+				writeJSONError(w, http.StatusForbidden, "Origin not allowed")
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Methods", p.methods)
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Idempotency-Key")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next(w, r)
+	}
+}
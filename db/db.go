@@ -1,179 +1,1190 @@
 package db
 
 import (
-	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
 	"database/sql"
 	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	sqlite_vec "github.com/asg017/sqlite-vec-go-bindings/cgo"
 	_ "github.com/mattn/go-sqlite3"
 )
 
-// deserializeFloat32 converts a BLOB back to []float32
-func deserializeFloat32(blob []byte) ([]float32, error) {
-	if len(blob)%4 != 0 {
-		return nil, nil
-	}
-	vector := make([]float32, len(blob)/4)
-	reader := bytes.NewReader(blob)
-	err := binary.Read(reader, binary.LittleEndian, &vector)
-	if err != nil {
-		return nil, err
+// ErrPromptNotFound is returned when no prompt exists for the given ID
+var ErrPromptNotFound = errors.New("prompt not found")
+
+// ErrEmbeddingNotFound is returned when a prompt has no stored embedding
+var ErrEmbeddingNotFound = errors.New("embedding not found")
+
+// ErrEmbeddingExists is returned when a prompt already has a stored embedding
+var ErrEmbeddingExists = errors.New("embedding already exists")
+
+// ErrPromptConflict is returned when an explicit prompt ID clashes with existing data
+var ErrPromptConflict = errors.New("prompt id conflict")
+
+// ErrPromptTextExists is returned when renaming a prompt to text another prompt already has
+var ErrPromptTextExists = errors.New("prompt text already exists")
+
+// ErrNoProjectedNeighbors is returned when a vector has no projected neighbors to place it near
+var ErrNoProjectedNeighbors = errors.New("no projected neighbors")
+
+// Dimension is the length of the vectors the embeddings table stores
+const Dimension = 3072
+
+// busyTimeout is how long a connection waits for another writer's lock before giving up
+const busyTimeout = 5 * time.Second
+
+// maxOpenConns bounds the connection pool; see Open for why more don't help writers
+const maxOpenConns = 8
+
+// sqliteTimeLayout matches what CURRENT_TIMESTAMP stores, so timestamps
+// written from Go sort alongside ones SQLite fills in
+const sqliteTimeLayout = "2006-01-02 15:04:05"
+
+// placementNeighbors is how many nearest projected neighbors ProjectNewPoint averages over
+const placementNeighbors = 5
+
+// Distance metrics the embeddings table can be created with
+const (
+	MetricL2     = "l2"
+	MetricCosine = "cosine"
+)
+
+// DefaultChunkSize is sqlite-vec's own default for how many vectors the
+// embeddings table stores per chunk
+const DefaultChunkSize = 1024
+
+// maxChunkSize is the largest chunk_size sqlite-vec accepts
+const maxChunkSize = 4096
+
+// Store is an open vecviz database. Every query goes through a Store, so
+// several can be open at once, each with its own file.
+type Store struct {
+	db *sql.DB
+
+	// Metric is the distance metric of the embeddings table. KNN distances
+	// from SearchNearest and NearestDistance use it.
+	Metric string
+
+	// Storage is the format the embeddings table keeps vectors in,
+	// StorageFloat32 or StorageInt8. Reads return float32 either way.
+	Storage string
+
+	// Model is recorded as the model of every embedding InsertEmbedding and
+	// ReplaceEmbedding store. Set it to the embedder's model; "" records none.
+	Model string
+
+	// ChunkSize is how many vectors the embeddings table stores per chunk
+	ChunkSize int
+
+	// normalization is how prompts are compared for duplicates; see
+	// SetPromptNormalization
+	normalization string
+
+	// graphGeneration counts changes to embeddings, so BuildNeighborGraph
+	// can tell whether one happened while it ran
+	graphGeneration atomic.Int64
+
+	// setHash is the last EmbeddingSetHash and the embedding generation it
+	// was computed at
+	setHash struct {
+		sync.Mutex
+		generation int64
+		hash       string
 	}
-	return vector, nil
 }
 
-var DB *sql.DB
+// MemoryPath opens a private in-memory database when passed to Open
+const MemoryPath = ":memory:"
+
+// Open opens the database and creates the schema. metric chooses the
+// embeddings table's distance metric ("l2" or "cosine"); "" means l2 for a
+// new database, or whatever an existing database was created with. storage
+// likewise chooses StorageFloat32 (the default) or StorageInt8, which
+// needs cosine and makes it the default. chunkSize sets sqlite-vec's
+// chunk_size, 0 meaning DefaultChunkSize for a new database. All three are
+// fixed at creation, so asking an existing database for different ones is an
+// error.
+//
+// Every chunk's storage is allocated in full when its first vector is
+// written, and KNN queries scan chunk by chunk. Larger chunks mean fewer,
+// bigger reads per query but a bigger up-front allocation (chunkSize * 12KiB
+// at 3072 float32 dimensions); smaller ones keep small databases small and
+// the insert that opens a chunk cheap, at the cost of more reads per query.
+//
+// dbPath may be MemoryPath for a database that lives only as long as the
+// Store, e.g. in tests. Each such Store is isolated from every other.
+func Open(dbPath, metric, storage string, chunkSize int) (*Store, error) {
+	if metric != "" && metric != MetricL2 && metric != MetricCosine {
+		return nil, fmt.Errorf("unknown distance metric %q, expected %s or %s", metric, MetricL2, MetricCosine)
+	}
+	if storage != "" && storage != StorageFloat32 && storage != StorageInt8 {
+		return nil, fmt.Errorf("unknown storage %q, expected %s or %s", storage, StorageFloat32, StorageInt8)
+	}
+	if storage == StorageInt8 && metric == MetricL2 {
+		return nil, fmt.Errorf("%s storage scales each vector separately, which distorts %s distances; use %s", StorageInt8, MetricL2, MetricCosine)
+	}
+	if chunkSize < 0 || chunkSize%8 != 0 || chunkSize > maxChunkSize {
+		return nil, fmt.Errorf("invalid chunk size %d, expected a positive multiple of 8 up to %d", chunkSize, maxChunkSize)
+	}
 
-func Init(dbPath string) error {
 	sqlite_vec.Auto()
 
-	var err error
-	DB, err = sql.Open("sqlite3", dbPath)
+	// WAL lets readers run alongside a writer, and the busy timeout makes a
+	// second writer wait for the lock instead of failing with "database is
+	// locked". Immediate transactions take the write lock up front, so two
+	// transactions that read then write can't deadlock upgrading their locks.
+	dsn := fmt.Sprintf("file:%s?_journal_mode=WAL&_busy_timeout=%d&_txlock=immediate", dbPath, busyTimeout.Milliseconds())
+
+	// SQLite allows only one writer at a time even in WAL mode, so for writes
+	// the pool is effectively a single connection: extra writers just wait on
+	// the busy timeout. The remaining connections exist for concurrent readers
+	// such as /points or a streaming /export.
+	conns := maxOpenConns
+	if dbPath == MemoryPath {
+		// Every connection to :memory: gets its own empty database, so an
+		// in-memory Store keeps exactly one connection open for its lifetime.
+		// Queries then run one at a time.
+		dsn = "file::memory:?_txlock=immediate"
+		conns = 1
+	}
+
+	sqlDB, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(conns)
+	sqlDB.SetMaxIdleConns(conns)
+
+	s := &Store{db: sqlDB}
+	if err := s.init(metric, storage, chunkSize); err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// init creates the schema and settles the metric, storage and chunk size
+func (s *Store) init(metric, storage string, chunkSize int) error {
+	// vec0 fixes the metric when the table is created, so an existing table
+	// keeps the one recorded in meta (databases from before it was recorded are l2)
+	var tableExists bool
+	err := s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE name = 'embeddings')").Scan(&tableExists)
 	if err != nil {
 		return err
 	}
+	createStorage := storage
+	if createStorage == "" {
+		createStorage = StorageFloat32
+	}
+	createMetric := metric
+	if createMetric == "" {
+		createMetric = MetricL2
+		if createStorage == StorageInt8 {
+			createMetric = MetricCosine
+		}
+	}
+	columnType := "float"
+	if createStorage == StorageInt8 {
+		columnType = "int8"
+	}
+	createChunkSize := chunkSize
+	if createChunkSize == 0 {
+		createChunkSize = DefaultChunkSize
+	}
 
 	// Create schema
-	schema := `
+	schema := fmt.Sprintf(`
 	CREATE TABLE IF NOT EXISTS prompts (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		text TEXT NOT NULL UNIQUE,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		deleted_at DATETIME,
+		dedup_key TEXT
 	);
 
 	CREATE VIRTUAL TABLE IF NOT EXISTS embeddings USING vec0(
 		prompt_id INTEGER PRIMARY KEY,
-		embedding float[3072]
+		embedding %s[%d] distance_metric=%s,
+		chunk_size=%d
+	);
+
+	CREATE TABLE IF NOT EXISTS projections (
+		prompt_id INTEGER PRIMARY KEY,
+		x REAL NOT NULL,
+		y REAL NOT NULL,
+		z REAL NOT NULL,
+		coords BLOB,
+		norm REAL,
+		cluster INTEGER,
+		FOREIGN KEY (prompt_id) REFERENCES prompts(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS meta (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS tags (
+		prompt_id INTEGER NOT NULL,
+		tag TEXT NOT NULL,
+		PRIMARY KEY (prompt_id, tag),
+		FOREIGN KEY (prompt_id) REFERENCES prompts(id) ON DELETE CASCADE
+	);
+
+	CREATE INDEX IF NOT EXISTS tags_tag ON tags (tag);
+
+	CREATE TABLE IF NOT EXISTS metadata (
+		prompt_id INTEGER NOT NULL,
+		key TEXT NOT NULL,
+		value TEXT NOT NULL,
+		PRIMARY KEY (prompt_id, key),
+		FOREIGN KEY (prompt_id) REFERENCES prompts(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS embed_queue (
+		prompt_id INTEGER PRIMARY KEY,
+		context TEXT NOT NULL DEFAULT '',
+		enqueued_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		claimed INTEGER NOT NULL DEFAULT 0,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		reembed INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE TABLE IF NOT EXISTS embedding_meta (
+		prompt_id INTEGER PRIMARY KEY,
+		context TEXT NOT NULL DEFAULT '',
+		embedded_at DATETIME,
+		scale REAL,
+		model TEXT
+	);
+
+	CREATE TABLE IF NOT EXISTS idempotency (
+		key TEXT PRIMARY KEY,
+		request_hash TEXT NOT NULL,
+		prompt_id INTEGER NOT NULL,
+		status INTEGER NOT NULL,
+		response BLOB NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS neighbors (
+		prompt_id INTEGER NOT NULL,
+		rank INTEGER NOT NULL,
+		neighbor_id INTEGER NOT NULL,
+		distance REAL NOT NULL,
+		PRIMARY KEY (prompt_id, rank)
+	);
+
+	CREATE TABLE IF NOT EXISTS projection_history (
+		run_id INTEGER NOT NULL,
+		prompt_id INTEGER NOT NULL,
+		x REAL NOT NULL,
+		y REAL NOT NULL,
+		z REAL NOT NULL,
+		PRIMARY KEY (run_id, prompt_id)
 	);
 
-	CREATE TABLE IF NOT EXISTS projections (
-		prompt_id INTEGER PRIMARY KEY,
-		x REAL NOT NULL,
-		y REAL NOT NULL,
-		z REAL NOT NULL,
-		FOREIGN KEY (prompt_id) REFERENCES prompts(id) ON DELETE CASCADE
-	);
-	`
+	CREATE TABLE IF NOT EXISTS tsne_runs (
+		run_id INTEGER PRIMARY KEY,
+		method TEXT NOT NULL,
+		params TEXT NOT NULL,
+		status TEXT NOT NULL,
+		points INTEGER NOT NULL,
+		started_at DATETIME NOT NULL,
+		duration_ms INTEGER,
+		history TEXT,
+		error TEXT
+	);
+	`, columnType, Dimension, createMetric, createChunkSize)
+
+	if _, err := s.db.Exec(schema); err != nil {
+		return err
+	}
+	if err := s.migrate(); err != nil {
+		return err
+	}
+	// A run still marked running was cut short when the server last stopped
+	if _, err := s.db.Exec("UPDATE tsne_runs SET status = ? WHERE status = ?", RunInterrupted, RunRunning); err != nil {
+		return err
+	}
+	if err := s.initNormalization(); err != nil {
+		return err
+	}
+
+	if !tableExists {
+		s.Metric, s.Storage, s.ChunkSize = createMetric, createStorage, createChunkSize
+		if err := s.SetMeta("distance_metric", s.Metric); err != nil {
+			return err
+		}
+		if err := s.SetMeta("chunk_size", strconv.Itoa(s.ChunkSize)); err != nil {
+			return err
+		}
+		return s.SetMeta("storage", s.Storage)
+	}
+	stored, err := s.GetMeta("distance_metric")
+	if err != nil {
+		return err
+	}
+	if stored == "" {
+		stored = MetricL2
+	}
+	if metric != "" && metric != stored {
+		return fmt.Errorf("database uses %s distance and cannot be switched to %s without re-creating it", stored, metric)
+	}
+	s.Metric = stored
+
+	// Databases from before storage was recorded hold float32
+	storedStorage, err := s.GetMeta("storage")
+	if err != nil {
+		return err
+	}
+	if storedStorage == "" {
+		storedStorage = StorageFloat32
+	}
+	if storage != "" && storage != storedStorage {
+		return fmt.Errorf("database stores %s embeddings and cannot be switched to %s without re-creating it", storedStorage, storage)
+	}
+	s.Storage = storedStorage
+
+	// Databases from before the chunk size was recorded use sqlite-vec's default
+	storedChunkSize := DefaultChunkSize
+	if v, err := s.GetMeta("chunk_size"); err != nil {
+		return err
+	} else if v != "" {
+		if storedChunkSize, err = strconv.Atoi(v); err != nil {
+			return fmt.Errorf("corrupt chunk_size %q in meta: %w", v, err)
+		}
+	}
+	if chunkSize != 0 && chunkSize != storedChunkSize {
+		return fmt.Errorf("database uses chunk size %d and cannot be switched to %d without re-creating it", storedChunkSize, chunkSize)
+	}
+	s.ChunkSize = storedChunkSize
+	return nil
+}
+
+// migrate upgrades databases created by older versions
+func (s *Store) migrate() error {
+	if err := s.migrateEmbeddedAt(); err != nil {
+		return err
+	}
+	// Columns added after the first release, NULL or zero in existing rows:
+	// prompts.deleted_at for soft deletes, projections.norm until the next
+	// projection run, projections.cluster until the next /cluster,
+	// embedding_meta.scale, which only int8 storage uses,
+	// embedding_meta.model, unknown for vectors stored before it,
+	// embed_queue.reembed for prompts queued by /reembed,
+	// prompts.dedup_key, which initNormalization fills in,
+	// projections.coords, for runs of other than 3 dimensions, and
+	// prompts.color, set by hand
+	if err := s.addColumnIfMissing("prompts", "deleted_at", "DATETIME"); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing("prompts", "dedup_key", "TEXT"); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing("prompts", "color", "TEXT"); err != nil {
+		return err
+	}
+	if _, err := s.db.Exec("CREATE INDEX IF NOT EXISTS prompts_dedup_key ON prompts (dedup_key)"); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing("embedding_meta", "scale", "REAL"); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing("embedding_meta", "model", "TEXT"); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing("embed_queue", "reembed", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing("projections", "norm", "REAL"); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing("projections", "coords", "BLOB"); err != nil {
+		return err
+	}
+	return s.addColumnIfMissing("projections", "cluster", "INTEGER")
+}
+
+// addColumnIfMissing adds a column to a table created by an older version
+func (s *Store) addColumnIfMissing(table, column, decl string) error {
+	var exists bool
+	err := s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM pragma_table_info(?) WHERE name = ?)", table, column).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+	_, err = s.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, decl))
+	return err
+}
+
+// migrateEmbeddedAt upgrades databases created before embedding_meta.embedded_at existed
+func (s *Store) migrateEmbeddedAt() error {
+	var exists bool
+	err := s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM pragma_table_info('embedding_meta') WHERE name = 'embedded_at')").Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("ALTER TABLE embedding_meta ADD COLUMN embedded_at DATETIME"); err != nil {
+		return err
+	}
+	// The real embedding time is unknown, so the prompt's creation time is the best guess
+	_, err = tx.Exec(`
+		INSERT INTO embedding_meta (prompt_id, embedded_at)
+		SELECT e.prompt_id, pr.created_at
+		FROM embeddings e
+		JOIN prompts pr ON pr.id = e.prompt_id
+		WHERE true
+		ON CONFLICT(prompt_id) DO UPDATE SET embedded_at = excluded.embedded_at
+	`)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Close closes the database handle
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Ping checks that the database answers a trivial query
+func (s *Store) Ping(ctx context.Context) error {
+	var one int
+	return s.db.QueryRowContext(ctx, "SELECT 1").Scan(&one)
+}
+
+// InsertPrompt inserts a prompt and returns its ID. If the prompt already exists, returns existing ID.
+// Whether it exists is judged by the store's prompt normalization, so with
+// NormalizeWhitespace "foo  bar " finds a stored "foo bar" and keeps its text.
+func (s *Store) InsertPrompt(text string) (int64, error) {
+	id, _, err := s.EnsurePrompt(text)
+	return id, err
+}
+
+// EnsurePrompt is InsertPrompt, also reporting whether the prompt was newly
+// created. A write that finds the database locked is retried once.
+func (s *Store) EnsurePrompt(text string) (id int64, created bool, err error) {
+	err = withRetry(func() error {
+		id, created, err = s.ensurePrompt(text)
+		return err
+	})
+	return id, created, err
+}
+
+// ensurePrompt does the work of EnsurePrompt
+func (s *Store) ensurePrompt(text string) (id int64, created bool, err error) {
+	key := s.dedupKey(text)
+
+	// Check if prompt exists. Looking first avoids using up an AUTOINCREMENT
+	// ID on every resubmission, which a conflicting insert does.
+	err = s.db.QueryRow("SELECT id FROM prompts WHERE dedup_key = ? ORDER BY id LIMIT 1", key).Scan(&id)
+	if err == nil {
+		return id, false, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, false, err
+	}
+
+	// The transaction holds the write lock, so two concurrent callers with
+	// texts that normalize alike end up with the one stored row
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+	err = tx.QueryRow("SELECT id FROM prompts WHERE dedup_key = ? ORDER BY id LIMIT 1", key).Scan(&id)
+	if err == nil {
+		return id, false, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, false, err
+	}
+	result, err := tx.Exec("INSERT INTO prompts (text, dedup_key) VALUES (?, ?)", text, key)
+	if err != nil {
+		return 0, false, err
+	}
+	if id, err = result.LastInsertId(); err != nil {
+		return 0, false, err
+	}
+	return id, true, tx.Commit()
+}
+
+// InsertPromptWithID inserts a prompt under an explicit ID, for keeping IDs aligned
+// with an external system. Re-inserting the same ID and text is a no-op; an ID
+// holding different text, or text stored under a different ID, is an ErrPromptConflict.
+// Text is compared as InsertPrompt compares it, and created reports whether
+// the prompt is new.
+func (s *Store) InsertPromptWithID(id int64, text string) (created bool, err error) {
+	key := s.dedupKey(text)
+
+	var existingText string
+	err = s.db.QueryRow("SELECT text FROM prompts WHERE id = ?", id).Scan(&existingText)
+	if err == nil {
+		if s.dedupKey(existingText) != key {
+			return false, fmt.Errorf("%w: id %d is already used by a different prompt", ErrPromptConflict, id)
+		}
+		return false, nil
+	}
+	if err != sql.ErrNoRows {
+		return false, err
+	}
+
+	var existingID int64
+	err = s.db.QueryRow("SELECT id FROM prompts WHERE dedup_key = ? ORDER BY id LIMIT 1", key).Scan(&existingID)
+	if err == nil {
+		return false, fmt.Errorf("%w: prompt is already stored with id %d", ErrPromptConflict, existingID)
+	}
+	if err != sql.ErrNoRows {
+		return false, err
+	}
+
+	if _, err := s.db.Exec("INSERT INTO prompts (id, text, dedup_key) VALUES (?, ?, ?)", id, text, key); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// GetPromptText returns the text of a prompt
+func (s *Store) GetPromptText(ctx context.Context, id int64) (string, error) {
+	var text string
+	err := s.db.QueryRowContext(ctx, "SELECT text FROM prompts WHERE id = ?", id).Scan(&text)
+	if err == sql.ErrNoRows {
+		return "", ErrPromptNotFound
+	}
+	return text, err
+}
+
+// ErrDimensionMismatch is returned when a vector's length isn't Dimension
+var ErrDimensionMismatch = errors.New("embedding dimension mismatch")
+
+// checkDimension returns an ErrDimensionMismatch naming the expected and actual length
+func checkDimension(vector []float32) error {
+	if len(vector) != Dimension {
+		return fmt.Errorf("%w: got %d values, expected %d", ErrDimensionMismatch, len(vector), Dimension)
+	}
+	return nil
+}
+
+// recordEmbeddingMeta stamps when a prompt's embedding was stored, with the
+// scale encodeVector returned for it and the model that produced it ("" for
+// unknown). It runs alongside every embedding insert.
+const recordEmbeddingMeta = `
+	INSERT INTO embedding_meta (prompt_id, embedded_at, scale, model) VALUES (?, ?, ?, NULLIF(?, ''))
+	ON CONFLICT(prompt_id) DO UPDATE SET embedded_at = excluded.embedded_at, scale = excluded.scale, model = excluded.model
+`
+
+// InsertEmbedding stores a Dimension-length embedding for a prompt.
+// It returns ErrEmbeddingExists if the prompt already has one, and
+// ErrDimensionMismatch for a vector of any other length. A write that finds
+// the database locked is retried once.
+func (s *Store) InsertEmbedding(promptID int64, embedding []float32) error {
+	if err := checkDimension(embedding); err != nil {
+		return err
+	}
+	serialized, scale, err := s.encodeVector(embedding)
+	if err != nil {
+		return err
+	}
+	return withRetry(func() error { return s.insertEmbedding(promptID, serialized, scale) })
+}
+
+// insertEmbedding does the work of InsertEmbedding with the encoded vector
+func (s *Store) insertEmbedding(promptID int64, serialized []byte, scale sql.NullFloat64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("INSERT INTO embeddings (prompt_id, embedding) VALUES (?, "+s.vectorArg()+")", promptID, serialized); err != nil {
+		tx.Rollback()
+		// vec0 reports every failure as a generic SQL error, so look for the
+		// duplicate directly rather than matching on the message
+		if exists, existsErr := s.HasEmbedding(context.Background(), promptID); existsErr == nil && exists {
+			return ErrEmbeddingExists
+		}
+		return err
+	}
+	if _, err := tx.Exec(recordEmbeddingMeta, promptID, time.Now().UTC().Format(sqliteTimeLayout), scale, s.Model); err != nil {
+		return err
+	}
+	if err := s.clearNeighborGraph(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReplaceEmbedding stores a prompt's embedding, replacing any existing one
+func (s *Store) ReplaceEmbedding(promptID int64, embedding []float32) error {
+	if err := checkDimension(embedding); err != nil {
+		return err
+	}
+	serialized, scale, err := s.encodeVector(embedding)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// vec0 has no upsert, so delete and insert in one transaction
+	if _, err := tx.Exec("DELETE FROM embeddings WHERE prompt_id = ?", promptID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO embeddings (prompt_id, embedding) VALUES (?, "+s.vectorArg()+")", promptID, serialized); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(recordEmbeddingMeta, promptID, time.Now().UTC().Format(sqliteTimeLayout), scale, s.Model); err != nil {
+		return err
+	}
+	if err := s.clearNeighborGraph(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// InsertEmbeddings stores several embeddings in a single transaction. Each
+// records its own Model rather than the Store's, since imported vectors may
+// come from anywhere. A prompt that already has an embedding, including one
+// stored earlier in the same call, is skipped rather than failing the whole
+// batch, and its ID is returned in skipped.
+func (s *Store) InsertEmbeddings(embeddings []EmbeddingData) (skipped []int64, err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare("INSERT INTO embeddings (prompt_id, embedding) VALUES (?, " + s.vectorArg() + ")")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	// Checked in the transaction, since another connection's view could be stale
+	exists, err := tx.Prepare("SELECT EXISTS(SELECT 1 FROM embeddings WHERE prompt_id = ?)")
+	if err != nil {
+		return nil, err
+	}
+	defer exists.Close()
+
+	embeddedAt := time.Now().UTC().Format(sqliteTimeLayout)
+	for _, e := range embeddings {
+		if err := checkDimension(e.Vector); err != nil {
+			return nil, fmt.Errorf("prompt %d: %w", e.PromptID, err)
+		}
+		var found bool
+		if err := exists.QueryRow(e.PromptID).Scan(&found); err != nil {
+			return nil, err
+		}
+		if found {
+			skipped = append(skipped, e.PromptID)
+			continue
+		}
+		serialized, scale, err := s.encodeVector(e.Vector)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := stmt.Exec(e.PromptID, serialized); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(recordEmbeddingMeta, e.PromptID, embeddedAt, scale, e.Model); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.clearNeighborGraph(tx); err != nil {
+		return nil, err
+	}
+
+	return skipped, tx.Commit()
+}
+
+// HasEmbedding reports whether an embedding is stored for a prompt
+func (s *Store) HasEmbedding(ctx context.Context, promptID int64) (bool, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM embeddings WHERE prompt_id = ?)", promptID).Scan(&exists)
+	return exists, err
+}
+
+// SetEmbeddingContext records the system prompt or template used to generate a prompt's embedding
+func (s *Store) SetEmbeddingContext(promptID int64, context string) error {
+	_, err := s.db.Exec(`
+		INSERT INTO embedding_meta (prompt_id, context) VALUES (?, ?)
+		ON CONFLICT(prompt_id) DO UPDATE SET context = excluded.context
+	`, promptID, context)
+	return err
+}
+
+// GetEmbeddingContext returns the recorded embedding context for a prompt, or "" if none was given
+func (s *Store) GetEmbeddingContext(ctx context.Context, promptID int64) (string, error) {
+	var context string
+	err := s.db.QueryRowContext(ctx, "SELECT context FROM embedding_meta WHERE prompt_id = ?", promptID).Scan(&context)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return context, err
+}
+
+// GetEmbeddedAt returns when a prompt's embedding was stored, or the zero time if unknown
+func (s *Store) GetEmbeddedAt(ctx context.Context, promptID int64) (time.Time, error) {
+	var embeddedAt sql.NullTime
+	err := s.db.QueryRowContext(ctx, "SELECT embedded_at FROM embedding_meta WHERE prompt_id = ?", promptID).Scan(&embeddedAt)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	return embeddedAt.Time, err
+}
+
+// GetEmbeddedTimes returns when each stored embedding was created, keyed by prompt ID
+func (s *Store) GetEmbeddedTimes(ctx context.Context) (map[int64]time.Time, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT prompt_id, embedded_at FROM embedding_meta WHERE embedded_at IS NOT NULL")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	times := make(map[int64]time.Time)
+	for rows.Next() {
+		var id int64
+		var t time.Time
+		if err := rows.Scan(&id, &t); err != nil {
+			return nil, err
+		}
+		times[id] = t
+	}
+	return times, rows.Err()
+}
+
+// EmbeddingData holds an embedding with its prompt ID
+type EmbeddingData struct {
+	PromptID int64
+	Vector   []float32
+	// Model is the model that produced Vector, or "" if unknown. Only
+	// InsertEmbeddings reads it.
+	Model string
+}
+
+// GetEmbedding returns the stored embedding for a prompt, or ErrEmbeddingNotFound
+func (s *Store) GetEmbedding(ctx context.Context, promptID int64) ([]float32, error) {
+	var blob []byte
+	var scale sql.NullFloat64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT e.embedding, m.scale
+		FROM embeddings e
+		LEFT JOIN embedding_meta m ON m.prompt_id = e.prompt_id
+		WHERE e.prompt_id = ?
+	`, promptID).Scan(&blob, &scale)
+	if err == sql.ErrNoRows {
+		return nil, ErrEmbeddingNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	vector, err := s.decodeVector(blob, scale)
+	if err != nil {
+		return nil, fmt.Errorf("prompt %d: %w", promptID, err)
+	}
+	return vector, nil
+}
+
+// embeddingGenerationKey is the meta key counting changes to the set of
+// embeddings EmbeddingSetHash covers
+const embeddingGenerationKey = "embedding_generation"
 
-	_, err = DB.Exec(schema)
+// bumpEmbeddingGeneration records, as part of tx, that the embeddings or
+// which of them are active changed
+func bumpEmbeddingGeneration(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		INSERT INTO meta (key, value) VALUES (?, '1')
+		ON CONFLICT(key) DO UPDATE SET value = CAST(value AS INTEGER) + 1
+	`, embeddingGenerationKey)
 	return err
 }
 
-// InsertPrompt inserts a prompt and returns its ID. If the prompt already exists, returns existing ID.
-func InsertPrompt(text string) (int64, error) {
-	// Check if prompt exists
-	var id int64
-	err := DB.QueryRow("SELECT id FROM prompts WHERE text = ?", text).Scan(&id)
-	if err == nil {
-		return id, nil
+// embeddingGeneration returns the count bumpEmbeddingGeneration keeps
+func (s *Store) embeddingGeneration(ctx context.Context) (int64, error) {
+	var generation int64
+	err := s.db.QueryRowContext(ctx, "SELECT COALESCE((SELECT CAST(value AS INTEGER) FROM meta WHERE key = ?), 0)", embeddingGenerationKey).Scan(&generation)
+	return generation, err
+}
+
+// EmbeddingSetHash returns a hex SHA-256 over every stored prompt ID and
+// embedding in ID order, skipping soft-deleted prompts like GetAllEmbeddings.
+// It changes whenever an embedding is added, removed or replaced, or its
+// prompt is soft-deleted or restored. Quantized vectors are hashed as the
+// float32 GetAllEmbeddings returns, so the hash matches HashEmbeddings.
+//
+// Hashing reads every vector, so the result is kept until the embedding
+// generation moves on, which every write that changes the set does in its
+// own transaction. Callers checking staleness on each request then cost a
+// single meta lookup.
+func (s *Store) EmbeddingSetHash(ctx context.Context) (string, error) {
+	generation, err := s.embeddingGeneration(ctx)
+	if err != nil {
+		return "", err
 	}
-	if err != sql.ErrNoRows {
-		return 0, err
+	s.setHash.Lock()
+	cachedGeneration, cachedHash := s.setHash.generation, s.setHash.hash
+	s.setHash.Unlock()
+	if cachedHash != "" && cachedGeneration == generation {
+		return cachedHash, nil
 	}
 
-	// Insert new prompt
-	result, err := DB.Exec("INSERT INTO prompts (text) VALUES (?)", text)
+	hash, err := s.hashEmbeddingSet(ctx)
 	if err != nil {
-		return 0, err
+		return "", err
+	}
+
+	// A write committed during the scan may or may not be in the hash, so
+	// only keep one that is known to match its generation
+	after, err := s.embeddingGeneration(ctx)
+	if err != nil {
+		return "", err
+	}
+	if after == generation {
+		s.setHash.Lock()
+		s.setHash.generation, s.setHash.hash = generation, hash
+		s.setHash.Unlock()
 	}
-	return result.LastInsertId()
+	return hash, nil
 }
 
-// InsertEmbedding stores a 3072-dim embedding for a prompt
-func InsertEmbedding(promptID int64, embedding []float32) error {
-	serialized, err := sqlite_vec.SerializeFloat32(embedding)
+// hashEmbeddingSet computes EmbeddingSetHash. Rows are hashed as they are
+// read, so vectors are never all in memory at once.
+func (s *Store) hashEmbeddingSet(ctx context.Context) (string, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT e.prompt_id, e.embedding, m.scale
+		FROM embeddings e
+		JOIN prompts pr ON pr.id = e.prompt_id
+		LEFT JOIN embedding_meta m ON m.prompt_id = e.prompt_id
+		WHERE pr.deleted_at IS NULL
+		ORDER BY e.prompt_id
+	`)
 	if err != nil {
-		return err
+		return "", err
 	}
+	defer rows.Close()
 
-	_, err = DB.Exec("INSERT INTO embeddings (prompt_id, embedding) VALUES (?, ?)", promptID, serialized)
-	return err
+	h := sha256.New()
+	for rows.Next() {
+		var promptID int64
+		var blob []byte
+		var scale sql.NullFloat64
+		if err := rows.Scan(&promptID, &blob, &scale); err != nil {
+			return "", err
+		}
+		if s.Storage == StorageInt8 {
+			blob = serializeFloat32(dequantizeInt8(blob, scale.Float64))
+		}
+		hashEmbedding(h, promptID, blob)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
-// EmbeddingData holds an embedding with its prompt ID
-type EmbeddingData struct {
-	PromptID int64
-	Vector   []float32
+// HashEmbeddings returns the same hash as EmbeddingSetHash for an in-memory
+// set of embeddings, in any order
+func HashEmbeddings(embeddings []EmbeddingData) (string, error) {
+	sorted := slices.Clone(embeddings)
+	slices.SortFunc(sorted, func(a, b EmbeddingData) int { return cmp.Compare(a.PromptID, b.PromptID) })
+
+	h := NewEmbeddingHasher()
+	for _, e := range sorted {
+		h.Add(e)
+	}
+	return h.Sum(), nil
+}
+
+// EmbeddingHasher builds the EmbeddingSetHash of embeddings added one at a
+// time, for callers that stream them with ForEachEmbedding. They must be
+// added in prompt ID order.
+type EmbeddingHasher struct {
+	h hash.Hash
+}
+
+// NewEmbeddingHasher returns an EmbeddingHasher with nothing added
+func NewEmbeddingHasher() *EmbeddingHasher {
+	return &EmbeddingHasher{h: sha256.New()}
+}
+
+// Add hashes the next embedding
+func (e *EmbeddingHasher) Add(d EmbeddingData) {
+	hashEmbedding(e.h, d.PromptID, serializeFloat32(d.Vector))
+}
+
+// Sum returns the hex hash of the embeddings added so far
+func (e *EmbeddingHasher) Sum() string {
+	return hex.EncodeToString(e.h.Sum(nil))
+}
+
+// hashEmbedding feeds one prompt ID and serialized vector into an embedding set hash
+func hashEmbedding(h hash.Hash, promptID int64, blob []byte) {
+	var idBuf [8]byte
+	binary.LittleEndian.PutUint64(idBuf[:], uint64(promptID))
+	h.Write(idBuf[:])
+	h.Write(blob)
+}
+
+// GetAllEmbeddings retrieves all embeddings for t-SNE computation, leaving
+// out soft-deleted prompts. They come in prompt ID order, so a reducer given
+// the same embeddings and seed sees the same input on every run.
+func (s *Store) GetAllEmbeddings(ctx context.Context) ([]EmbeddingData, error) {
+	var results []EmbeddingData
+	err := s.ForEachEmbedding(ctx, func(e EmbeddingData) error {
+		results = append(results, e)
+		return nil
+	})
+	return results, err
 }
 
-// GetAllEmbeddings retrieves all embeddings for t-SNE computation
-func GetAllEmbeddings() ([]EmbeddingData, error) {
-	rows, err := DB.Query("SELECT prompt_id, embedding FROM embeddings")
+// ForEachEmbedding calls fn for every embedding GetAllEmbeddings returns, in
+// prompt ID order, reading rows one at a time so the set never has to fit in
+// memory. An error from fn stops the iteration and is returned. The rows stay
+// open while fn runs, so with a MemoryPath store fn must not query the store.
+func (s *Store) ForEachEmbedding(ctx context.Context, fn func(EmbeddingData) error) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT e.prompt_id, e.embedding, m.scale
+		FROM embeddings e
+		JOIN prompts pr ON pr.id = e.prompt_id
+		LEFT JOIN embedding_meta m ON m.prompt_id = e.prompt_id
+		WHERE pr.deleted_at IS NULL
+		ORDER BY e.prompt_id
+	`)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
 
-	var results []EmbeddingData
 	for rows.Next() {
 		var promptID int64
 		var blob []byte
-		if err := rows.Scan(&promptID, &blob); err != nil {
-			return nil, err
+		var scale sql.NullFloat64
+		if err := rows.Scan(&promptID, &blob, &scale); err != nil {
+			return err
 		}
 
-		vector, err := deserializeFloat32(blob)
+		vector, err := s.decodeVector(blob, scale)
 		if err != nil {
-			return nil, err
+			return fmt.Errorf("prompt %d: %w", promptID, err)
 		}
 
-		results = append(results, EmbeddingData{
-			PromptID: promptID,
-			Vector:   vector,
-		})
+		if err := fn(EmbeddingData{PromptID: promptID, Vector: vector}); err != nil {
+			return err
+		}
 	}
-	return results, rows.Err()
+	return rows.Err()
 }
 
-// Projection holds 3D coordinates for a prompt
+// GetPromptCreationTimes returns when each prompt was created, keyed by prompt ID
+func (s *Store) GetPromptCreationTimes(ctx context.Context) (map[int64]time.Time, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, created_at FROM prompts")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	times := make(map[int64]time.Time)
+	for rows.Next() {
+		var id int64
+		var createdAt time.Time
+		if err := rows.Scan(&id, &createdAt); err != nil {
+			return nil, err
+		}
+		times[id] = createdAt
+	}
+	return times, rows.Err()
+}
+
+// Projection holds the coordinates of a prompt in the projected layout
 type Projection struct {
-	PromptID int64
-	Text     string
-	X        float64
-	Y        float64
-	Z        float64
+	PromptID  int64
+	Text      string
+	CreatedAt time.Time
+	// DeletedAt is when the prompt was soft-deleted, or zero if it wasn't
+	DeletedAt time.Time
+	// X, Y and Z are the first three axes, zero past the run's dimensions
+	X float64
+	Y float64
+	Z float64
+	// Coords holds every axis of the run, or nil if only X, Y and Z were
+	// stored (e.g. projections from before it existed, or an import)
+	Coords []float64
+	// Norm is the L2 norm of the prompt's embedding when it was projected,
+	// or zero if unknown (e.g. projections brought in by an import)
+	Norm float64
+	// Cluster is the k-means cluster from the last SetClusters, or NoCluster
+	Cluster int
+	// Model is the model that produced the prompt's embedding, or "" if unknown
+	Model string
+	// Color is the prompt's color from SetPromptColor, or "" if it has none
+	Color string
+}
+
+// NoCluster marks a projection that hasn't been clustered since it was computed
+const NoCluster = -1
+
+// InsertProjections stores projections for their prompts as the output of
+// run runID, from StartProjectionRun, which ProjectionRunID then returns. An
+// existing projection for one of the prompts is replaced; every other
+// projection is left as it is. A write that finds the database locked is
+// retried once.
+func (s *Store) InsertProjections(projections []Projection, runID int64) error {
+	return withRetry(func() error { return s.storeProjections(projections, runID, false) })
 }
 
-// InsertProjections stores 3D projections (replaces existing)
-func InsertProjections(projections []Projection) error {
-	tx, err := DB.Begin()
+// ReplaceAllProjections stores projections as a new run like
+// InsertProjections, but first deletes every existing projection, for a
+// recompute of the whole set
+func (s *Store) ReplaceAllProjections(projections []Projection, runID int64) error {
+	return withRetry(func() error { return s.storeProjections(projections, runID, true) })
+}
+
+// storeProjections does the work of InsertProjections and, with replaceAll,
+// ReplaceAllProjections
+func (s *Store) storeProjections(projections []Projection, runID int64, replaceAll bool) error {
+	tx, err := s.db.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	// Clear existing projections
-	_, err = tx.Exec("DELETE FROM projections")
+	if replaceAll {
+		if _, err := tx.Exec("DELETE FROM projections"); err != nil {
+			return err
+		}
+	}
+
+	// Insert new projections several rows per statement, which saves a
+	// round trip through the driver per row on large sets
+	var full *sql.Stmt
+	for start := 0; start < len(projections); start += projectionInsertChunk {
+		chunk := projections[start:min(start+projectionInsertChunk, len(projections))]
+		args := make([]interface{}, 0, 6*len(chunk))
+		for _, p := range chunk {
+			args = append(args, p.PromptID, p.X, p.Y, p.Z, serializeCoords(p.Coords), p.Norm)
+		}
+		if len(chunk) < projectionInsertChunk {
+			if _, err := tx.Exec(insertProjectionsSQL(len(chunk)), args...); err != nil {
+				return err
+			}
+			continue
+		}
+		if full == nil {
+			if full, err = tx.Prepare(insertProjectionsSQL(projectionInsertChunk)); err != nil {
+				return err
+			}
+			defer full.Close()
+		}
+		if _, err := full.Exec(args...); err != nil {
+			return err
+		}
+	}
+
+	// The run is what snapshots and /tsne/diff refer to
+	if _, err := tx.Exec(`
+		INSERT INTO meta (key, value) VALUES (?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value
+	`, projectionRunIDKey, strconv.FormatInt(runID, 10)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// projectionInsertChunk is how many rows storeProjections writes per
+// statement. At 6 parameters a row it stays well under SQLite's limit of
+// 32766 bound parameters.
+const projectionInsertChunk = 500
+
+// insertProjectionsSQL returns an INSERT of n projection rows. A row
+// replacing an existing projection leaves it unclustered, like a new one.
+func insertProjectionsSQL(n int) string {
+	const row = "(?, ?, ?, ?, ?, NULLIF(?, 0))"
+	return "INSERT INTO projections (prompt_id, x, y, z, coords, norm) VALUES " + strings.Repeat(row+", ", n-1) + row + `
+		ON CONFLICT(prompt_id) DO UPDATE SET
+			x = excluded.x, y = excluded.y, z = excluded.z,
+			coords = excluded.coords, norm = excluded.norm, cluster = NULL`
+}
+
+// SetClusters records each prompt's cluster, keyed by prompt ID. Every other
+// projection is left unclustered, so results from an earlier run don't linger.
+func (s *Store) SetClusters(clusters map[int64]int) error {
+	tx, err := s.db.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	// Insert new projections
-	stmt, err := tx.Prepare("INSERT INTO projections (prompt_id, x, y, z) VALUES (?, ?, ?, ?)")
+	if _, err := tx.Exec("UPDATE projections SET cluster = NULL"); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare("UPDATE projections SET cluster = ? WHERE prompt_id = ?")
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
-	for _, p := range projections {
-		_, err = stmt.Exec(p.PromptID, p.X, p.Y, p.Z)
-		if err != nil {
+	for id, cluster := range clusters {
+		if _, err := stmt.Exec(cluster, id); err != nil {
 			return err
 		}
 	}
+	return tx.Commit()
+}
+
+// ClearProjections removes every projection and forgets which embeddings
+// they came from, so the next projection run can't be skipped as cached
+func (s *Store) ClearProjections() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
+	if _, err := tx.Exec("DELETE FROM projections"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM meta WHERE key = 'projection_hash'"); err != nil {
+		return err
+	}
 	return tx.Commit()
 }
 
-// GetAllProjections retrieves all 3D projections with prompt text
-func GetAllProjections() ([]Projection, error) {
-	rows, err := DB.Query(`
-		SELECT p.prompt_id, pr.text, p.x, p.y, p.z
+// GetAllProjections retrieves all 3D projections with prompt text and creation
+// time. Soft-deleted prompts are left out unless includeDeleted is set.
+func (s *Store) GetAllProjections(ctx context.Context, includeDeleted bool) ([]Projection, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT p.prompt_id, pr.text, pr.created_at, pr.deleted_at, p.x, p.y, p.z, p.coords, p.norm, p.cluster, m.model, pr.color
 		FROM projections p
 		JOIN prompts pr ON p.prompt_id = pr.id
+		LEFT JOIN embedding_meta m ON m.prompt_id = p.prompt_id
+		WHERE ? OR pr.deleted_at IS NULL
 		ORDER BY p.prompt_id
-	`)
+	`, includeDeleted)
 	if err != nil {
 		return nil, err
 	}
@@ -182,24 +1193,398 @@ func GetAllProjections() ([]Projection, error) {
 	var results []Projection
 	for rows.Next() {
 		var p Projection
-		if err := rows.Scan(&p.PromptID, &p.Text, &p.X, &p.Y, &p.Z); err != nil {
+		var deletedAt sql.NullTime
+		var norm sql.NullFloat64
+		var cluster sql.NullInt64
+		var model, color sql.NullString
+		var coords []byte
+		if err := rows.Scan(&p.PromptID, &p.Text, &p.CreatedAt, &deletedAt, &p.X, &p.Y, &p.Z, &coords, &norm, &cluster, &model, &color); err != nil {
 			return nil, err
 		}
+		var err error
+		if p.Coords, err = deserializeCoords(coords); err != nil {
+			return nil, fmt.Errorf("prompt %d: %w", p.PromptID, err)
+		}
+		p.Model = model.String
+		p.Color = color.String
+		p.DeletedAt = deletedAt.Time
+		p.Norm = norm.Float64
+		p.Cluster = NoCluster
+		if cluster.Valid {
+			p.Cluster = int(cluster.Int64)
+		}
 		results = append(results, p)
 	}
 	return results, rows.Err()
 }
 
+// EmbeddingModels returns the distinct known models behind the embeddings of
+// prompts that aren't soft-deleted, sorted. Embeddings of unknown model are
+// left out.
+func (s *Store) EmbeddingModels(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT DISTINCT m.model
+		FROM embedding_meta m
+		JOIN prompts pr ON pr.id = m.prompt_id
+		WHERE m.model IS NOT NULL AND pr.deleted_at IS NULL
+			AND m.prompt_id IN (SELECT prompt_id FROM embeddings)
+		ORDER BY m.model
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var models []string
+	for rows.Next() {
+		var model string
+		if err := rows.Scan(&model); err != nil {
+			return nil, err
+		}
+		models = append(models, model)
+	}
+	return models, rows.Err()
+}
+
 // GetEmbeddingCount returns the number of stored embeddings
-func GetEmbeddingCount() (int, error) {
+func (s *Store) GetEmbeddingCount(ctx context.Context) (int, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM embeddings").Scan(&count)
+	return count, err
+}
+
+// GetActiveEmbeddingCount returns the number of embeddings GetAllEmbeddings
+// returns, leaving out those of soft-deleted prompts
+func (s *Store) GetActiveEmbeddingCount(ctx context.Context) (int, error) {
 	var count int
-	err := DB.QueryRow("SELECT COUNT(*) FROM embeddings").Scan(&count)
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM embeddings e
+		JOIN prompts pr ON pr.id = e.prompt_id
+		WHERE pr.deleted_at IS NULL
+	`).Scan(&count)
 	return count, err
 }
 
+// FindUnprojected returns the IDs of prompts that have an embedding but no
+// projection, such as ones added since the last run, in ID order.
+// Soft-deleted prompts are left out.
+func (s *Store) FindUnprojected(ctx context.Context) ([]int64, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT e.prompt_id
+		FROM embeddings e
+		JOIN prompts pr ON pr.id = e.prompt_id
+		LEFT JOIN projections p ON p.prompt_id = e.prompt_id
+		WHERE p.prompt_id IS NULL AND pr.deleted_at IS NULL
+		ORDER BY e.prompt_id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
 // GetProjectionCount returns the number of stored projections
-func GetProjectionCount() (int, error) {
+func (s *Store) GetProjectionCount(ctx context.Context) (int, error) {
 	var count int
-	err := DB.QueryRow("SELECT COUNT(*) FROM projections").Scan(&count)
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM projections").Scan(&count)
 	return count, err
 }
+
+// DeletePrompt removes a prompt along with its embedding and projection.
+// The embeddings vec0 table has no foreign key, so all three are deleted explicitly.
+func (s *Store) DeletePrompt(id int64) error {
+	n, err := s.DeletePrompts([]int64{id})
+	if err == nil && n == 0 {
+		return ErrPromptNotFound
+	}
+	return err
+}
+
+// deleteChunk is how many IDs deletePrompts puts in each IN clause, well
+// under SQLite's limit of 32766 bound parameters
+const deleteChunk = 500
+
+// DeletePrompts removes prompts as DeletePrompt does, all in one transaction,
+// and returns how many there were. Unknown IDs are skipped.
+func (s *Store) DeletePrompts(ids []int64) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	return s.deletePrompts(tx, ids)
+}
+
+// DeletePromptsWithTag removes every prompt labeled with tag as DeletePrompts
+// does, returning how many there were
+func (s *Store) DeletePromptsWithTag(tag string) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Reading the IDs in the transaction keeps a prompt tagged meanwhile
+	// from being missed
+	rows, err := tx.Query("SELECT prompt_id FROM tags WHERE tag = ?", tag)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	return s.deletePrompts(tx, ids)
+}
+
+// deletePrompts does the work of DeletePrompts in tx, committing it if any
+// prompt was deleted
+func (s *Store) deletePrompts(tx *sql.Tx, ids []int64) (int, error) {
+	deleted := 0
+	tables := append([]string{"embeddings", "projections"}, orphanTables...)
+	for start := 0; start < len(ids); start += deleteChunk {
+		chunk := ids[start:min(start+deleteChunk, len(ids))]
+		in := "(?" + strings.Repeat(", ?", len(chunk)-1) + ")"
+		args := make([]interface{}, len(chunk))
+		for i, id := range chunk {
+			args[i] = id
+		}
+
+		for _, table := range tables {
+			if _, err := tx.Exec("DELETE FROM "+table+" WHERE prompt_id IN "+in, args...); err != nil {
+				return 0, err
+			}
+		}
+		result, err := tx.Exec("DELETE FROM prompts WHERE id IN "+in, args...)
+		if err != nil {
+			return 0, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		deleted += int(n)
+	}
+	if deleted == 0 {
+		return 0, nil
+	}
+
+	if err := s.clearNeighborGraph(tx); err != nil {
+		return 0, err
+	}
+	return deleted, tx.Commit()
+}
+
+// activeKNN limits a KNN query on the embeddings table to prompts that
+// aren't soft-deleted. vec0 applies it while searching, so the query still
+// finds k neighbors when some of the nearest are deleted.
+const activeKNN = "prompt_id IN (SELECT id FROM prompts WHERE deleted_at IS NULL)"
+
+// ProjectNewPoint approximates where a vector would land in the current 3D layout
+// by averaging the projections of its nearest embedded neighbors, weighted by
+// inverse distance. Soft-deleted prompts are hidden from the layout, so they
+// are never neighbors. Nothing is persisted.
+func (s *Store) ProjectNewPoint(ctx context.Context, vector []float32) (x, y, z float64, err error) {
+	// A query vector's scale doesn't change cosine distances, the only kind int8 storage allows
+	serialized, _, err := s.encodeVector(vector)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		WITH knn AS (
+			SELECT prompt_id, distance
+			FROM embeddings
+			WHERE embedding MATCH `+s.vectorArg()+` AND k = ? AND `+activeKNN+`
+		)
+		SELECT knn.distance, p.x, p.y, p.z
+		FROM knn
+		JOIN projections p ON p.prompt_id = knn.prompt_id
+		ORDER BY knn.distance
+	`, serialized, placementNeighbors)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer rows.Close()
+
+	var totalWeight float64
+	for rows.Next() {
+		var distance, px, py, pz float64
+		if err := rows.Scan(&distance, &px, &py, &pz); err != nil {
+			return 0, 0, 0, err
+		}
+		// An exact match pins the point to its twin
+		if distance == 0 {
+			return px, py, pz, nil
+		}
+		weight := 1 / distance
+		x += weight * px
+		y += weight * py
+		z += weight * pz
+		totalWeight += weight
+	}
+	if err := rows.Err(); err != nil {
+		return 0, 0, 0, err
+	}
+
+	if totalWeight == 0 {
+		return 0, 0, 0, ErrNoProjectedNeighbors
+	}
+	return x / totalWeight, y / totalWeight, z / totalWeight, nil
+}
+
+// SearchResult is a prompt matched by a vector search
+type SearchResult struct {
+	PromptID int64
+	Text     string
+	Distance float64
+}
+
+// SearchNearest returns the k prompts whose embeddings are closest to vector,
+// leaving out soft-deleted prompts
+func (s *Store) SearchNearest(ctx context.Context, vector []float32, k int) ([]SearchResult, error) {
+	serialized, _, err := s.encodeVector(vector)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		WITH knn AS (
+			SELECT prompt_id, distance
+			FROM embeddings
+			WHERE embedding MATCH `+s.vectorArg()+` AND k = ? AND `+activeKNN+`
+		)
+		SELECT knn.prompt_id, pr.text, knn.distance
+		FROM knn
+		JOIN prompts pr ON pr.id = knn.prompt_id
+		ORDER BY knn.distance
+	`, serialized, k)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var r SearchResult
+		if err := rows.Scan(&r.PromptID, &r.Text, &r.Distance); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+// NearestDistance returns the distance (under Metric) from vector to the closest stored
+// embedding other than promptID's own, leaving out soft-deleted prompts. ok is
+// false when there is no other embedding.
+func (s *Store) NearestDistance(ctx context.Context, promptID int64, vector []float32) (distance float64, ok bool, err error) {
+	serialized, _, err := s.encodeVector(vector)
+	if err != nil {
+		return 0, false, err
+	}
+
+	// k = 2 so the prompt's own embedding can be skipped. vec0 rejects an
+	// outer LIMIT alongside k, so MIN picks the remaining neighbor.
+	var nearest sql.NullFloat64
+	err = s.db.QueryRowContext(ctx, `
+		WITH knn AS (
+			SELECT prompt_id, distance
+			FROM embeddings
+			WHERE embedding MATCH `+s.vectorArg()+` AND k = 2 AND `+activeKNN+`
+		)
+		SELECT MIN(distance) FROM knn
+		WHERE prompt_id != ?
+	`, serialized, promptID).Scan(&nearest)
+	if err != nil {
+		return 0, false, err
+	}
+	return nearest.Float64, nearest.Valid, nil
+}
+
+// WithinDistance returns the distance (under Metric) from promptID's embedding to
+// every embedding no farther than radius, keyed by prompt ID. The prompt itself is
+// included at distance 0. Returns ErrEmbeddingNotFound if promptID has no embedding.
+func (s *Store) WithinDistance(ctx context.Context, promptID int64, radius float64) (map[int64]float64, error) {
+	// vec0 caps k well below the size of a large collection, so this scans with
+	// the scalar distance function matching the table's metric instead of a KNN query
+	distanceFunc := "vec_distance_l2"
+	if s.Metric == MetricCosine {
+		distanceFunc = "vec_distance_cosine"
+	}
+
+	var exists bool
+	err := s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM embeddings WHERE prompt_id = ?)", promptID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrEmbeddingNotFound
+	}
+
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`
+		WITH query AS (
+			SELECT embedding FROM embeddings WHERE prompt_id = ?
+		),
+		scored AS (
+			SELECT e.prompt_id, %s(e.embedding, query.embedding) AS distance
+			FROM embeddings e, query
+		)
+		SELECT prompt_id, distance FROM scored
+		WHERE distance <= ?
+	`, distanceFunc), promptID, radius)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	distances := make(map[int64]float64)
+	for rows.Next() {
+		var id int64
+		var distance float64
+		if err := rows.Scan(&id, &distance); err != nil {
+			return nil, err
+		}
+		distances[id] = distance
+	}
+	return distances, rows.Err()
+}
+
+// SetMeta stores a key/value setting describing the current data
+func (s *Store) SetMeta(key, value string) error {
+	_, err := s.db.Exec(`
+		INSERT INTO meta (key, value) VALUES (?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value
+	`, key, value)
+	return err
+}
+
+// GetMeta returns a stored setting, or "" if it has never been set
+func (s *Store) GetMeta(key string) (string, error) {
+	var value string
+	err := s.db.QueryRow("SELECT value FROM meta WHERE key = ?", key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return value, err
+}
@@ -0,0 +1,269 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// newTestStore opens a fresh in-memory Store, closed when the test ends
+func newTestStore(t testing.TB) *Store {
+	t.Helper()
+	s, err := Open(MemoryPath, "", "", 0)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// axisVector returns a Dimension vector that is zero except for value at index
+func axisVector(index int, value float32) []float32 {
+	v := make([]float32, Dimension)
+	v[index] = value
+	return v
+}
+
+// addPrompt stores a prompt with the given embedding and returns its ID
+func addPrompt(t *testing.T, s *Store, text string, vector []float32) int64 {
+	t.Helper()
+	id, err := s.InsertPrompt(text)
+	if err != nil {
+		t.Fatalf("insert prompt %q: %v", text, err)
+	}
+	if err := s.InsertEmbedding(id, vector); err != nil {
+		t.Fatalf("insert embedding for %q: %v", text, err)
+	}
+	return id
+}
+
+func TestNearestSkipsSoftDeleted(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	// Along one axis, so distances from the origin are 1, 2 and 3
+	near := addPrompt(t, s, "near", axisVector(0, 1))
+	middle := addPrompt(t, s, "middle", axisVector(0, 2))
+	far := addPrompt(t, s, "far", axisVector(0, 3))
+	err := s.InsertProjections([]Projection{
+		{PromptID: near, X: 1},
+		{PromptID: middle, X: 2},
+		{PromptID: far, X: 3},
+	}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SoftDelete(near); err != nil {
+		t.Fatal(err)
+	}
+
+	origin := axisVector(0, 0)
+	results, err := s.SearchNearest(ctx, origin, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The deleted prompt is filtered while searching, so k results still come back
+	if len(results) != 2 || results[0].PromptID != middle || results[1].PromptID != far {
+		t.Errorf("SearchNearest = %+v, want middle then far", results)
+	}
+
+	// An exact match pins a point to its twin, unless the twin is deleted
+	x, _, _, err := s.ProjectNewPoint(ctx, axisVector(0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x == 1 {
+		t.Errorf("ProjectNewPoint placed the point on a soft-deleted prompt")
+	}
+
+	if err := s.Restore(near); err != nil {
+		t.Fatal(err)
+	}
+	results, err = s.SearchNearest(ctx, origin, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].PromptID != near {
+		t.Errorf("after Restore, SearchNearest = %+v, want near", results)
+	}
+}
+
+func TestConcurrentEmbedsOfOnePromptStoreOne(t *testing.T) {
+	s := newTestStore(t)
+
+	const workers = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id, err := s.InsertPrompt("same text")
+			if err != nil {
+				errs <- err
+				return
+			}
+			if err := s.InsertEmbedding(id, axisVector(0, 1)); err != nil && !errors.Is(err, ErrEmbeddingExists) {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	var prompts, embeddings int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM prompts").Scan(&prompts); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM embeddings").Scan(&embeddings); err != nil {
+		t.Fatal(err)
+	}
+	if prompts != 1 || embeddings != 1 {
+		t.Errorf("stored %d prompts and %d embeddings, want 1 of each", prompts, embeddings)
+	}
+}
+
+func TestInsertEmbeddingsSkipsExisting(t *testing.T) {
+	s := newTestStore(t)
+
+	existing := addPrompt(t, s, "existing", axisVector(0, 1))
+	fresh, err := s.InsertPrompt("fresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	skipped, err := s.InsertEmbeddings([]EmbeddingData{
+		{PromptID: existing, Vector: axisVector(0, 2)},
+		{PromptID: fresh, Vector: axisVector(1, 1)},
+		{PromptID: fresh, Vector: axisVector(1, 2)},
+	})
+	if err != nil {
+		t.Fatalf("InsertEmbeddings: %v", err)
+	}
+	if !slices.Equal(skipped, []int64{existing, fresh}) {
+		t.Errorf("skipped = %v, want [%d %d]", skipped, existing, fresh)
+	}
+
+	// The first embedding of each prompt is the one kept
+	for id, want := range map[int64][]float32{existing: axisVector(0, 1), fresh: axisVector(1, 1)} {
+		got, err := s.GetEmbedding(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("prompt %d kept the wrong embedding", id)
+		}
+	}
+}
+
+func TestMemoryStoreInsertQueryDelete(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	a := addPrompt(t, s, "a", axisVector(0, 1))
+	b := addPrompt(t, s, "b", axisVector(1, 1))
+
+	// vec0 answers KNN queries, so the extension is loaded
+	results, err := s.SearchNearest(ctx, axisVector(0, 1), 2)
+	if err != nil {
+		t.Fatalf("SearchNearest: %v", err)
+	}
+	if len(results) != 2 || results[0].PromptID != a || results[0].Distance != 0 || results[1].PromptID != b {
+		t.Errorf("SearchNearest = %+v, want a at distance 0 then b", results)
+	}
+
+	if err := s.DeletePrompt(a); err != nil {
+		t.Fatalf("DeletePrompt: %v", err)
+	}
+	if err := s.DeletePrompt(a); !errors.Is(err, ErrPromptNotFound) {
+		t.Errorf("deleting again: err = %v, want ErrPromptNotFound", err)
+	}
+	if count, err := s.GetEmbeddingCount(ctx); err != nil || count != 1 {
+		t.Errorf("GetEmbeddingCount = %d, %v, want 1", count, err)
+	}
+	results, err = s.SearchNearest(ctx, axisVector(0, 1), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].PromptID != b {
+		t.Errorf("after delete, SearchNearest = %+v, want only b", results)
+	}
+}
+
+func TestMemoryStoresAreIsolated(t *testing.T) {
+	first, second := newTestStore(t), newTestStore(t)
+
+	addPrompt(t, first, "only in the first", axisVector(0, 1))
+
+	count, err := second.GetEmbeddingCount(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("second store has %d embeddings, want none from the first", count)
+	}
+}
+
+func TestInsertEmbeddingErrors(t *testing.T) {
+	s := newTestStore(t)
+	id, err := s.InsertPrompt("prompt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.InsertEmbedding(id, make([]float32, Dimension-1)); !errors.Is(err, ErrDimensionMismatch) {
+		t.Errorf("short vector: err = %v, want ErrDimensionMismatch", err)
+	}
+	if err := s.InsertEmbedding(id, axisVector(0, 1)); err != nil {
+		t.Fatalf("first insert: %v", err)
+	}
+	if err := s.InsertEmbedding(id, axisVector(0, 2)); !errors.Is(err, ErrEmbeddingExists) {
+		t.Errorf("second insert: err = %v, want ErrEmbeddingExists", err)
+	}
+}
+
+func TestConcurrentWritesToFile(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "vecviz.db"), "", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	const writers = 16
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id, err := s.InsertPrompt(fmt.Sprintf("prompt %d", i))
+			if err != nil {
+				errs <- fmt.Errorf("prompt %d: %w", i, err)
+				return
+			}
+			if err := s.InsertEmbedding(id, axisVector(i, 1)); err != nil {
+				errs <- fmt.Errorf("embedding %d: %w", i, err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	count, err := s.GetEmbeddingCount(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != writers {
+		t.Errorf("stored %d embeddings, want %d", count, writers)
+	}
+}
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// ExportRow is one prompt with its embedding and projection, either of which may be missing
+type ExportRow struct {
+	PromptID   int64
+	Text       string
+	CreatedAt  time.Time
+	Vector     []float32
+	EmbeddedAt time.Time
+	// Model is the model that produced Vector, or "" if unknown
+	Model      string
+	Projection *Projection
+}
+
+// ForEachExportRow calls fn for every prompt in ID order. Rows are read one
+// at a time so large databases can be exported without loading every
+// vector into memory. Iteration stops at the first error fn returns.
+func (s *Store) ForEachExportRow(ctx context.Context, fn func(ExportRow) error) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT pr.id, pr.text, pr.created_at, e.embedding, m.embedded_at, m.scale, m.model, p.x, p.y, p.z
+		FROM prompts pr
+		LEFT JOIN embeddings e ON e.prompt_id = pr.id
+		LEFT JOIN embedding_meta m ON m.prompt_id = pr.id
+		LEFT JOIN projections p ON p.prompt_id = pr.id
+		ORDER BY pr.id
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var row ExportRow
+		var blob []byte
+		var embeddedAt sql.NullTime
+		var scale, x, y, z sql.NullFloat64
+		var model sql.NullString
+		if err := rows.Scan(&row.PromptID, &row.Text, &row.CreatedAt, &blob, &embeddedAt, &scale, &model, &x, &y, &z); err != nil {
+			return err
+		}
+
+		if blob != nil {
+			row.Vector, err = s.decodeVector(blob, scale)
+			if err != nil {
+				return fmt.Errorf("prompt %d: %w", row.PromptID, err)
+			}
+			row.EmbeddedAt = embeddedAt.Time
+			row.Model = model.String
+		}
+		if x.Valid {
+			row.Projection = &Projection{
+				PromptID:  row.PromptID,
+				Text:      row.Text,
+				CreatedAt: row.CreatedAt,
+				X:         x.Float64,
+				Y:         y.Float64,
+				Z:         z.Float64,
+			}
+		}
+
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
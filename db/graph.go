@@ -0,0 +1,167 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+)
+
+// MaxGraphK is the most neighbors BuildNeighborGraph keeps per point: vec0
+// answers at most 4096 per query, and one of them is the point itself
+const MaxGraphK = 4095
+
+// ErrGraphChanged is returned by BuildNeighborGraph when embeddings change
+// while it runs, leaving the graph it computed out of date
+var ErrGraphChanged = errors.New("embeddings changed while building the neighbor graph")
+
+// NeighborGraphK returns the k the stored neighbor graph was built with, or 0
+// if there is none
+func (s *Store) NeighborGraphK() (int, error) {
+	v, err := s.GetMeta("neighbor_graph_k")
+	if err != nil || v == "" {
+		return 0, err
+	}
+	return strconv.Atoi(v)
+}
+
+// BuildNeighborGraph stores each embedded prompt's k nearest other prompts,
+// as SearchNearest finds them, replacing any earlier graph. It returns how
+// many points and edges were stored. Storing or removing an embedding
+// afterwards discards the graph.
+func (s *Store) BuildNeighborGraph(ctx context.Context, k int) (points, edges int, err error) {
+	generation := s.graphGeneration.Load()
+
+	rows, err := s.db.QueryContext(ctx, "SELECT prompt_id FROM embeddings ORDER BY prompt_id")
+	if err != nil {
+		return 0, 0, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	// The KNN queries run outside the transaction so writers aren't blocked
+	// for the whole build; the generation check below catches their changes
+	type edge struct {
+		promptID, neighborID int64
+		rank                 int
+		distance             float64
+	}
+	var graph []edge
+	for _, id := range ids {
+		vector, err := s.GetEmbedding(ctx, id)
+		if errors.Is(err, ErrEmbeddingNotFound) {
+			return 0, 0, ErrGraphChanged
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		matches, err := s.SearchNearest(ctx, vector, k+1)
+		if err != nil {
+			return 0, 0, err
+		}
+		rank := 0
+		for _, m := range matches {
+			if m.PromptID == id || rank == k {
+				continue
+			}
+			graph = append(graph, edge{id, m.PromptID, rank, m.Distance})
+			rank++
+		}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM neighbors"); err != nil {
+		return 0, 0, err
+	}
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO neighbors (prompt_id, rank, neighbor_id, distance) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer stmt.Close()
+	for _, e := range graph {
+		if _, err := stmt.ExecContext(ctx, e.promptID, e.rank, e.neighborID, e.distance); err != nil {
+			return 0, 0, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO meta (key, value) VALUES ('neighbor_graph_k', ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value
+	`, strconv.Itoa(k)); err != nil {
+		return 0, 0, err
+	}
+
+	// Writers bump the generation inside their own transactions, and SQLite
+	// lets only one write at a time, so a change is visible here by now
+	if s.graphGeneration.Load() != generation {
+		return 0, 0, ErrGraphChanged
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+	return len(ids), len(graph), nil
+}
+
+// GraphNeighbors returns promptID's k nearest neighbors from the stored
+// graph, nearest first. ok is false if the graph can't answer: none is
+// built, it was built with a smaller k, or it has no neighbors for promptID.
+func (s *Store) GraphNeighbors(ctx context.Context, promptID int64, k int) (results []SearchResult, ok bool, err error) {
+	graphK, err := s.NeighborGraphK()
+	if err != nil || graphK == 0 || k > graphK {
+		return nil, false, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT n.neighbor_id, pr.text, n.distance
+		FROM neighbors n
+		JOIN prompts pr ON pr.id = n.neighbor_id
+		WHERE n.prompt_id = ?
+		ORDER BY n.rank
+		LIMIT ?
+	`, promptID, k)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r SearchResult
+		if err := rows.Scan(&r.PromptID, &r.Text, &r.Distance); err != nil {
+			return nil, false, err
+		}
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+	return results, len(results) > 0, nil
+}
+
+// clearNeighborGraph discards the neighbor graph as part of a transaction
+// that changes embeddings, and moves on the embedding generation
+func (s *Store) clearNeighborGraph(tx *sql.Tx) error {
+	s.graphGeneration.Add(1)
+	if err := bumpEmbeddingGeneration(tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM neighbors"); err != nil {
+		return err
+	}
+	_, err := tx.Exec("DELETE FROM meta WHERE key = 'neighbor_graph_k'")
+	return err
+}
@@ -0,0 +1,105 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+)
+
+// ErrRunNotFound is returned by RunProjections for a run that isn't the
+// current one and has no snapshot
+var ErrRunNotFound = errors.New("projection run not found")
+
+// projectionRunIDKey is the meta key holding the ID of the run that produced
+// the stored projections. Storing projections increments it.
+const projectionRunIDKey = "projection_run_id"
+
+// ProjectionRunID returns the ID of the run that produced the stored
+// projections, or 0 if there has been none
+func (s *Store) ProjectionRunID() (int64, error) {
+	v, err := s.GetMeta(projectionRunIDKey)
+	if err != nil || v == "" {
+		return 0, err
+	}
+	return strconv.ParseInt(v, 10, 64)
+}
+
+// SnapshotProjections copies the stored projections into projection_history
+// under their run's ID, so they can be compared with later runs, and returns
+// that ID, or 0 if there is nothing to snapshot. Only the keep most recent
+// snapshots are kept.
+func (s *Store) SnapshotProjections(keep int) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Read the run ID in the same transaction as the rows, so they can't
+	// come from different runs
+	var v string
+	err = tx.QueryRow("SELECT value FROM meta WHERE key = ?", projectionRunIDKey).Scan(&v)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	runID, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.Exec(`
+		INSERT OR REPLACE INTO projection_history (run_id, prompt_id, x, y, z)
+		SELECT ?, prompt_id, x, y, z FROM projections
+	`, runID); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM projection_history WHERE run_id NOT IN (
+			SELECT DISTINCT run_id FROM projection_history ORDER BY run_id DESC LIMIT ?
+		)
+	`, keep); err != nil {
+		return 0, err
+	}
+	return runID, tx.Commit()
+}
+
+// RunProjections returns a run's projections keyed by prompt ID, from its
+// snapshot or, for the current run, the stored projections. Only X, Y and Z
+// are filled in.
+func (s *Store) RunProjections(ctx context.Context, runID int64) (map[int64]Projection, error) {
+	projections, err := s.queryRunProjections(ctx, "SELECT prompt_id, x, y, z FROM projection_history WHERE run_id = ?", runID)
+	if err != nil || len(projections) > 0 {
+		return projections, err
+	}
+	current, err := s.ProjectionRunID()
+	if err != nil {
+		return nil, err
+	}
+	if runID != current || current == 0 {
+		return nil, ErrRunNotFound
+	}
+	return s.queryRunProjections(ctx, "SELECT prompt_id, x, y, z FROM projections")
+}
+
+// queryRunProjections reads prompt_id, x, y and z rows into a map
+func (s *Store) queryRunProjections(ctx context.Context, query string, args ...interface{}) (map[int64]Projection, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	projections := make(map[int64]Projection)
+	for rows.Next() {
+		var p Projection
+		if err := rows.Scan(&p.PromptID, &p.X, &p.Y, &p.Z); err != nil {
+			return nil, err
+		}
+		projections[p.PromptID] = p
+	}
+	return projections, rows.Err()
+}
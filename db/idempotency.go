@@ -0,0 +1,62 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrIdempotencyKeyNotFound is returned when a key hasn't been seen within its TTL
+var ErrIdempotencyKeyNotFound = errors.New("idempotency key not found")
+
+// IdempotentResponse is the response recorded for a request's Idempotency-Key
+type IdempotentResponse struct {
+	// RequestHash identifies the request the key was first used with
+	RequestHash string
+	PromptID    int64
+	Status      int
+	Body        []byte
+}
+
+// GetIdempotentResponse returns the response recorded for key, or
+// ErrIdempotencyKeyNotFound if there is none younger than ttl
+func (s *Store) GetIdempotentResponse(ctx context.Context, key string, ttl time.Duration) (IdempotentResponse, error) {
+	var resp IdempotentResponse
+	err := s.db.QueryRowContext(ctx, `
+		SELECT request_hash, prompt_id, status, response FROM idempotency
+		WHERE key = ? AND created_at > datetime('now', ?)
+	`, key, ttlModifier(ttl)).Scan(&resp.RequestHash, &resp.PromptID, &resp.Status, &resp.Body)
+	if err == sql.ErrNoRows {
+		return resp, ErrIdempotencyKeyNotFound
+	}
+	return resp, err
+}
+
+// SaveIdempotentResponse records the response to a request under key,
+// dropping keys older than ttl first. If another request recorded the key
+// first, its response is kept.
+func (s *Store) SaveIdempotentResponse(key string, resp IdempotentResponse, ttl time.Duration) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM idempotency WHERE created_at <= datetime('now', ?)", ttlModifier(ttl)); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO idempotency (key, request_hash, prompt_id, status, response) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(key) DO NOTHING
+	`, key, resp.RequestHash, resp.PromptID, resp.Status, resp.Body); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ttlModifier turns a TTL into an SQLite datetime modifier reaching that far back
+func ttlModifier(ttl time.Duration) string {
+	return fmt.Sprintf("-%d seconds", int64(ttl.Seconds()))
+}
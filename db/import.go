@@ -0,0 +1,106 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// ImportRecord is a prompt with a precomputed embedding and optional projection
+type ImportRecord struct {
+	Text string
+	// CreatedAt keeps the original creation time; the zero value means now
+	CreatedAt time.Time
+	Vector    []float32
+	// EmbeddedAt keeps the original embedding time; the zero value means now
+	EmbeddedAt time.Time
+	// Model is the model that produced Vector, or "" if unknown
+	Model      string
+	Projection *Projection
+}
+
+// ImportPrompts stores records in a single transaction without calling Ollama.
+// A record whose text is already stored is skipped, or with upsert has its
+// embedding and projection replaced. Prompt IDs are assigned by this database.
+func (s *Store) ImportPrompts(records []ImportRecord, upsert bool) (imported, skipped int, err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	for _, rec := range records {
+		var id int64
+		key := s.dedupKey(rec.Text)
+		err := tx.QueryRow("SELECT id FROM prompts WHERE dedup_key = ? ORDER BY id LIMIT 1", key).Scan(&id)
+		switch {
+		case err == nil:
+			if !upsert {
+				skipped++
+				continue
+			}
+			// vec0 has no upsert, so replace the embedding by deleting it first
+			if rec.Vector != nil {
+				if _, err := tx.Exec("DELETE FROM embeddings WHERE prompt_id = ?", id); err != nil {
+					return 0, 0, err
+				}
+			}
+		case err == sql.ErrNoRows:
+			var result sql.Result
+			if rec.CreatedAt.IsZero() {
+				result, err = tx.Exec("INSERT INTO prompts (text, dedup_key) VALUES (?, ?)", rec.Text, key)
+			} else {
+				result, err = tx.Exec("INSERT INTO prompts (text, dedup_key, created_at) VALUES (?, ?, ?)", rec.Text, key, rec.CreatedAt.UTC().Format(sqliteTimeLayout))
+			}
+			if err != nil {
+				return 0, 0, err
+			}
+			if id, err = result.LastInsertId(); err != nil {
+				return 0, 0, err
+			}
+		default:
+			return 0, 0, err
+		}
+
+		if rec.Vector != nil {
+			if err := checkDimension(rec.Vector); err != nil {
+				return 0, 0, fmt.Errorf("prompt %q: %w", rec.Text, err)
+			}
+			serialized, scale, err := s.encodeVector(rec.Vector)
+			if err != nil {
+				return 0, 0, err
+			}
+			if _, err := tx.Exec("INSERT INTO embeddings (prompt_id, embedding) VALUES (?, "+s.vectorArg()+")", id, serialized); err != nil {
+				return 0, 0, err
+			}
+			embeddedAt := rec.EmbeddedAt
+			if embeddedAt.IsZero() {
+				embeddedAt = time.Now()
+			}
+			if _, err := tx.Exec(recordEmbeddingMeta, id, embeddedAt.UTC().Format(sqliteTimeLayout), scale, rec.Model); err != nil {
+				return 0, 0, err
+			}
+		}
+
+		if p := rec.Projection; p != nil {
+			_, err := tx.Exec(`
+				INSERT INTO projections (prompt_id, x, y, z) VALUES (?, ?, ?, ?)
+				ON CONFLICT(prompt_id) DO UPDATE SET x = excluded.x, y = excluded.y, z = excluded.z, coords = NULL, norm = excluded.norm, cluster = NULL
+			`, id, p.X, p.Y, p.Z)
+			if err != nil {
+				return 0, 0, err
+			}
+		}
+		imported++
+	}
+	if imported > 0 {
+		if err := s.clearNeighborGraph(tx); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+	return imported, skipped, nil
+}
@@ -0,0 +1,66 @@
+package db
+
+import "context"
+
+// SetMetadata stores key/value metadata for a prompt, such as its source URL
+// or author. Keys already set on the prompt are overwritten; other keys are kept.
+func (s *Store) SetMetadata(promptID int64, metadata map[string]string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for key, value := range metadata {
+		_, err := tx.Exec(`
+			INSERT INTO metadata (prompt_id, key, value) VALUES (?, ?, ?)
+			ON CONFLICT(prompt_id, key) DO UPDATE SET value = excluded.value
+		`, promptID, key, value)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetMetadata returns a prompt's metadata, empty if it has none
+func (s *Store) GetMetadata(ctx context.Context, promptID int64) (map[string]string, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT key, value FROM metadata WHERE prompt_id = ?", promptID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	metadata := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		metadata[key] = value
+	}
+	return metadata, rows.Err()
+}
+
+// GetAllMetadata returns every prompt's metadata, keyed by prompt ID
+func (s *Store) GetAllMetadata(ctx context.Context) (map[int64]map[string]string, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT prompt_id, key, value FROM metadata")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	metadata := make(map[int64]map[string]string)
+	for rows.Next() {
+		var promptID int64
+		var key, value string
+		if err := rows.Scan(&promptID, &key, &value); err != nil {
+			return nil, err
+		}
+		if metadata[promptID] == nil {
+			metadata[promptID] = make(map[string]string)
+		}
+		metadata[promptID][key] = value
+	}
+	return metadata, rows.Err()
+}
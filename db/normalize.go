@@ -0,0 +1,142 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Ways prompt text can be normalized before looking for a stored duplicate.
+// The stored text is always what was first submitted; only the dedup key is
+// normalized.
+const (
+	// NormalizeNone treats prompts as duplicates only when their text is identical
+	NormalizeNone = "none"
+	// NormalizeWhitespace trims prompts and collapses runs of whitespace to one space
+	NormalizeWhitespace = "whitespace"
+	// NormalizeLowercase normalizes whitespace and also ignores case
+	NormalizeLowercase = "lowercase"
+)
+
+// normalizePrompt returns the dedup key for text under mode
+func normalizePrompt(text, mode string) string {
+	switch mode {
+	case NormalizeWhitespace:
+		return strings.Join(strings.Fields(text), " ")
+	case NormalizeLowercase:
+		return strings.ToLower(strings.Join(strings.Fields(text), " "))
+	}
+	return text
+}
+
+// dedupKey returns the key text is looked up by under the store's normalization
+func (s *Store) dedupKey(text string) string {
+	return normalizePrompt(text, s.normalization)
+}
+
+// PromptNormalization returns how prompts are compared for duplicates
+func (s *Store) PromptNormalization() string {
+	return s.normalization
+}
+
+// SetPromptNormalization chooses how InsertPrompt and friends compare prompts
+// for duplicates. Changing it re-keys every stored prompt, and returns how
+// many prompts now share a key with an earlier one: those stay stored, and
+// new submissions matching them resolve to the lowest ID.
+func (s *Store) SetPromptNormalization(mode string) (duplicates int, err error) {
+	if mode == "" {
+		mode = NormalizeNone
+	}
+	if mode != NormalizeNone && mode != NormalizeWhitespace && mode != NormalizeLowercase {
+		return 0, fmt.Errorf("unknown prompt normalization %q, expected %s, %s or %s", mode, NormalizeNone, NormalizeWhitespace, NormalizeLowercase)
+	}
+	if mode == s.normalization {
+		return 0, nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err := rekeyPrompts(tx, mode, false); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO meta (key, value) VALUES ('prompt_normalization', ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value
+	`, mode); err != nil {
+		return 0, err
+	}
+	if err := tx.QueryRow("SELECT COUNT(*) - COUNT(DISTINCT dedup_key) FROM prompts").Scan(&duplicates); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	s.normalization = mode
+	return duplicates, nil
+}
+
+// initNormalization loads the recorded normalization and keys prompts stored
+// before dedup keys existed
+func (s *Store) initNormalization() error {
+	mode, err := s.GetMeta("prompt_normalization")
+	if err != nil {
+		return err
+	}
+	if mode == "" {
+		mode = NormalizeNone
+	}
+	s.normalization = mode
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := rekeyPrompts(tx, mode, true); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// rekeyPrompts sets dedup_key for every prompt, or with onlyMissing just the
+// ones that have none
+func rekeyPrompts(tx *sql.Tx, mode string, onlyMissing bool) error {
+	rows, err := tx.Query("SELECT id, text FROM prompts WHERE NOT ? OR dedup_key IS NULL", onlyMissing)
+	if err != nil {
+		return err
+	}
+	type keyed struct {
+		id  int64
+		key string
+	}
+	var keys []keyed
+	for rows.Next() {
+		var id int64
+		var text string
+		if err := rows.Scan(&id, &text); err != nil {
+			rows.Close()
+			return err
+		}
+		keys = append(keys, keyed{id, normalizePrompt(text, mode)})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare("UPDATE prompts SET dedup_key = ? WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, k := range keys {
+		if _, err := stmt.Exec(k.key, k.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package db
+
+import (
+	"context"
+	"testing"
+)
+
+func TestNormalizePrompt(t *testing.T) {
+	tests := []struct {
+		text, mode, want string
+	}{
+		{"  Foo \t bar\n", NormalizeNone, "  Foo \t bar\n"},
+		{"  Foo \t bar\n", NormalizeWhitespace, "Foo bar"},
+		{"  Foo \t bar\n", NormalizeLowercase, "foo bar"},
+		{"foo", NormalizeWhitespace, "foo"},
+		{"ÉCOLE  Été", NormalizeLowercase, "école été"},
+	}
+	for _, tt := range tests {
+		if got := normalizePrompt(tt.text, tt.mode); got != tt.want {
+			t.Errorf("normalizePrompt(%q, %s) = %q, want %q", tt.text, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestWhitespaceVariantsCollapse(t *testing.T) {
+	s := newTestStore(t)
+	if _, err := s.SetPromptNormalization(NormalizeWhitespace); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := s.InsertPrompt("foo ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.InsertPrompt("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Errorf(`"foo " and "foo" stored as prompts %d and %d, want one`, first, second)
+	}
+	// The text first submitted is kept for display
+	if text, err := s.GetPromptText(context.Background(), first); err != nil || text != "foo " {
+		t.Errorf("stored text = %q, %v, want %q", text, err, "foo ")
+	}
+
+	// Case still counts under whitespace normalization
+	if other, err := s.InsertPrompt("FOO"); err != nil || other == first {
+		t.Errorf(`"FOO" got prompt %d, %v, want a new prompt`, other, err)
+	}
+}
+
+func TestSetPromptNormalizationCountsDuplicates(t *testing.T) {
+	s := newTestStore(t)
+	for _, text := range []string{"foo", "foo ", "Foo"} {
+		if _, err := s.InsertPrompt(text); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n, err := s.SetPromptNormalization(NormalizeLowercase); err != nil || n != 2 {
+		t.Errorf("SetPromptNormalization = %d, %v, want 2 duplicates", n, err)
+	}
+	if _, err := s.SetPromptNormalization("fuzzy"); err == nil {
+		t.Error("unknown mode accepted")
+	}
+}
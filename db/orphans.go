@@ -0,0 +1,95 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+)
+
+// orphanTables are the tables besides embeddings and projections that hold
+// per-prompt rows DeletePrompt removes
+var orphanTables = []string{"embedding_meta", "embed_queue", "tags", "metadata", "idempotency"}
+
+// OrphanCounts says how many rows RemoveOrphans deleted
+type OrphanCounts struct {
+	Embeddings  int
+	Projections int
+	// Other counts embedding metadata, queue entries, tags, metadata and
+	// idempotency keys
+	Other int
+}
+
+// FindOrphans returns the prompt IDs of embeddings and projections whose
+// prompt no longer exists. The embeddings table is a vec0 virtual table and
+// SQLite doesn't enforce projections' foreign key, so deleting prompts with
+// plain SQL rather than DeletePrompt leaves these behind.
+func (s *Store) FindOrphans(ctx context.Context) (orphanEmbeddings, orphanProjections []int64, err error) {
+	orphanEmbeddings, err = findOrphans(ctx, s.db, "SELECT prompt_id FROM embeddings WHERE prompt_id NOT IN (SELECT id FROM prompts) ORDER BY prompt_id")
+	if err != nil {
+		return nil, nil, err
+	}
+	orphanProjections, err = findOrphans(ctx, s.db, "SELECT prompt_id FROM projections WHERE prompt_id NOT IN (SELECT id FROM prompts) ORDER BY prompt_id")
+	if err != nil {
+		return nil, nil, err
+	}
+	return orphanEmbeddings, orphanProjections, nil
+}
+
+// RemoveOrphans deletes the embeddings and projections FindOrphans reports,
+// along with any other per-prompt rows left without a prompt
+func (s *Store) RemoveOrphans() (OrphanCounts, error) {
+	var counts OrphanCounts
+	tx, err := s.db.Begin()
+	if err != nil {
+		return counts, err
+	}
+	defer tx.Rollback()
+
+	if counts.Embeddings, err = deleteOrphanRows(tx, "embeddings"); err != nil {
+		return counts, err
+	}
+	if counts.Projections, err = deleteOrphanRows(tx, "projections"); err != nil {
+		return counts, err
+	}
+	for _, table := range orphanTables {
+		n, err := deleteOrphanRows(tx, table)
+		if err != nil {
+			return counts, err
+		}
+		counts.Other += n
+	}
+	if counts.Embeddings > 0 {
+		if err := s.clearNeighborGraph(tx); err != nil {
+			return counts, err
+		}
+	}
+	return counts, tx.Commit()
+}
+
+// findOrphans returns the IDs a query selects
+func findOrphans(ctx context.Context, db *sql.DB, query string) ([]int64, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// deleteOrphanRows deletes a table's rows whose prompt_id has no prompt
+func deleteOrphanRows(tx *sql.Tx, table string) (int, error) {
+	result, err := tx.Exec("DELETE FROM " + table + " WHERE prompt_id NOT IN (SELECT id FROM prompts)")
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,70 @@
+package db
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// benchmarkProjections is how many projections the insert benchmarks write
+const benchmarkProjections = 50000
+
+// syntheticProjections stores n prompts and returns a random projection for each
+func syntheticProjections(b *testing.B, s *Store, n int) []Projection {
+	b.Helper()
+	tx, err := s.db.Begin()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer tx.Rollback()
+	rng := rand.New(rand.NewSource(1))
+	projections := make([]Projection, n)
+	for i := range projections {
+		id := int64(i + 1)
+		if _, err := tx.Exec("INSERT INTO prompts (id, text, dedup_key) VALUES (?, ?, ?)", id, fmt.Sprint(id), fmt.Sprint(id)); err != nil {
+			b.Fatal(err)
+		}
+		projections[i] = Projection{PromptID: id, X: rng.Float64(), Y: rng.Float64(), Z: rng.Float64()}
+	}
+	if err := tx.Commit(); err != nil {
+		b.Fatal(err)
+	}
+	return projections
+}
+
+func BenchmarkInsertProjections(b *testing.B) {
+	b.Run("batched", func(b *testing.B) {
+		s := newTestStore(b)
+		projections := syntheticProjections(b, s, benchmarkProjections)
+		for b.Loop() {
+			if err := s.InsertProjections(projections, 1); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	// One statement per row, as projections were stored before, for comparison
+	b.Run("row by row", func(b *testing.B) {
+		s := newTestStore(b)
+		projections := syntheticProjections(b, s, benchmarkProjections)
+		for b.Loop() {
+			tx, err := s.db.Begin()
+			if err != nil {
+				b.Fatal(err)
+			}
+			stmt, err := tx.Prepare(insertProjectionsSQL(1))
+			if err != nil {
+				b.Fatal(err)
+			}
+			for _, p := range projections {
+				if _, err := stmt.Exec(p.PromptID, p.X, p.Y, p.Z, serializeCoords(p.Coords), p.Norm); err != nil {
+					b.Fatal(err)
+				}
+			}
+			stmt.Close()
+			if err := tx.Commit(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,197 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// PromptInfo describes a stored prompt and how far through the pipeline it is
+type PromptInfo struct {
+	ID            int64
+	Text          string
+	CreatedAt     time.Time
+	HasEmbedding  bool
+	HasProjection bool
+	// DeletedAt is when the prompt was soft-deleted, or zero if it wasn't
+	DeletedAt time.Time
+}
+
+// ListPrompts returns prompts ordered by ID, for paging through the database.
+// Soft-deleted prompts are left out unless includeDeleted is set.
+func (s *Store) ListPrompts(ctx context.Context, limit, offset int, includeDeleted bool) ([]PromptInfo, error) {
+	return s.listPrompts(ctx, "LIMIT ? OFFSET ?", includeDeleted, 0, limit, offset)
+}
+
+// ListPromptsAfter is ListPrompts starting after the prompt with ID afterID,
+// so prompts inserted or deleted while paging can't shift later pages
+func (s *Store) ListPromptsAfter(ctx context.Context, afterID int64, limit int, includeDeleted bool) ([]PromptInfo, error) {
+	return s.listPrompts(ctx, "LIMIT ?", includeDeleted, afterID, limit)
+}
+
+// listPrompts runs the query behind ListPrompts and ListPromptsAfter, with
+// page being the LIMIT clause that args fill in
+func (s *Store) listPrompts(ctx context.Context, page string, includeDeleted bool, afterID int64, args ...interface{}) ([]PromptInfo, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT
+			pr.id,
+			pr.text,
+			pr.created_at,
+			EXISTS(SELECT 1 FROM embeddings e WHERE e.prompt_id = pr.id),
+			EXISTS(SELECT 1 FROM projections p WHERE p.prompt_id = pr.id),
+			pr.deleted_at
+		FROM prompts pr
+		WHERE (? OR pr.deleted_at IS NULL) AND pr.id > ?
+		ORDER BY pr.id
+		`+page, append([]interface{}{includeDeleted, afterID}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []PromptInfo
+	for rows.Next() {
+		var p PromptInfo
+		var deletedAt sql.NullTime
+		if err := rows.Scan(&p.ID, &p.Text, &p.CreatedAt, &p.HasEmbedding, &p.HasProjection, &deletedAt); err != nil {
+			return nil, err
+		}
+		p.DeletedAt = deletedAt.Time
+		results = append(results, p)
+	}
+	return results, rows.Err()
+}
+
+// LookupPrompt finds a prompt by its text, compared as InsertPrompt compares
+// it, returning its projection too if it has one. It returns
+// ErrPromptNotFound if no prompt matches, or only a soft-deleted one and
+// includeDeleted isn't set.
+func (s *Store) LookupPrompt(ctx context.Context, text string, includeDeleted bool) (PromptInfo, *Projection, error) {
+	var p PromptInfo
+	var deletedAt sql.NullTime
+	var x, y, z sql.NullFloat64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT
+			pr.id,
+			pr.text,
+			pr.created_at,
+			EXISTS(SELECT 1 FROM embeddings e WHERE e.prompt_id = pr.id),
+			pr.deleted_at,
+			p.x, p.y, p.z
+		FROM prompts pr
+		LEFT JOIN projections p ON p.prompt_id = pr.id
+		WHERE pr.dedup_key = ? AND (? OR pr.deleted_at IS NULL)
+		ORDER BY pr.id
+		LIMIT 1
+	`, s.dedupKey(text), includeDeleted).Scan(&p.ID, &p.Text, &p.CreatedAt, &p.HasEmbedding, &deletedAt, &x, &y, &z)
+	if err == sql.ErrNoRows {
+		return p, nil, ErrPromptNotFound
+	}
+	if err != nil {
+		return p, nil, err
+	}
+	p.DeletedAt = deletedAt.Time
+	if !x.Valid {
+		return p, nil, nil
+	}
+	p.HasProjection = true
+	return p, &Projection{PromptID: p.ID, X: x.Float64, Y: y.Float64, Z: z.Float64}, nil
+}
+
+// GetPromptCount returns the number of stored prompts, counting soft-deleted
+// ones only if includeDeleted is set
+func (s *Store) GetPromptCount(ctx context.Context, includeDeleted bool) (int, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM prompts WHERE ? OR deleted_at IS NULL", includeDeleted).Scan(&count)
+	return count, err
+}
+
+// SoftDelete hides a prompt from listings, projections and t-SNE while keeping
+// it and its embedding, so Restore can bring it back. Soft-deleting a prompt
+// that already is keeps its original deletion time.
+func (s *Store) SoftDelete(id int64) error {
+	return s.setDeletedAt(id, "COALESCE(deleted_at, ?)", time.Now().UTC().Format(sqliteTimeLayout))
+}
+
+// Restore undoes SoftDelete. Restoring a prompt that isn't deleted is a no-op.
+func (s *Store) Restore(id int64) error {
+	return s.setDeletedAt(id, "?", nil)
+}
+
+// setDeletedAt sets a prompt's deleted_at to expr, returning ErrPromptNotFound
+// for an unknown ID. Which embeddings are active changes with it, so the
+// embedding generation moves on too.
+func (s *Store) setDeletedAt(id int64, expr string, arg interface{}) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE prompts SET deleted_at = "+expr+" WHERE id = ?", arg, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrPromptNotFound
+	}
+	if err := bumpEmbeddingGeneration(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetPromptColor sets the color a prompt is drawn in, e.g. "#ff8800", or
+// clears it if color is "". It returns ErrPromptNotFound for an unknown ID.
+func (s *Store) SetPromptColor(id int64, color string) error {
+	result, err := s.db.Exec("UPDATE prompts SET color = NULLIF(?, '') WHERE id = ?", color, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrPromptNotFound
+	}
+	return nil
+}
+
+// UpdatePromptText changes a prompt's text, keeping its ID and therefore its
+// embedding, projection and tags. It returns ErrPromptNotFound for an unknown
+// ID and ErrPromptTextExists if another prompt already has the new text, as
+// compared under the store's prompt normalization.
+func (s *Store) UpdatePromptText(id int64, text string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	key := s.dedupKey(text)
+	var existingID int64
+	err = tx.QueryRow("SELECT id FROM prompts WHERE dedup_key = ? AND id != ? ORDER BY id LIMIT 1", key, id).Scan(&existingID)
+	if err == nil {
+		return fmt.Errorf("%w: used by prompt %d", ErrPromptTextExists, existingID)
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	result, err := tx.Exec("UPDATE prompts SET text = ?, dedup_key = ? WHERE id = ?", text, key, id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrPromptNotFound
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"database/sql"
+	"math"
+)
+
+// Formats the embeddings table can store vectors in
+const (
+	// StorageFloat32 keeps every component exactly, at 4 bytes each
+	StorageFloat32 = "float32"
+	// StorageInt8 keeps each component as a signed byte scaled per vector,
+	// a quarter of the size. Directions survive almost exactly, so it
+	// requires the cosine metric.
+	StorageInt8 = "int8"
+)
+
+// quantizeInt8 scales v so its largest component becomes ±127 and rounds
+// each component to a signed byte. Multiplying back by scale recovers v to
+// within half a step. A zero vector gets scale 0.
+func quantizeInt8(v []float32) (blob []byte, scale float64) {
+	var maxAbs float64
+	for _, x := range v {
+		maxAbs = math.Max(maxAbs, math.Abs(float64(x)))
+	}
+	blob = make([]byte, len(v))
+	if maxAbs == 0 {
+		return blob, 0
+	}
+	scale = maxAbs / 127
+	for i, x := range v {
+		blob[i] = byte(int8(math.Round(float64(x) / scale)))
+	}
+	return blob, scale
+}
+
+// dequantizeInt8 reverses quantizeInt8
+func dequantizeInt8(blob []byte, scale float64) []float32 {
+	v := make([]float32, len(blob))
+	for i, b := range blob {
+		v[i] = float32(float64(int8(b)) * scale)
+	}
+	return v
+}
+
+// vectorArg is the SQL placeholder for a vector bound with encodeVector. An
+// int8 column needs vec_int8 to tell vec0 how to read the blob.
+func (s *Store) vectorArg() string {
+	if s.Storage == StorageInt8 {
+		return "vec_int8(?)"
+	}
+	return "?"
+}
+
+// encodeVector serializes v in the Store's storage format. scale is what
+// embedding_meta records for dequantizing, and NULL for float32 storage.
+func (s *Store) encodeVector(v []float32) (blob []byte, scale sql.NullFloat64, err error) {
+	if s.Storage == StorageInt8 {
+		blob, f := quantizeInt8(v)
+		return blob, sql.NullFloat64{Float64: f, Valid: true}, nil
+	}
+	return serializeFloat32(v), sql.NullFloat64{}, nil
+}
+
+// decodeVector reverses encodeVector, given the scale stored alongside the blob
+func (s *Store) decodeVector(blob []byte, scale sql.NullFloat64) ([]float32, error) {
+	if s.Storage == StorageInt8 {
+		return dequantizeInt8(blob, scale.Float64), nil
+	}
+	return deserializeFloat32(blob)
+}
@@ -0,0 +1,75 @@
+package db
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// randomVector returns a Dimension vector of normally distributed
+// components, like the spread of a real embedding
+func randomVector(rng *rand.Rand) []float32 {
+	v := make([]float32, Dimension)
+	for i := range v {
+		v[i] = float32(rng.NormFloat64())
+	}
+	return v
+}
+
+// cosine returns the cosine similarity of a and b
+func cosine(a, b []float32) float64 {
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	return dot / math.Sqrt(normA*normB)
+}
+
+func TestQuantizeInt8ReconstructionError(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for range 20 {
+		v := randomVector(rng)
+		blob, scale := quantizeInt8(v)
+		if len(blob) != len(v) {
+			t.Fatalf("blob is %d bytes, want one per component", len(blob))
+		}
+		restored := dequantizeInt8(blob, scale)
+
+		// Rounding puts every component within half a step
+		for i := range v {
+			if diff := math.Abs(float64(restored[i] - v[i])); diff > scale/2+1e-6 {
+				t.Fatalf("component %d off by %g, more than half the step %g", i, diff, scale)
+			}
+		}
+		// t-SNE with the cosine metric only sees directions, which barely move
+		if sim := cosine(v, restored); sim < 0.9999 {
+			t.Errorf("cosine similarity to the original is %v, want at least 0.9999", sim)
+		}
+	}
+
+	if blob, scale := quantizeInt8(make([]float32, 4)); scale != 0 || dequantizeInt8(blob, scale)[0] != 0 {
+		t.Errorf("zero vector: scale %v, want 0 and a zero vector back", scale)
+	}
+}
+
+func TestInt8StoreRoundTrip(t *testing.T) {
+	s, err := Open(MemoryPath, MetricCosine, StorageInt8, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	v := randomVector(rand.New(rand.NewSource(2)))
+	id := addPrompt(t, s, "quantized", v)
+
+	restored, err := s.GetEmbedding(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sim := cosine(v, restored); sim < 0.9999 {
+		t.Errorf("stored vector has cosine similarity %v to the original, want at least 0.9999", sim)
+	}
+}
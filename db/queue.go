@@ -0,0 +1,115 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+)
+
+// QueuedPrompt is a prompt waiting to be embedded in the background
+type QueuedPrompt struct {
+	PromptID int64
+	Context  string
+	// Reembed replaces an existing embedding instead of keeping it
+	Reembed bool
+}
+
+// QueueStats summarizes the background embedding queue
+type QueueStats struct {
+	Pending    int
+	InProgress int
+	Failed     int
+}
+
+// EnqueueEmbedding queues a prompt for background embedding. Queueing a
+// prompt that is already queued resets its attempts.
+func (s *Store) EnqueueEmbedding(promptID int64, context string) error {
+	_, err := s.db.Exec(`
+		INSERT INTO embed_queue (prompt_id, context) VALUES (?, ?)
+		ON CONFLICT(prompt_id) DO UPDATE SET context = excluded.context, attempts = 0, last_error = ''
+	`, promptID, context)
+	return err
+}
+
+// ClaimQueued marks the oldest unclaimed prompt with fewer than maxAttempts
+// failures as in progress and returns it. It returns nil when nothing is ready.
+func (s *Store) ClaimQueued(maxAttempts int) (*QueuedPrompt, error) {
+	var q QueuedPrompt
+	err := s.db.QueryRow(`
+		UPDATE embed_queue SET claimed = 1
+		WHERE prompt_id = (
+			SELECT prompt_id FROM embed_queue
+			WHERE claimed = 0 AND attempts < ?
+			ORDER BY enqueued_at, prompt_id
+			LIMIT 1
+		)
+		RETURNING prompt_id, context, reembed
+	`, maxAttempts).Scan(&q.PromptID, &q.Context, &q.Reembed)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
+
+// CompleteQueued removes a prompt from the queue once it is embedded
+func (s *Store) CompleteQueued(promptID int64) error {
+	_, err := s.db.Exec("DELETE FROM embed_queue WHERE prompt_id = ?", promptID)
+	return err
+}
+
+// FailQueued releases a claimed prompt and records why embedding it failed
+func (s *Store) FailQueued(promptID int64, reason string) error {
+	_, err := s.db.Exec(`
+		UPDATE embed_queue SET claimed = 0, attempts = attempts + 1, last_error = ?
+		WHERE prompt_id = ?
+	`, reason, promptID)
+	return err
+}
+
+// ReleaseClaims returns prompts claimed by a previous run to the queue,
+// since whatever was embedding them is gone
+func (s *Store) ReleaseClaims() error {
+	_, err := s.db.Exec("UPDATE embed_queue SET claimed = 0 WHERE claimed = 1")
+	return err
+}
+
+// EnqueueReembedAll queues every prompt that isn't soft-deleted to have its
+// embedding replaced, and returns how many were queued. Prompts already
+// queued are switched to re-embedding with their attempts reset.
+func (s *Store) EnqueueReembedAll() (int, error) {
+	result, err := s.db.Exec(`
+		INSERT INTO embed_queue (prompt_id, reembed)
+		SELECT id, 1 FROM prompts WHERE deleted_at IS NULL
+		ON CONFLICT(prompt_id) DO UPDATE SET reembed = 1, attempts = 0, last_error = ''
+	`)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// GetQueueStats counts queued prompts. Prompts that reached maxAttempts are failed.
+func (s *Store) GetQueueStats(ctx context.Context, maxAttempts int) (QueueStats, error) {
+	return s.queueStats(ctx, maxAttempts, false)
+}
+
+// GetReembedStats is like GetQueueStats but counts only prompts queued by EnqueueReembedAll
+func (s *Store) GetReembedStats(ctx context.Context, maxAttempts int) (QueueStats, error) {
+	return s.queueStats(ctx, maxAttempts, true)
+}
+
+func (s *Store) queueStats(ctx context.Context, maxAttempts int, reembedOnly bool) (QueueStats, error) {
+	var stats QueueStats
+	err := s.db.QueryRowContext(ctx, `
+		SELECT
+			COALESCE(SUM(claimed = 0 AND attempts < ?), 0),
+			COALESCE(SUM(claimed = 1), 0),
+			COALESCE(SUM(claimed = 0 AND attempts >= ?), 0)
+		FROM embed_queue
+		WHERE NOT ? OR reembed = 1
+	`, maxAttempts, maxAttempts, reembedOnly).Scan(&stats.Pending, &stats.InProgress, &stats.Failed)
+	return stats, err
+}
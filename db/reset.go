@@ -0,0 +1,65 @@
+package db
+
+// ResetCounts says how many rows Reset deleted
+type ResetCounts struct {
+	Prompts     int
+	Embeddings  int
+	Projections int
+}
+
+// resetTables are the per-prompt and per-run tables Reset empties besides
+// prompts, embeddings and projections
+var resetTables = []string{"embedding_meta", "embed_queue", "tags", "metadata", "idempotency", "projection_history", "tsne_runs"}
+
+// resetMetaKeys are the meta entries describing stored data rather than how
+// the database is set up, which Reset removes with the data
+var resetMetaKeys = []string{"projection_hash", "projection_run", "projection_dimensions", "projection_quality", "tsne_history", ProjectedAtKey, projectionRunIDKey, "reembed_total"}
+
+// Reset deletes every prompt along with its embedding, projection and other
+// rows, in one transaction, and restarts prompt IDs from 1. The database's
+// settings, such as its metric and storage, are kept.
+func (s *Store) Reset() (ResetCounts, error) {
+	var counts ResetCounts
+	tx, err := s.db.Begin()
+	if err != nil {
+		return counts, err
+	}
+	defer tx.Rollback()
+
+	// Rows referring to prompts go first, so nothing is left pointing at a
+	// deleted prompt whether or not SQLite enforces the foreign keys
+	count := func(query string) (int, error) {
+		result, err := tx.Exec(query)
+		if err != nil {
+			return 0, err
+		}
+		n, err := result.RowsAffected()
+		return int(n), err
+	}
+	if counts.Projections, err = count("DELETE FROM projections"); err != nil {
+		return counts, err
+	}
+	if counts.Embeddings, err = count("DELETE FROM embeddings"); err != nil {
+		return counts, err
+	}
+	for _, table := range resetTables {
+		if _, err := tx.Exec("DELETE FROM " + table); err != nil {
+			return counts, err
+		}
+	}
+	if err := s.clearNeighborGraph(tx); err != nil {
+		return counts, err
+	}
+	if counts.Prompts, err = count("DELETE FROM prompts"); err != nil {
+		return counts, err
+	}
+	if _, err := tx.Exec("DELETE FROM sqlite_sequence WHERE name = 'prompts'"); err != nil {
+		return counts, err
+	}
+	for _, key := range resetMetaKeys {
+		if _, err := tx.Exec("DELETE FROM meta WHERE key = ?", key); err != nil {
+			return counts, err
+		}
+	}
+	return counts, tx.Commit()
+}
@@ -0,0 +1,35 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// retryBackoff is how long withRetry waits before trying again
+const retryBackoff = 50 * time.Millisecond
+
+// isLocked reports whether err is SQLite refusing a write because another
+// connection holds the lock ("database is locked" or "database table is
+// locked")
+func isLocked(err error) bool {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+}
+
+// withRetry runs fn, and if it fails because the database is locked, runs it
+// once more after retryBackoff. The busy timeout already makes writers wait,
+// but a burst of them can still outlast it. fn must be safe to repeat, e.g.
+// one transaction that rolls back on error.
+func withRetry(fn func() error) error {
+	err := fn()
+	if !isLocked(err) {
+		return err
+	}
+	time.Sleep(retryBackoff)
+	return fn()
+}
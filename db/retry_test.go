@@ -0,0 +1,97 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func TestIsLocked(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{sqlite3.Error{Code: sqlite3.ErrBusy}, true},
+		{sqlite3.Error{Code: sqlite3.ErrLocked}, true},
+		{fmt.Errorf("insert: %w", sqlite3.Error{Code: sqlite3.ErrBusy}), true},
+		{sqlite3.Error{Code: sqlite3.ErrConstraint}, false},
+		{errors.New("database is locked"), false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := isLocked(tt.err); got != tt.want {
+			t.Errorf("isLocked(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	busy := sqlite3.Error{Code: sqlite3.ErrBusy}
+	tests := []struct {
+		name      string
+		errs      []error
+		wantCalls int
+		wantErr   error
+	}{
+		{"succeeds first time", []error{nil}, 1, nil},
+		{"locked once", []error{busy, nil}, 2, nil},
+		{"locked twice", []error{busy, busy}, 2, busy},
+		// Only a locked database is worth another try
+		{"other error", []error{sql.ErrConnDone}, 1, sql.ErrConnDone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := withRetry(func() error {
+				calls++
+				return tt.errs[calls-1]
+			})
+			if calls != tt.wantCalls {
+				t.Errorf("fn called %d times, want %d", calls, tt.wantCalls)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRetryOutlastsAnotherWriter(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits out the busy timeout")
+	}
+	path := filepath.Join(t.TempDir(), "vecviz.db")
+	s, err := Open(path, "", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// Another process holds the write lock past the busy timeout, so the
+	// first attempt fails and only the retry can succeed
+	other, err := sql.Open("sqlite3", "file:"+path+"?_txlock=immediate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	tx, err := other.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec("INSERT INTO meta (key, value) VALUES ('holder', 'other')"); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(busyTimeout + time.Second)
+		tx.Commit()
+	}()
+
+	if _, err := s.InsertPrompt("contended"); err != nil {
+		t.Fatalf("InsertPrompt: %v", err)
+	}
+}
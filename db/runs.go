@@ -0,0 +1,129 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Statuses of a recorded projection run
+const (
+	RunRunning   = "running"
+	RunCompleted = "completed"
+	RunFailed    = "failed"
+	RunCancelled = "cancelled"
+	// RunInterrupted is a run the server stopped during, found on the next start
+	RunInterrupted = "interrupted"
+)
+
+// keepProjectionRuns is how many runs tsne_runs keeps; older ones are
+// deleted as new ones start
+const keepProjectionRuns = 100
+
+// ProjectionRun is one projection run as recorded in tsne_runs
+type ProjectionRun struct {
+	ID     int64
+	Method string
+	// Params is the run's effective settings as JSON
+	Params string
+	Status string
+	// Points is how many embeddings the run was given
+	Points    int
+	StartedAt time.Time
+	// Duration is how long the run took, zero if it never finished
+	Duration time.Duration
+	// History is the convergence curve as JSON, "" unless the run completed.
+	// ListProjectionRuns leaves it out.
+	History string
+	// Error says why a failed run failed
+	Error string
+}
+
+// StartProjectionRun records a run of method with the given params and
+// number of points as running, and returns its ID. IDs follow on from the
+// projection run ID, so they never repeat one that projection_history or
+// /tsne/diff may still refer to.
+func (s *Store) StartProjectionRun(method, params string, points int) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRow(`
+		SELECT MAX(
+			COALESCE((SELECT MAX(run_id) FROM tsne_runs), 0),
+			COALESCE((SELECT CAST(value AS INTEGER) FROM meta WHERE key = ?), 0)
+		) + 1
+	`, projectionRunIDKey).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO tsne_runs (run_id, method, params, status, points, started_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, id, method, params, RunRunning, points, time.Now().UTC().Format(sqliteTimeLayout)); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("DELETE FROM tsne_runs WHERE run_id <= ?", id-keepProjectionRuns); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// FinishProjectionRun records how a run ended: its status, how long it took,
+// and for a completed run its convergence history, or for a failed one the
+// error
+func (s *Store) FinishProjectionRun(id int64, status string, duration time.Duration, history, errMsg string) error {
+	_, err := s.db.Exec(`
+		UPDATE tsne_runs SET status = ?, duration_ms = ?, history = NULLIF(?, ''), error = NULLIF(?, '')
+		WHERE run_id = ?
+	`, status, duration.Milliseconds(), history, errMsg, id)
+	return err
+}
+
+// GetProjectionRun returns a recorded run, or ErrRunNotFound
+func (s *Store) GetProjectionRun(ctx context.Context, id int64) (ProjectionRun, error) {
+	runs, err := s.queryProjectionRuns(ctx, "history", "WHERE run_id = ?", id)
+	if err != nil {
+		return ProjectionRun{}, err
+	}
+	if len(runs) == 0 {
+		return ProjectionRun{}, ErrRunNotFound
+	}
+	return runs[0], nil
+}
+
+// ListProjectionRuns returns up to limit recorded runs, newest first,
+// without their history
+func (s *Store) ListProjectionRuns(ctx context.Context, limit int) ([]ProjectionRun, error) {
+	return s.queryProjectionRuns(ctx, "NULL", "ORDER BY run_id DESC LIMIT ?", limit)
+}
+
+// queryProjectionRuns reads tsne_runs rows, selecting history as the given
+// expression and filtering and ordering them with clause
+func (s *Store) queryProjectionRuns(ctx context.Context, history, clause string, args ...interface{}) ([]ProjectionRun, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT run_id, method, params, status, points, started_at, duration_ms, `+history+`, error
+		FROM tsne_runs `+clause, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []ProjectionRun{}
+	for rows.Next() {
+		var r ProjectionRun
+		var durationMS sql.NullInt64
+		var historyJSON, errMsg sql.NullString
+		if err := rows.Scan(&r.ID, &r.Method, &r.Params, &r.Status, &r.Points, &r.StartedAt, &durationMS, &historyJSON, &errMsg); err != nil {
+			return nil, err
+		}
+		r.Duration = time.Duration(durationMS.Int64) * time.Millisecond
+		r.History = historyJSON.String
+		r.Error = errMsg.String
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
@@ -0,0 +1,65 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNotEmpty is returned by SeedFromReader when the database already has
+// prompts
+var ErrNotEmpty = errors.New("database already has prompts")
+
+// SeedFromReader loads an empty database from JSONL, one
+// {"text": "...", "vector": [...]} per line, and returns how many prompts it
+// stored and how many lines it skipped as repeating an earlier text (compared
+// as PromptNormalization does). A database that already has prompts is left
+// untouched with ErrNotEmpty, so a seed only ever applies on first run. Every
+// line is checked before anything is stored, and everything is stored in one
+// transaction, so a bad line or a crash leaves the database empty for the
+// next start to seed again.
+func (s *Store) SeedFromReader(r io.Reader) (seeded, skipped int, err error) {
+	var hasPrompts bool
+	if err := s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM prompts)").Scan(&hasPrompts); err != nil {
+		return 0, 0, err
+	}
+	if hasPrompts {
+		return 0, 0, ErrNotEmpty
+	}
+
+	var records []ImportRecord
+	reader := bufio.NewReader(r)
+	for lineNum := 1; ; lineNum++ {
+		line, readErr := reader.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return 0, 0, readErr
+		}
+
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			var record struct {
+				Text   string    `json:"text"`
+				Vector []float32 `json:"vector"`
+			}
+			if err := json.Unmarshal(line, &record); err != nil {
+				return 0, 0, fmt.Errorf("line %d: %w", lineNum, err)
+			}
+			if record.Text == "" {
+				return 0, 0, fmt.Errorf("line %d: text is required", lineNum)
+			}
+			if err := checkDimension(record.Vector); err != nil {
+				return 0, 0, fmt.Errorf("line %d: %w", lineNum, err)
+			}
+			records = append(records, ImportRecord{Text: record.Text, Vector: record.Vector})
+		}
+
+		if readErr == io.EOF {
+			break
+		}
+	}
+
+	// ImportPrompts skips a record whose text an earlier one already stored
+	return s.ImportPrompts(records, false)
+}
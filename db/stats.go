@@ -0,0 +1,62 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// ProjectedAtKey is the meta key holding when projections were last computed, as RFC3339
+const ProjectedAtKey = "projected_at"
+
+// Summary is an overview of the database for monitoring
+type Summary struct {
+	Prompts     int
+	Embeddings  int
+	Projections int
+	Dimension   int
+	Metric      string
+	// ProjectedAt is when projections were last computed, or the zero time if never
+	ProjectedAt time.Time
+	// SizeBytes is the size of the main database file, excluding the WAL
+	SizeBytes int64
+}
+
+// Stats gathers row counts, schema settings and the database size
+func (s *Store) Stats(ctx context.Context) (Summary, error) {
+	stats := Summary{Dimension: Dimension, Metric: s.Metric}
+
+	err := s.db.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM prompts),
+			(SELECT COUNT(*) FROM embeddings),
+			(SELECT COUNT(*) FROM projections)
+	`).Scan(&stats.Prompts, &stats.Embeddings, &stats.Projections)
+	if err != nil {
+		return Summary{}, err
+	}
+
+	var pageCount, pageSize int64
+	if err := s.db.QueryRowContext(ctx, "PRAGMA page_count").Scan(&pageCount); err != nil {
+		return Summary{}, err
+	}
+	if err := s.db.QueryRowContext(ctx, "PRAGMA page_size").Scan(&pageSize); err != nil {
+		return Summary{}, err
+	}
+	stats.SizeBytes = pageCount * pageSize
+
+	projectedAt, err := s.GetMeta(ProjectedAtKey)
+	if err != nil {
+		return Summary{}, err
+	}
+	if projectedAt != "" {
+		stats.ProjectedAt, _ = time.Parse(time.RFC3339, projectedAt)
+	}
+	return stats, nil
+}
+
+// Versions reports the versions of the SQLite library and the sqlite-vec
+// extension the database runs on
+func (s *Store) Versions(ctx context.Context) (sqliteVersion, vecVersion string, err error) {
+	err = s.db.QueryRowContext(ctx, "SELECT sqlite_version(), vec_version()").Scan(&sqliteVersion, &vecVersion)
+	return sqliteVersion, vecVersion, err
+}
@@ -0,0 +1,73 @@
+package db
+
+import "context"
+
+// AddTag labels a prompt with a tag. Adding an existing tag is a no-op.
+func (s *Store) AddTag(promptID int64, tag string) error {
+	_, err := s.db.Exec("INSERT OR IGNORE INTO tags (prompt_id, tag) VALUES (?, ?)", promptID, tag)
+	return err
+}
+
+// RemoveTag removes a tag from a prompt
+func (s *Store) RemoveTag(promptID int64, tag string) error {
+	_, err := s.db.Exec("DELETE FROM tags WHERE prompt_id = ? AND tag = ?", promptID, tag)
+	return err
+}
+
+// GetTags returns a prompt's tags in alphabetical order
+func (s *Store) GetTags(ctx context.Context, promptID int64) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT tag FROM tags WHERE prompt_id = ? ORDER BY tag", promptID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []string{}
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// GetAllTags returns every prompt's tags, keyed by prompt ID
+func (s *Store) GetAllTags(ctx context.Context) (map[int64][]string, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT prompt_id, tag FROM tags ORDER BY prompt_id, tag")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := make(map[int64][]string)
+	for rows.Next() {
+		var promptID int64
+		var tag string
+		if err := rows.Scan(&promptID, &tag); err != nil {
+			return nil, err
+		}
+		tags[promptID] = append(tags[promptID], tag)
+	}
+	return tags, rows.Err()
+}
+
+// PromptIDsWithTag returns the IDs of the prompts labeled with tag, in order
+func (s *Store) PromptIDsWithTag(ctx context.Context, tag string) ([]int64, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT prompt_id FROM tags WHERE tag = ? ORDER BY prompt_id", tag)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,62 @@
+package db
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Vectors are stored as vec0 expects a float32 column: each component as
+// 4 little-endian bytes, no header. Both directions live here so reads can't
+// drift from writes.
+
+// serializeFloat32 converts a []float32 to a BLOB
+func serializeFloat32(vector []float32) []byte {
+	blob := make([]byte, 4*len(vector))
+	for i, x := range vector {
+		binary.LittleEndian.PutUint32(blob[4*i:], math.Float32bits(x))
+	}
+	return blob
+}
+
+// deserializeFloat32 converts a BLOB back to []float32
+func deserializeFloat32(blob []byte) ([]float32, error) {
+	if len(blob)%4 != 0 {
+		return nil, fmt.Errorf("corrupt embedding blob: length %d not multiple of 4", len(blob))
+	}
+	vector := make([]float32, len(blob)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(blob[4*i:]))
+	}
+	return vector, nil
+}
+
+// Projection coordinates are stored the same way at float64 precision: each
+// axis as 8 little-endian bytes
+
+// serializeCoords converts projection coordinates to a BLOB, or nil for none
+func serializeCoords(coords []float64) []byte {
+	if len(coords) == 0 {
+		return nil
+	}
+	blob := make([]byte, 8*len(coords))
+	for i, x := range coords {
+		binary.LittleEndian.PutUint64(blob[8*i:], math.Float64bits(x))
+	}
+	return blob
+}
+
+// deserializeCoords converts a BLOB back to coordinates, nil for a NULL one
+func deserializeCoords(blob []byte) ([]float64, error) {
+	if blob == nil {
+		return nil, nil
+	}
+	if len(blob)%8 != 0 {
+		return nil, fmt.Errorf("corrupt coords blob: length %d not multiple of 8", len(blob))
+	}
+	coords := make([]float64, len(blob)/8)
+	for i := range coords {
+		coords[i] = math.Float64frombits(binary.LittleEndian.Uint64(blob[8*i:]))
+	}
+	return coords, nil
+}
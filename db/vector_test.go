@@ -0,0 +1,56 @@
+package db
+
+import (
+	"bytes"
+	"math"
+	"slices"
+	"testing"
+
+	sqlite_vec "github.com/asg017/sqlite-vec-go-bindings/cgo"
+)
+
+func TestFloat32RoundTrip(t *testing.T) {
+	vector := []float32{0, 1, -1, 0.1, math.MaxFloat32, math.SmallestNonzeroFloat32, float32(math.Inf(-1))}
+
+	blob := serializeFloat32(vector)
+	// vec0 reads what sqlite-vec's own serializer writes
+	want, err := sqlite_vec.SerializeFloat32(vector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(blob, want) {
+		t.Errorf("serializeFloat32 = %x, want sqlite-vec's %x", blob, want)
+	}
+
+	got, err := deserializeFloat32(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, vector) {
+		t.Errorf("round trip gave %v, want %v", got, vector)
+	}
+}
+
+func TestCoordsRoundTrip(t *testing.T) {
+	coords := []float64{0.5, -0.25, 1e-300, 3}
+	got, err := deserializeCoords(serializeCoords(coords))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, coords) {
+		t.Errorf("round trip gave %v, want %v", got, coords)
+	}
+
+	if got, err := deserializeCoords(serializeCoords(nil)); got != nil || err != nil {
+		t.Errorf("no coords: got %v, %v, want nil, nil", got, err)
+	}
+}
+
+func TestCorruptBlobs(t *testing.T) {
+	if _, err := deserializeFloat32([]byte{1, 2, 3}); err == nil || err.Error() != "corrupt embedding blob: length 3 not multiple of 4" {
+		t.Errorf("3-byte embedding blob: err = %v, want a corrupt blob error", err)
+	}
+	if _, err := deserializeCoords([]byte{1, 2, 3}); err == nil {
+		t.Error("3-byte coords blob: no error")
+	}
+}
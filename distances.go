@@ -0,0 +1,167 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/tlehman/vecviz/db"
+	"github.com/tlehman/vecviz/vecmath"
+)
+
+const (
+	// defaultMaxDistancePoints is how many embeddings /distances returns a
+	// full matrix for unless VECVIZ_MAX_DISTANCE_POINTS says otherwise
+	defaultMaxDistancePoints = 1000
+	// sparseDistanceFactor is how many times more embeddings /distances
+	// accepts with ?k=, since the response then grows linearly
+	sparseDistanceFactor = 10
+)
+
+// GET /distances?metric=cosine|l2&k= - Pairwise distances among all embeddings
+//
+// Without k the response holds the full N×N matrix, with rows and columns in
+// the order of "ids". With k each row instead lists that prompt's k nearest
+// other prompts, closest first. metric defaults to the database's. Soft-deleted
+// prompts are left out.
+//
+// Every pair is compared either way, so N is capped: the full matrix at
+// VECVIZ_MAX_DISTANCE_POINTS (default 1000) and ?k= at ten times that.
+func (s *Server) handleDistances(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	metric := s.store.Metric
+	if v := r.URL.Query().Get("metric"); v != "" {
+		if v != db.MetricL2 && v != db.MetricCosine {
+			writeJSONError(w, http.StatusBadRequest, "metric must be l2 or cosine")
+			return
+		}
+		metric = v
+	}
+
+	k := 0
+	if v := r.URL.Query().Get("k"); v != "" {
+		var err error
+		k, err = strconv.Atoi(v)
+		if err != nil || k < 1 {
+			writeJSONError(w, http.StatusBadRequest, "Invalid k")
+			return
+		}
+	}
+
+	embeddings, err := s.store.GetAllEmbeddings(r.Context())
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to get embeddings: "+err.Error())
+		return
+	}
+
+	n := len(embeddings)
+	if k == 0 && n > s.maxDistancePoints {
+		writeJSONError(w, http.StatusBadRequest, "There are "+strconv.Itoa(n)+" embeddings but a full distance matrix is limited to "+
+			strconv.Itoa(s.maxDistancePoints)+" (VECVIZ_MAX_DISTANCE_POINTS); pass k for each prompt's nearest neighbors instead")
+		return
+	}
+	if k > 0 && n > s.maxDistancePoints*sparseDistanceFactor {
+		writeJSONError(w, http.StatusBadRequest, "There are "+strconv.Itoa(n)+" embeddings but nearest-neighbor distances are limited to "+
+			strconv.Itoa(s.maxDistancePoints*sparseDistanceFactor)+" (ten times VECVIZ_MAX_DISTANCE_POINTS)")
+		return
+	}
+
+	// Ordering by id keeps rows stable between calls
+	slices.SortFunc(embeddings, func(a, b db.EmbeddingData) int { return cmp.Compare(a.PromptID, b.PromptID) })
+	ids := make([]int64, n)
+	vectors := make([][]float32, n)
+	for i, e := range embeddings {
+		ids[i] = e.PromptID
+		vectors[i] = e.Vector
+	}
+
+	resp := map[string]interface{}{
+		"metric": metric,
+		"ids":    ids,
+	}
+	if k == 0 {
+		resp["distances"] = distanceMatrix(metric, vectors)
+	} else {
+		k = min(k, max(n-1, 0))
+		resp["k"] = k
+		resp["neighbors"] = nearestByDistance(metric, ids, vectors, k)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// pairwiseDistances calls row(i, d) with each vector's distances to every
+// vector, spreading rows over the CPUs. Cosine vectors are normalized once up
+// front so each pair costs a single dot product.
+func pairwiseDistances(metric string, vectors [][]float32, row func(i int, d []float64)) {
+	dist := func(a, b []float32) float64 { return vecmath.L2Distance(a, b) }
+	if metric == db.MetricCosine {
+		normalized := make([][]float32, len(vectors))
+		for i, v := range vectors {
+			normalized[i] = vecmath.Normalize(v)
+		}
+		vectors = normalized
+		dist = func(a, b []float32) float64 { return 1 - vecmath.Dot(a, b) }
+	}
+
+	rows := make(chan int)
+	var wg sync.WaitGroup
+	for range runtime.GOMAXPROCS(0) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range rows {
+				d := make([]float64, len(vectors))
+				for j, v := range vectors {
+					if j != i {
+						d[j] = dist(vectors[i], v)
+					}
+				}
+				row(i, d)
+			}
+		}()
+	}
+	for i := range vectors {
+		rows <- i
+	}
+	close(rows)
+	wg.Wait()
+}
+
+// distanceMatrix returns every vector's distance to every other
+func distanceMatrix(metric string, vectors [][]float32) [][]float64 {
+	matrix := make([][]float64, len(vectors))
+	pairwiseDistances(metric, vectors, func(i int, d []float64) { matrix[i] = d })
+	return matrix
+}
+
+// nearestByDistance returns, for each vector, its k nearest other vectors
+// as {"id", "distance"}, closest first
+func nearestByDistance(metric string, ids []int64, vectors [][]float32, k int) [][]map[string]interface{} {
+	neighbors := make([][]map[string]interface{}, len(vectors))
+	pairwiseDistances(metric, vectors, func(i int, d []float64) {
+		order := make([]int, 0, len(d)-1)
+		for j := range d {
+			if j != i {
+				order = append(order, j)
+			}
+		}
+		slices.SortFunc(order, func(a, b int) int { return cmp.Compare(d[a], d[b]) })
+
+		row := make([]map[string]interface{}, k)
+		for r, j := range order[:k] {
+			row[r] = map[string]interface{}{"id": ids[j], "distance": d[j]}
+		}
+		neighbors[i] = row
+	})
+	return neighbors
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tlehman/vecviz/db"
+	"github.com/tlehman/vecviz/ollama"
+	"github.com/tlehman/vecviz/openai"
+)
+
+// Embedder turns text into an embedding vector. Both backends also have a
+// Ping(ctx) error used by /healthz.
+type Embedder interface {
+	Embed(ctx context.Context, text string) ([]float32, error)
+	Ping(ctx context.Context) error
+}
+
+// resultEmbedder is an Embedder that also reports what each embed cost, as
+// Ollama does. The wrappers newEmbedder adds pass it through.
+type resultEmbedder interface {
+	EmbedResult(ctx context.Context, text string) (*ollama.EmbeddingResult, error)
+}
+
+// embedResult embeds text with e, along with the cost e reports if it is a
+// resultEmbedder. Other backends give a result with only the embedding set.
+func embedResult(ctx context.Context, e Embedder, text string) (*ollama.EmbeddingResult, error) {
+	if r, ok := e.(resultEmbedder); ok {
+		return r.EmbedResult(ctx, text)
+	}
+	embedding, err := e.Embed(ctx, text)
+	if err != nil {
+		return nil, err
+	}
+	return &ollama.EmbeddingResult{Embedding: embedding}, nil
+}
+
+// defaultEmbedLimit is how many embedding calls may be in flight across the
+// whole server when neither -max-embeds nor VECVIZ_MAX_EMBEDS is set
+const defaultEmbedLimit = 4
+
+// newEmbedder builds the backend named by VECVIZ_EMBEDDER: "ollama" (the
+// default) or "openai" for any OpenAI-compatible /v1/embeddings API. Either
+// way VECVIZ_MODEL picks the model, or VECVIZ_MODELS and VECVIZ_COMBINE
+// several whose vectors are merged (see multiModelEmbedder). At most limit
+// Embed calls run at once;
+// if limit is zero, VECVIZ_MAX_EMBEDS or defaultEmbedLimit is used. Ollama is
+// called at ollamaURL, or ollama.DefaultBaseURL if it is empty; the OpenAI
+// backend ignores it.
+func newEmbedder(limit int, ollamaURL string) (Embedder, string, error) {
+	if limit == 0 {
+		limit = defaultEmbedLimit
+		if s := os.Getenv("VECVIZ_MAX_EMBEDS"); s != "" {
+			var err error
+			limit, err = strconv.Atoi(s)
+			if err != nil {
+				return nil, "", fmt.Errorf("invalid VECVIZ_MAX_EMBEDS: %w", err)
+			}
+		}
+	}
+	if limit < 1 {
+		return nil, "", fmt.Errorf("embedding concurrency limit must be at least 1, got %d", limit)
+	}
+
+	backend := os.Getenv("VECVIZ_EMBEDDER")
+	if backend == "" {
+		backend = "ollama"
+	}
+
+	// newClient builds a client for one model. expectedDim, if non-zero, is
+	// the length its vectors must have, for backends that check as they answer.
+	var newClient func(model string, expectedDim int) Embedder
+	switch backend {
+	case "ollama":
+		var opts []ollama.Option
+		if s := os.Getenv("VECVIZ_OLLAMA_TIMEOUT"); s != "" {
+			timeout, err := time.ParseDuration(s)
+			if err != nil {
+				return nil, "", fmt.Errorf("invalid VECVIZ_OLLAMA_TIMEOUT: %w", err)
+			}
+			opts = append(opts, ollama.WithTimeout(timeout))
+		}
+		if ollamaURL != "" {
+			var err error
+			if ollamaURL, err = normalizeOllamaURL(ollamaURL); err != nil {
+				return nil, "", err
+			}
+		}
+		newClient = func(model string, expectedDim int) Embedder {
+			client := ollama.NewClient(ollamaURL, model, opts...)
+			client.ExpectedDim = expectedDim
+			return client
+		}
+	case "openai":
+		newClient = func(model string, _ int) Embedder {
+			return openai.NewClient(os.Getenv("VECVIZ_OPENAI_BASE_URL"), model, os.Getenv("VECVIZ_OPENAI_API_KEY"))
+		}
+	default:
+		return nil, "", fmt.Errorf("unknown embedder %q, expected ollama or openai", backend)
+	}
+
+	var e Embedder
+	if models := os.Getenv("VECVIZ_MODELS"); models != "" {
+		if os.Getenv("VECVIZ_MODEL") != "" {
+			return nil, "", errors.New("set either VECVIZ_MODEL or VECVIZ_MODELS, not both")
+		}
+		var err error
+		if e, err = newMultiModelEmbedder(models, os.Getenv("VECVIZ_COMBINE"), newClient); err != nil {
+			return nil, "", err
+		}
+	} else {
+		e = newClient(os.Getenv("VECVIZ_MODEL"), db.Dimension)
+	}
+	return limited{dimensionChecked{e}, make(chan struct{}, limit)}, backend, nil
+}
+
+// parseOllamaURLs parses VECVIZ_OLLAMA_URLS, the comma-separated Ollama
+// servers a request may pick with "ollama_url"
+func parseOllamaURLs(s string) (map[string]bool, error) {
+	urls := make(map[string]bool)
+	for _, raw := range strings.Split(s, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		u, err := normalizeOllamaURL(raw)
+		if err != nil {
+			return nil, err
+		}
+		urls[u] = true
+	}
+	return urls, nil
+}
+
+// normalizeOllamaURL checks that raw is an http(s) base URL and drops any
+// trailing slash, so "http://host:11434/" and "http://host:11434" match
+func normalizeOllamaURL(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid Ollama URL %q: %w", raw, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid Ollama URL %q, expected http(s)://host[:port]", raw)
+	}
+	if u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return "", fmt.Errorf("invalid Ollama URL %q, expected no credentials, query or fragment", raw)
+	}
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
+
+// errNotOllama is returned by withOllamaURL for an embedder that isn't Ollama
+var errNotOllama = errors.New("ollama_url requires VECVIZ_EMBEDDER=ollama")
+
+// withOllamaURL returns e calling the Ollama server at baseURL instead. The
+// result keeps e's dimension check and shares its concurrency limit, so
+// routed requests still count against -max-embeds.
+func withOllamaURL(e Embedder, baseURL string) (Embedder, error) {
+	l, ok := e.(limited)
+	if !ok {
+		return nil, errNotOllama
+	}
+	client, ok := backendOf(e).(*ollama.Client)
+	if !ok {
+		if _, multi := backendOf(e).(*multiModelEmbedder); multi {
+			return nil, errors.New("ollama_url can't be used with VECVIZ_MODELS")
+		}
+		return nil, errNotOllama
+	}
+	return limited{dimensionChecked{client.WithBaseURL(baseURL)}, l.sem}, nil
+}
+
+// backendOf returns the backend client inside the wrappers newEmbedder adds
+func backendOf(e Embedder) Embedder {
+	for {
+		switch w := e.(type) {
+		case limited:
+			e = w.Embedder
+		case dimensionChecked:
+			e = w.Embedder
+		default:
+			return e
+		}
+	}
+}
+
+// embedderModel returns the model e embeds with, or "" if it can't tell,
+// e.g. for a fake Embedder in tests
+func embedderModel(e Embedder) string {
+	switch client := backendOf(e).(type) {
+	case *ollama.Client:
+		return client.Model
+	case *openai.Client:
+		return client.Model
+	case *multiModelEmbedder:
+		return client.Model()
+	}
+	return ""
+}
+
+// embedderFor returns the embedder for a request's ollama_url: the default
+// one if it is empty, or a client for that server if VECVIZ_OLLAMA_URLS lists
+// it. Only listed servers are accepted, so requests can't make vecviz call
+// arbitrary URLs.
+func (s *Server) embedderFor(ollamaURL string) (Embedder, error) {
+	if ollamaURL == "" {
+		return s.embedder, nil
+	}
+	u, err := normalizeOllamaURL(ollamaURL)
+	if err != nil {
+		return nil, err
+	}
+	if !s.ollamaURLs[u] {
+		return nil, fmt.Errorf("ollama_url %s is not listed in VECVIZ_OLLAMA_URLS", u)
+	}
+	return withOllamaURL(s.embedder, u)
+}
+
+// limited caps how many Embed calls run at once, so batch imports and the
+// background queue together can't overwhelm the backend. Ping is not limited.
+type limited struct {
+	Embedder
+	sem chan struct{}
+}
+
+func (l limited) Embed(ctx context.Context, text string) ([]float32, error) {
+	select {
+	case l.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-l.sem }()
+	return l.Embedder.Embed(ctx, text)
+}
+
+func (l limited) EmbedResult(ctx context.Context, text string) (*ollama.EmbeddingResult, error) {
+	select {
+	case l.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-l.sem }()
+	return embedResult(ctx, l.Embedder, text)
+}
+
+// dimensionChecked rejects embeddings whose length doesn't match the
+// embeddings table, so a backend or model producing a different dimension is
+// caught when it answers rather than when its vectors are stored or compared
+type dimensionChecked struct {
+	Embedder
+}
+
+func (d dimensionChecked) Embed(ctx context.Context, text string) ([]float32, error) {
+	embedding, err := d.Embedder.Embed(ctx, text)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkEmbeddingDimension(embedding); err != nil {
+		return nil, err
+	}
+	return embedding, nil
+}
+
+func (d dimensionChecked) EmbedResult(ctx context.Context, text string) (*ollama.EmbeddingResult, error) {
+	result, err := embedResult(ctx, d.Embedder, text)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkEmbeddingDimension(result.Embedding); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// embedErrorStatus is the status for a request whose embed failed with err:
+// 424 Failed Dependency if the backend lacks the model, which retrying won't
+// fix until someone pulls it, otherwise 500
+func embedErrorStatus(err error) int {
+	if errors.Is(err, ollama.ErrModelNotFound) {
+		return http.StatusFailedDependency
+	}
+	return http.StatusInternalServerError
+}
+
+// checkEmbeddingDimension rejects an embedding the embeddings table can't store
+func checkEmbeddingDimension(embedding []float32) error {
+	if len(embedding) != db.Dimension {
+		return fmt.Errorf("embedding has dimension %d, but the database stores %d; check VECVIZ_MODEL", len(embedding), db.Dimension)
+	}
+	return nil
+}
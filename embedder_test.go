@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// trackingEmbedder records the most Embed calls it has seen running at once
+type trackingEmbedder struct {
+	fakeEmbedder
+	running, peak atomic.Int64
+}
+
+func (e *trackingEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	n := e.running.Add(1)
+	defer e.running.Add(-1)
+	for {
+		peak := e.peak.Load()
+		if n <= peak || e.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	// Long enough for the other callers to pile up
+	time.Sleep(10 * time.Millisecond)
+	return e.fakeEmbedder.Embed(ctx, text)
+}
+
+func TestLimitedNeverExceedsItsCap(t *testing.T) {
+	const limit = 3
+	backend := &trackingEmbedder{}
+	embedder := limited{backend, make(chan struct{}, limit)}
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := embedder.Embed(context.Background(), "text"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if peak := backend.peak.Load(); peak > limit {
+		t.Errorf("%d calls ran at once, want at most %d", peak, limit)
+	}
+	if calls := backend.calls.Load(); calls != 20 {
+		t.Errorf("%d calls made, want all 20", calls)
+	}
+}
+
+func TestLimitedGivesUpWhenCanceled(t *testing.T) {
+	embedder := limited{&fakeEmbedder{}, make(chan struct{}, 1)}
+	// Hold the only slot
+	embedder.sem <- struct{}{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := embedder.Embed(ctx, "text"); err != context.DeadlineExceeded {
+		t.Errorf("err = %v, want the context's deadline", err)
+	}
+}
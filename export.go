@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tlehman/vecviz/db"
+)
+
+// exportRecord is the JSON shape of one exported prompt, also accepted by /import
+type exportRecord struct {
+	ID         int64             `json:"id"`
+	Text       string            `json:"text"`
+	CreatedAt  string            `json:"created_at"`
+	Embedding  []float32         `json:"embedding"`
+	EmbeddedAt string            `json:"embedded_at,omitempty"`
+	Model      string            `json:"model,omitempty"`
+	Projection *exportProjection `json:"projection"`
+}
+
+type exportProjection struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Z float64 `json:"z"`
+}
+
+// GET /export?format=json|csv - Stream every prompt with its embedding and projection
+//
+// JSON is an array of objects. CSV has one row per prompt with the embedding
+// flattened into columns e0..e{dim-1}; missing embeddings or projections are
+// left blank. Rows are streamed, so errors after the first row can only be
+// logged, not reported with a status code.
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var err error
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "", "json":
+		err = s.exportJSON(r.Context(), w)
+	case "csv":
+		err = s.exportCSV(r.Context(), w)
+	default:
+		writeJSONError(w, http.StatusBadRequest, "Format must be json or csv")
+		return
+	}
+	if err != nil {
+		slog.Error("Export failed", "format", format, "err", err)
+	}
+}
+
+func (s *Server) exportJSON(ctx context.Context, w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="vecviz.json"`)
+
+	if _, err := w.Write([]byte("[")); err != nil {
+		return err
+	}
+	first := true
+	err := s.store.ForEachExportRow(ctx, func(row db.ExportRow) error {
+		record := exportRecord{
+			ID:        row.PromptID,
+			Text:      row.Text,
+			CreatedAt: row.CreatedAt.UTC().Format(time.RFC3339),
+			Embedding: row.Vector,
+			Model:     row.Model,
+		}
+		if !row.EmbeddedAt.IsZero() {
+			record.EmbeddedAt = row.EmbeddedAt.UTC().Format(time.RFC3339)
+		}
+		if row.Projection != nil {
+			record.Projection = &exportProjection{X: row.Projection.X, Y: row.Projection.Y, Z: row.Projection.Z}
+		}
+
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		if !first {
+			if _, err := w.Write([]byte(",\n")); err != nil {
+				return err
+			}
+		}
+		first = false
+		_, err = w.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write([]byte("]\n"))
+	return err
+}
+
+func (s *Server) exportCSV(ctx context.Context, w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="vecviz.csv"`)
+
+	cw := csv.NewWriter(w)
+	header := []string{"id", "text", "created_at", "embedded_at", "model", "x", "y", "z"}
+	for i := 0; i < db.Dimension; i++ {
+		header = append(header, "e"+strconv.Itoa(i))
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	record := make([]string, len(header))
+	err := s.store.ForEachExportRow(ctx, func(row db.ExportRow) error {
+		clear(record)
+		record[0] = strconv.FormatInt(row.PromptID, 10)
+		record[1] = row.Text
+		record[2] = row.CreatedAt.UTC().Format(time.RFC3339)
+		if !row.EmbeddedAt.IsZero() {
+			record[3] = row.EmbeddedAt.UTC().Format(time.RFC3339)
+		}
+		record[4] = row.Model
+		if row.Projection != nil {
+			record[5] = strconv.FormatFloat(row.Projection.X, 'g', -1, 64)
+			record[6] = strconv.FormatFloat(row.Projection.Y, 'g', -1, 64)
+			record[7] = strconv.FormatFloat(row.Projection.Z, 'g', -1, 64)
+		}
+		for i, v := range row.Vector {
+			if 8+i < len(record) {
+				record[8+i] = strconv.FormatFloat(float64(v), 'g', -1, 32)
+			}
+		}
+		return cw.Write(record)
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/tlehman/vecviz/vecmath"
+)
+
+// scoredPrompt is a prompt with its distance and cosine similarity to a query
+type scoredPrompt struct {
+	id         int64
+	distance   float64
+	similarity float64
+}
+
+// nearestFirstHeap is a min-heap on distance, so the closest kept prompt is evicted first
+type nearestFirstHeap []scoredPrompt
+
+func (h nearestFirstHeap) Len() int           { return len(h) }
+func (h nearestFirstHeap) Less(i, j int) bool { return h[i].distance < h[j].distance }
+func (h nearestFirstHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *nearestFirstHeap) Push(x any)        { *h = append(*h, x.(scoredPrompt)) }
+func (h *nearestFirstHeap) Pop() any {
+	old := *h
+	p := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return p
+}
+
+// POST /search/farthest - Find the prompts least similar to a query
+//
+// sqlite-vec KNN only finds nearest neighbors, so this scans every stored
+// embedding and keeps the k farthest in a heap. The cost is O(n) in the
+// number of embeddings, which is fine for thousands of prompts but will be
+// slow for very large databases. Results carry the cosine "similarity"
+// alongside the L2 "distance".
+func (s *Server) handleSearchFarthest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req struct {
+		Prompt string `json:"prompt"`
+		K      int    `json:"k"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	if req.Prompt == "" {
+		writeJSONError(w, http.StatusBadRequest, "Prompt is required")
+		return
+	}
+	k, err := s.checkK(req.K)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	req.K = k
+
+	query, err := s.embedder.Embed(r.Context(), req.Prompt)
+	if err != nil {
+		slog.Error("Embed failed", "query_chars", len(req.Prompt), "err", err)
+		writeJSONError(w, embedErrorStatus(err), "Failed to get embedding: "+err.Error())
+		return
+	}
+
+	embeddings, err := s.store.GetAllEmbeddings(r.Context())
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to get embeddings: "+err.Error())
+		return
+	}
+
+	h := &nearestFirstHeap{}
+	for _, e := range embeddings {
+		if len(e.Vector) != len(query) {
+			continue
+		}
+		distance := vecmath.L2Distance(query, e.Vector)
+		if h.Len() < req.K {
+			heap.Push(h, scoredPrompt{id: e.PromptID, distance: distance, similarity: vecmath.Cosine(query, e.Vector)})
+		} else if distance > (*h)[0].distance {
+			(*h)[0] = scoredPrompt{id: e.PromptID, distance: distance, similarity: vecmath.Cosine(query, e.Vector)}
+			heap.Fix(h, 0)
+		}
+	}
+
+	// Drain nearest-first to produce a farthest-first list
+	results := make([]map[string]interface{}, h.Len())
+	for i := len(results) - 1; i >= 0; i-- {
+		p := heap.Pop(h).(scoredPrompt)
+		text, err := s.store.GetPromptText(r.Context(), p.id)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to get prompt: "+err.Error())
+			return
+		}
+		results[i] = map[string]interface{}{
+			"id":         p.id,
+			"text":       text,
+			"distance":   p.distance,
+			"similarity": p.similarity,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"query":   req.Prompt,
+		"results": results,
+	})
+}
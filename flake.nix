@@ -14,6 +14,7 @@
         pythonEnv = pkgs.python3.withPackages (ps: with ps; [
           numpy
           scikit-learn
+          umap-learn
         ]);
       in
       {
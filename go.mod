@@ -3,6 +3,11 @@ module github.com/tlehman/vecviz
 go 1.25.4
 
 require (
-	github.com/asg017/sqlite-vec-go-bindings v0.1.6 // indirect
-	github.com/mattn/go-sqlite3 v1.14.33 // indirect
+	github.com/asg017/sqlite-vec-go-bindings v0.1.6
+	github.com/fsnotify/fsnotify v1.10.1
+	github.com/gorilla/websocket v1.5.3
+	github.com/mattn/go-sqlite3 v1.14.33
+	golang.org/x/sync v0.19.0
 )
+
+require golang.org/x/sys v0.22.0 // indirect
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tlehman/vecviz/db"
+)
+
+// defaultGraphK is how many neighbors /graph/build keeps per point when k is not given
+const defaultGraphK = 15
+
+// POST /graph/build?k=15 - Precompute every point's k nearest neighbors
+//
+// /points/{id}/neighbors then reads from the graph for any k up to this one
+// instead of running a KNN query. Storing or deleting an embedding discards
+// the graph, and neighbors go back to live queries until it is rebuilt. The
+// build runs a KNN query per point, so it is slow for large sets; it fails
+// with 409 if embeddings change while it runs.
+func (s *Server) handleGraphBuild(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	k := defaultGraphK
+	if v := r.URL.Query().Get("k"); v != "" {
+		var err error
+		k, err = strconv.Atoi(v)
+		if err != nil || k < 1 || k > db.MaxGraphK {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("k must be between 1 and %d", db.MaxGraphK))
+			return
+		}
+	}
+
+	start := time.Now()
+	points, edges, err := s.store.BuildNeighborGraph(r.Context(), k)
+	if errors.Is(err, db.ErrGraphChanged) {
+		writeJSONError(w, http.StatusConflict, err.Error()+", try again")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to build neighbor graph: "+err.Error())
+		return
+	}
+	elapsed := time.Since(start)
+	slog.Info("Built neighbor graph", "k", k, "points", points, "edges", edges, "duration", elapsed)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"k":             k,
+		"points":        points,
+		"edges":         edges,
+		"build_time_ms": elapsed.Milliseconds(),
+	})
+}
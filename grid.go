@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// maxGridCells bounds /points/grid, since the assignment is cubic in size
+const maxGridCells = 1024
+
+// GET /points/grid?cols=&rows= - Lay out projected points on a grid, one point per cell
+//
+// The stored x/y coordinates are scaled onto the grid and each point is
+// assigned to a distinct cell minimizing total squared displacement (a linear
+// assignment problem), so neighbors in the projection stay neighbors on the
+// grid. Without cols/rows the smallest square grid that fits is used.
+func (s *Server) handlePointsGrid(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	projections, err := s.store.GetAllProjections(r.Context(), false)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to get projections: "+err.Error())
+		return
+	}
+
+	side := int(math.Ceil(math.Sqrt(float64(len(projections)))))
+	cols, rows := side, side
+	for name, dst := range map[string]*int{"cols": &cols, "rows": &rows} {
+		if v := r.URL.Query().Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				writeJSONError(w, http.StatusBadRequest, "Invalid "+name)
+				return
+			}
+			*dst = n
+		}
+	}
+	if cols*rows > maxGridCells {
+		writeJSONError(w, http.StatusBadRequest, "Grid may have at most "+strconv.Itoa(maxGridCells)+" cells")
+		return
+	}
+	if cols*rows < len(projections) {
+		writeJSONError(w, http.StatusBadRequest, "Grid has "+strconv.Itoa(cols*rows)+" cells but there are "+strconv.Itoa(len(projections))+" points")
+		return
+	}
+
+	points := make([][2]float64, len(projections))
+	for i, p := range projections {
+		points[i] = [2]float64{p.X, p.Y}
+	}
+	cells := assignGrid(points, cols, rows)
+
+	results := make([]map[string]interface{}, len(projections))
+	for i, p := range projections {
+		results[i] = map[string]interface{}{
+			"id":   p.PromptID,
+			"text": p.Text,
+			"col":  cells[i] % cols,
+			"row":  cells[i] / cols,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"cols":   cols,
+		"rows":   rows,
+		"points": results,
+	})
+}
+
+// assignGrid scales points onto a cols×rows grid and returns a distinct cell
+// index (row*cols + col) for each point, minimizing total squared distance
+func assignGrid(points [][2]float64, cols, rows int) []int {
+	if len(points) == 0 {
+		return nil
+	}
+
+	minX, maxX := points[0][0], points[0][0]
+	minY, maxY := points[0][1], points[0][1]
+	for _, p := range points {
+		minX, maxX = math.Min(minX, p[0]), math.Max(maxX, p[0])
+		minY, maxY = math.Min(minY, p[1]), math.Max(maxY, p[1])
+	}
+	scale := func(v, lo, hi float64, n int) float64 {
+		if hi == lo {
+			return float64(n-1) / 2
+		}
+		return (v - lo) / (hi - lo) * float64(n-1)
+	}
+
+	cost := make([][]float64, len(points))
+	for i, p := range points {
+		x := scale(p[0], minX, maxX, cols)
+		y := scale(p[1], minY, maxY, rows)
+		cost[i] = make([]float64, cols*rows)
+		for cell := range cost[i] {
+			dx := x - float64(cell%cols)
+			dy := y - float64(cell/cols)
+			cost[i][cell] = dx*dx + dy*dy
+		}
+	}
+	return hungarian(cost)
+}
+
+// hungarian solves the rectangular assignment problem for an n×m cost
+// matrix with n <= m, returning the column assigned to each row. It is the
+// O(n²m) shortest augmenting path method with row and column potentials.
+func hungarian(cost [][]float64) []int {
+	n, m := len(cost), len(cost[0])
+	u := make([]float64, n+1)
+	v := make([]float64, m+1)
+	match := make([]int, m+1) // match[j] is the 1-based row assigned to column j
+	way := make([]int, m+1)
+
+	for i := 1; i <= n; i++ {
+		match[0] = i
+		j0 := 0
+		minv := make([]float64, m+1)
+		used := make([]bool, m+1)
+		for j := range minv {
+			minv[j] = math.Inf(1)
+		}
+		for match[j0] != 0 {
+			used[j0] = true
+			i0, delta, j1 := match[j0], math.Inf(1), 0
+			for j := 1; j <= m; j++ {
+				if used[j] {
+					continue
+				}
+				cur := cost[i0-1][j-1] - u[i0] - v[j]
+				if cur < minv[j] {
+					minv[j], way[j] = cur, j0
+				}
+				if minv[j] < delta {
+					delta, j1 = minv[j], j
+				}
+			}
+			for j := 0; j <= m; j++ {
+				if used[j] {
+					u[match[j]] += delta
+					v[j] -= delta
+				} else {
+					minv[j] -= delta
+				}
+			}
+			j0 = j1
+		}
+		for j0 != 0 {
+			j1 := way[j0]
+			match[j0] = match[j1]
+			j0 = j1
+		}
+	}
+
+	assignment := make([]int, n)
+	for j := 1; j <= m; j++ {
+		if match[j] != 0 {
+			assignment[match[j]-1] = j - 1
+		}
+	}
+	return assignment
+}
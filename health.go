@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/tlehman/vecviz/tsne"
+)
+
+// healthCheckTimeout bounds each dependency check so a hung dependency fails the probe quickly
+const healthCheckTimeout = 2 * time.Second
+
+// GET /healthz - Check that SQLite and the embedding backend (Ollama by default) are reachable
+// Responds 200 only if both are up, otherwise 503, with each dependency's status in the body.
+// The body also counts reducer processes rerun after crashing since startup, which doesn't
+// affect the status.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	check := func(ping func(context.Context) error) map[string]interface{} {
+		ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+		defer cancel()
+		if err := ping(ctx); err != nil {
+			return map[string]interface{}{"status": "down", "error": err.Error()}
+		}
+		return map[string]interface{}{"status": "up"}
+	}
+
+	dbStatus := check(s.store.Ping)
+	embedderStatus := check(s.embedder.Ping)
+
+	status := http.StatusOK
+	if dbStatus["status"] != "up" || embedderStatus["status"] != "up" {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"database": dbStatus,
+		"embedder": embedderStatus,
+		"reducer":  map[string]interface{}{"restarts": tsne.Restarts()},
+	})
+}
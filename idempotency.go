@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/tlehman/vecviz/db"
+)
+
+const (
+	// idempotencyTTL is how long a response is replayed for its Idempotency-Key
+	idempotencyTTL = 24 * time.Hour
+	// maxIdempotencyKey bounds the length of an Idempotency-Key header
+	maxIdempotencyKey = 255
+)
+
+// idempotencyRecorder captures a response so it can be replayed for later
+// requests carrying the same Idempotency-Key
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	store       *db.Store
+	key         string
+	requestHash string
+	status      int
+	body        bytes.Buffer
+}
+
+func (r *idempotencyRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *idempotencyRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// save records a successful response under the key. Failures aren't
+// recorded, so retrying them does the work again.
+func (r *idempotencyRecorder) save() {
+	if r.status < 200 || r.status >= 300 {
+		return
+	}
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.Unmarshal(r.body.Bytes(), &created); err != nil {
+		slog.Warn("Failed to read response for idempotency key", "err", err)
+		return
+	}
+	resp := db.IdempotentResponse{
+		RequestHash: r.requestHash,
+		PromptID:    created.ID,
+		Status:      r.status,
+		Body:        r.body.Bytes(),
+	}
+	if err := r.store.SaveIdempotentResponse(r.key, resp, idempotencyTTL); err != nil {
+		slog.Warn("Failed to record idempotency key", "err", err)
+	}
+}
+
+// checkIdempotencyKey handles a request's Idempotency-Key header. If the key
+// has been seen within idempotencyTTL the earlier response is written again,
+// with an Idempotent-Replayed header, and done is true; reusing a key for a
+// different request is a 422. Otherwise rec, when the header is set, should
+// be used as the response writer and saved once the handler returns.
+// Concurrent requests sharing a new key both run, and the first to finish is
+// the one replayed afterwards.
+func (s *Server) checkIdempotencyKey(w http.ResponseWriter, r *http.Request) (rec *idempotencyRecorder, done bool) {
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" {
+		return nil, false
+	}
+	if len(key) > maxIdempotencyKey {
+		writeJSONError(w, http.StatusBadRequest, "Idempotency-Key is too long")
+		return nil, true
+	}
+
+	// The body is read here to identify the request, then put back for the handler
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Failed to read request")
+		return nil, true
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	h := sha256.New()
+	io.WriteString(h, r.URL.RawQuery+"\n")
+	h.Write(body)
+	requestHash := hex.EncodeToString(h.Sum(nil))
+
+	saved, err := s.store.GetIdempotentResponse(r.Context(), key, idempotencyTTL)
+	switch {
+	case errors.Is(err, db.ErrIdempotencyKeyNotFound):
+		return &idempotencyRecorder{ResponseWriter: w, store: s.store, key: key, requestHash: requestHash}, false
+	case err != nil:
+		writeJSONError(w, http.StatusInternalServerError, "Failed to check idempotency key: "+err.Error())
+		return nil, true
+	case saved.RequestHash != requestHash:
+		writeJSONError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+		return nil, true
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(saved.Status)
+	w.Write(saved.Body)
+	return nil, true
+}
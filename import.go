@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/tlehman/vecviz/db"
+)
+
+const (
+	// importBatchSize is how many embeddings are inserted per transaction during imports
+	importBatchSize = 500
+	// maxImportErrors bounds how many per-line errors an import reports
+	maxImportErrors = 100
+)
+
+// POST /import/openai-jsonl - Import precomputed embeddings from an OpenAI JSONL export
+//
+// Each line is {"text": "...", "embedding": [...]}, optionally with the
+// "model" that produced the embedding; without it the model is recorded as
+// unknown. The body is streamed and
+// inserted in batches, so large exports never sit in memory at once. Prompts
+// that already have an embedding are skipped, and Ollama is never called.
+func (s *Server) handleImportOpenAIJSONL(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var imported, skipped, invalid, dimensionMismatches int
+	errs := []string{}
+	addError := func(line int, msg string) {
+		if len(errs) < maxImportErrors {
+			errs = append(errs, fmt.Sprintf("line %d: %s", line, msg))
+		}
+	}
+
+	var batch []db.EmbeddingData
+	flush := func() error {
+		alreadyEmbedded, err := s.store.InsertEmbeddings(batch)
+		if err != nil {
+			return err
+		}
+		// Embedded by another request since this one checked
+		imported += len(batch) - len(alreadyEmbedded)
+		skipped += len(alreadyEmbedded)
+		batch = batch[:0]
+		return nil
+	}
+
+	// Tracks prompts already in the current batch so duplicate lines are skipped
+	batched := make(map[int64]bool)
+
+	reader := bufio.NewReader(r.Body)
+	for lineNum := 1; ; lineNum++ {
+		line, readErr := reader.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			writeJSONError(w, http.StatusBadRequest, "Failed to read body: "+readErr.Error())
+			return
+		}
+
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			var record struct {
+				Text      string    `json:"text"`
+				Embedding []float64 `json:"embedding"`
+				Model     string    `json:"model"`
+			}
+			switch err := json.Unmarshal(line, &record); {
+			case err != nil:
+				invalid++
+				addError(lineNum, "invalid JSON: "+err.Error())
+			case record.Text == "":
+				invalid++
+				addError(lineNum, "text is required")
+			case len(record.Embedding) != db.Dimension:
+				dimensionMismatches++
+				addError(lineNum, fmt.Sprintf("embedding has dimension %d, expected %d", len(record.Embedding), db.Dimension))
+			default:
+				id, err := s.store.InsertPrompt(record.Text)
+				if err != nil {
+					writeJSONError(w, http.StatusInternalServerError, "Failed to store prompt: "+err.Error())
+					return
+				}
+				exists, err := s.store.HasEmbedding(r.Context(), id)
+				if err != nil {
+					writeJSONError(w, http.StatusInternalServerError, "Failed to check embedding: "+err.Error())
+					return
+				}
+				if exists || batched[id] {
+					skipped++
+					break
+				}
+
+				vector := make([]float32, len(record.Embedding))
+				for i, v := range record.Embedding {
+					vector[i] = float32(v)
+				}
+				batch = append(batch, db.EmbeddingData{PromptID: id, Vector: vector, Model: record.Model})
+				batched[id] = true
+
+				if len(batch) >= importBatchSize {
+					if err := flush(); err != nil {
+						writeJSONError(w, http.StatusInternalServerError, "Failed to store embeddings: "+err.Error())
+						return
+					}
+					clear(batched)
+				}
+			}
+		}
+
+		if readErr == io.EOF {
+			break
+		}
+	}
+
+	if err := flush(); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to store embeddings: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"imported":             imported,
+		"skipped":              skipped,
+		"invalid":              invalid,
+		"dimension_mismatches": dimensionMismatches,
+		"errors":               errs,
+	})
+}
+
+// POST /import?on_duplicate=skip|upsert - Import prompts in the shape /export produces
+//
+// The body is a JSON array of {"text", "created_at", "embedding", "embedded_at", "model", "projection"}
+// objects; id is ignored and new IDs are assigned. Valid records are stored in
+// one transaction. Prompts whose text already exists are skipped by default,
+// or with on_duplicate=upsert get their embedding and projection replaced.
+func (s *Server) handleImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var upsert bool
+	switch r.URL.Query().Get("on_duplicate") {
+	case "", "skip":
+	case "upsert":
+		upsert = true
+	default:
+		writeJSONError(w, http.StatusBadRequest, "on_duplicate must be skip or upsert")
+		return
+	}
+
+	var records []exportRecord
+	if err := json.NewDecoder(r.Body).Decode(&records); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	var invalid, dimensionMismatches int
+	errs := []string{}
+	addError := func(index int, msg string) {
+		if len(errs) < maxImportErrors {
+			errs = append(errs, fmt.Sprintf("record %d: %s", index, msg))
+		}
+	}
+
+	toImport := make([]db.ImportRecord, 0, len(records))
+	for i, rec := range records {
+		if rec.Text == "" {
+			invalid++
+			addError(i, "text is required")
+			continue
+		}
+		if rec.Embedding != nil && len(rec.Embedding) != db.Dimension {
+			dimensionMismatches++
+			addError(i, fmt.Sprintf("embedding has dimension %d, expected %d", len(rec.Embedding), db.Dimension))
+			continue
+		}
+
+		var createdAt time.Time
+		if rec.CreatedAt != "" {
+			t, err := time.Parse(time.RFC3339, rec.CreatedAt)
+			if err != nil {
+				invalid++
+				addError(i, "created_at must be RFC3339")
+				continue
+			}
+			createdAt = t
+		}
+
+		var embeddedAt time.Time
+		if rec.EmbeddedAt != "" {
+			t, err := time.Parse(time.RFC3339, rec.EmbeddedAt)
+			if err != nil {
+				invalid++
+				addError(i, "embedded_at must be RFC3339")
+				continue
+			}
+			embeddedAt = t
+		}
+
+		ir := db.ImportRecord{Text: rec.Text, CreatedAt: createdAt, Vector: rec.Embedding, EmbeddedAt: embeddedAt, Model: rec.Model}
+		if p := rec.Projection; p != nil {
+			ir.Projection = &db.Projection{X: p.X, Y: p.Y, Z: p.Z}
+		}
+		toImport = append(toImport, ir)
+	}
+
+	imported, skipped, err := s.store.ImportPrompts(toImport, upsert)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to import: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"imported":             imported,
+		"skipped":              skipped,
+		"invalid":              invalid,
+		"dimension_mismatches": dimensionMismatches,
+		"errors":               errs,
+	})
+}
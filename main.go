@@ -1,189 +1,2147 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
+	"unicode/utf8"
 
 	"github.com/tlehman/vecviz/db"
 	"github.com/tlehman/vecviz/ollama"
 	"github.com/tlehman/vecviz/tsne"
+	"github.com/tlehman/vecviz/vecmath"
 )
 
-var ollamaClient *ollama.Client
+// defaultReducer is used when no method is requested
+const defaultReducer = "tsne"
+
+// projectionRun records which reducer and parameters produced the stored projections
+type projectionRun struct {
+	Method string `json:"method"`
+	tsne.TSNEParams
+}
+
+// projectionQuality holds the reducer's own measures of how well the stored
+// projections fit, where it has any
+type projectionQuality struct {
+	KLDivergence           *float64  `json:"kl_divergence,omitempty"`
+	ExplainedVarianceRatio []float64 `json:"explained_variance_ratio,omitempty"`
+}
+
+// maxConcurrentEmbeds caps how many embedding calls a single request runs at once
+const maxConcurrentEmbeds = 4
+
+// maxPointsLimit bounds the page size for /points when limit is given
+const maxPointsLimit = 10000
+
+// defaultMaxPromptLength is the most characters /embed accepts in a prompt
+// unless VECVIZ_MAX_PROMPT_LENGTH says otherwise. Longer text is slow to
+// embed and can exceed the model's context.
+const defaultMaxPromptLength = 32768
+
+// maxBatchPrompts bounds how many prompts /embed/batch and /project/batch
+// take in one request, which are all embedded before it answers
+const maxBatchPrompts = 1000
+
+// defaultSearchK is the number of results /search returns when k is not given
+const defaultSearchK = 10
+
+// defaultShutdownTimeout is how long in-flight requests (e.g. a t-SNE run) get to finish on shutdown
+const defaultShutdownTimeout = 30 * time.Second
+
+const (
+	// defaultDBPath is the database file used when neither -db nor VECVIZ_DB is set
+	defaultDBPath = "vecviz.db"
+	// defaultAddr is the listen address used when neither -addr nor VECVIZ_ADDR is set
+	defaultAddr = ":8080"
+)
+
+// flagOrEnv returns a string flag's value if it was given, otherwise the
+// environment variable env, otherwise fallback
+func flagOrEnv(value, env, fallback string) string {
+	if value != "" {
+		return value
+	}
+	if v := os.Getenv(env); v != "" {
+		return v
+	}
+	return fallback
+}
 
 func main() {
+	dbFlag := flag.String("db", "", "SQLite database file (default $VECVIZ_DB or "+defaultDBPath+")")
+	addrFlag := flag.String("addr", "", "address to listen on (default $VECVIZ_ADDR or "+defaultAddr+")")
+	ollamaURLFlag := flag.String("ollama-url", "", "Ollama server to embed with (default $VECVIZ_OLLAMA_URL or "+ollama.DefaultBaseURL+")")
+	watchPath := flag.String("watch", "", "file to tail for new prompts, one per line")
+	seedPath := flag.String("seed", "", "JSONL file of {\"text\", \"vector\"} lines to load into a new, empty database")
+	maxEmbeds := flag.Int("max-embeds", 0, "maximum embedding requests in flight (default $VECVIZ_MAX_EMBEDS or 4)")
+	staticDir := flag.String("static-dir", "", "directory to serve the web UI from (default $VECVIZ_STATIC_DIR, $VECVIZ_ROOT/static, or the copy built into the binary)")
+	noStatic := flag.Bool("no-static", false, "serve only the API, without the web UI (default $VECVIZ_NO_STATIC)")
+	allowReset := flag.Bool("allow-reset", false, "enable POST /reset, which deletes all data (default $VECVIZ_ALLOW_RESET)")
+	normalize := flag.String("normalize-prompts", "", "how prompts are compared for duplicates: none, whitespace or lowercase (default $VECVIZ_NORMALIZE_PROMPTS, or whatever the database last used)")
+	flag.Parse()
+
+	logger, err := newLogger()
+	if err != nil {
+		fatal("Failed to configure logging", "err", err)
+	}
+	slog.SetDefault(logger)
+
 	// Initialize database
-	if err := db.Init("vecviz.db"); err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+	chunkSize := 0
+	if v := os.Getenv("VECVIZ_CHUNK_SIZE"); v != "" {
+		if chunkSize, err = strconv.Atoi(v); err != nil {
+			fatal("Invalid VECVIZ_CHUNK_SIZE", "value", v, "err", err)
+		}
+	}
+	dbPath := flagOrEnv(*dbFlag, "VECVIZ_DB", defaultDBPath)
+	store, err := db.Open(dbPath, os.Getenv("VECVIZ_DISTANCE_METRIC"), os.Getenv("VECVIZ_STORAGE"), chunkSize)
+	if err != nil {
+		fatal("Failed to initialize database", "err", err)
+	}
+	slog.Info("Database initialized", "path", dbPath, "metric", store.Metric, "storage", store.Storage, "chunk_size", store.ChunkSize, "dimension", db.Dimension)
+
+	if mode := flagOrEnv(*normalize, "VECVIZ_NORMALIZE_PROMPTS", ""); mode != "" {
+		duplicates, err := store.SetPromptNormalization(mode)
+		if err != nil {
+			fatal("Failed to set prompt normalization", "err", err)
+		}
+		if duplicates > 0 {
+			slog.Warn("Stored prompts that are now duplicates are kept; new submissions resolve to the lowest ID", "normalization", store.PromptNormalization(), "duplicates", duplicates)
+		}
+	}
+
+	// Seed after normalization is settled, as it decides which texts are duplicates
+	if *seedPath != "" {
+		if err := seedStore(store, *seedPath); err != nil {
+			fatal("Failed to seed database", "path", *seedPath, "err", err)
+		}
+	}
+
+	// Initialize the embedding backend
+	client, backend, err := newEmbedder(*maxEmbeds, flagOrEnv(*ollamaURLFlag, "VECVIZ_OLLAMA_URL", ""))
+	if err != nil {
+		fatal("Failed to configure embedder", "err", err)
+	}
+	store.Model = embedderModel(client)
+	slog.Info("Embedder configured", "backend", backend, "model", store.Model)
+
+	// Resume any queued background embeddings
+	events := newProgressHub()
+	queue, err := startEmbedQueue(store, client, events, embedQueueWorkers)
+	if err != nil {
+		fatal("Failed to start embed queue", "err", err)
+	}
+
+	readCORS, writeCORS, err := corsFromEnv()
+	if err != nil {
+		fatal("Failed to configure CORS", "err", err)
+	}
+	// VECVIZ_ROOT runs the reducer scripts from a checkout instead of the embedded copies
+	python := tsne.Runtime{PythonPath: os.Getenv("VECVIZ_PYTHON"), Root: os.Getenv("VECVIZ_ROOT"), DebugDir: os.Getenv("VECVIZ_DEBUG_DIR")}
+	if s := os.Getenv("VECVIZ_DEBUG_RUNS"); s != "" {
+		python.DebugRuns, err = strconv.Atoi(s)
+		if err != nil || python.DebugRuns < 1 {
+			fatal("Invalid VECVIZ_DEBUG_RUNS, expected a positive integer", "value", s)
+		}
+	}
+	if python.DebugDir != "" {
+		slog.Info("Recording reducer script runs", "dir", python.DebugDir)
+	}
+
+	var static fs.FS
+	if apiOnly, _ := strconv.ParseBool(os.Getenv("VECVIZ_NO_STATIC")); *noStatic || apiOnly {
+		slog.Info("Web UI disabled, serving only the API")
+	} else {
+		dir := *staticDir
+		if dir == "" {
+			dir = staticDirFromEnv()
+		}
+		static, err = staticFS(dir)
+		if err != nil {
+			fatal("Failed to open static directory", "dir", dir, "err", err)
+		}
+		if dir != "" {
+			slog.Info("Serving web UI from directory", "dir", dir)
+		}
+	}
+	srv := newServer(client, store, queue, events, python, static, readCORS, writeCORS)
+	srv.apiKey = os.Getenv("VECVIZ_API_KEY")
+	if srv.apiKey != "" {
+		slog.Info("Write endpoints require an API key")
+	}
+	envReset, _ := strconv.ParseBool(os.Getenv("VECVIZ_ALLOW_RESET"))
+	srv.allowReset = *allowReset || envReset
+	if srv.allowReset {
+		slog.Warn("POST /reset is enabled and deletes all data")
+	}
+	srv.ollamaURLs, err = parseOllamaURLs(os.Getenv("VECVIZ_OLLAMA_URLS"))
+	if err != nil {
+		fatal("Invalid VECVIZ_OLLAMA_URLS", "err", err)
+	}
+	if s := os.Getenv("VECVIZ_MAX_DISTANCE_POINTS"); s != "" {
+		srv.maxDistancePoints, err = strconv.Atoi(s)
+		if err != nil || srv.maxDistancePoints < 1 {
+			fatal("Invalid VECVIZ_MAX_DISTANCE_POINTS, expected a positive integer", "value", s)
+		}
+	}
+	if s := os.Getenv("VECVIZ_MAX_PROMPT_LENGTH"); s != "" {
+		srv.maxPromptLength, err = strconv.Atoi(s)
+		if err != nil || srv.maxPromptLength < 1 {
+			fatal("Invalid VECVIZ_MAX_PROMPT_LENGTH, expected a positive integer", "value", s)
+		}
+	}
+	if s := os.Getenv("VECVIZ_MAX_SEARCH_K"); s != "" {
+		srv.maxSearchK, err = strconv.Atoi(s)
+		if err != nil || srv.maxSearchK < 1 {
+			fatal("Invalid VECVIZ_MAX_SEARCH_K, expected a positive integer", "value", s)
+		}
+	}
+	if s := os.Getenv("VECVIZ_COORD_PRECISION"); s != "" {
+		if srv.coordPrecision, err = parseCoordPrecision(s); err != nil {
+			fatal("Invalid VECVIZ_COORD_PRECISION", "err", err)
+		}
+	}
+	if s := os.Getenv("VECVIZ_PROJECTION_HISTORY"); s != "" {
+		srv.projectionHistory, err = strconv.Atoi(s)
+		if err != nil || srv.projectionHistory < 1 {
+			fatal("Invalid VECVIZ_PROJECTION_HISTORY, expected a positive integer", "value", s)
+		}
+	}
+
+	shutdownTimeout := defaultShutdownTimeout
+	if s := os.Getenv("VECVIZ_SHUTDOWN_TIMEOUT"); s != "" {
+		shutdownTimeout, err = time.ParseDuration(s)
+		if err != nil {
+			fatal("Invalid VECVIZ_SHUTDOWN_TIMEOUT", "err", err)
+		}
+	}
+
+	addr := flagOrEnv(*addrFlag, "VECVIZ_ADDR", defaultAddr)
+	server := &http.Server{Addr: addr, Handler: srv}
+	server.RegisterOnShutdown(srv.progress.close)
+	server.RegisterOnShutdown(srv.events.close)
+
+	// Stop accepting requests on SIGINT/SIGTERM and let in-flight ones finish
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// The watcher stops with ctx and must finish before the database closes
+	watchDone := make(chan struct{})
+	if *watchPath != "" {
+		go func() {
+			defer close(watchDone)
+			if err := srv.watchPromptFile(ctx, *watchPath); err != nil {
+				slog.Error("Failed to watch prompt file", "path", *watchPath, "err", err)
+			}
+		}()
+	} else {
+		close(watchDone)
+	}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		slog.Info("Server starting", "addr", addr)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		fatal("Server failed", "err", err)
+	case <-ctx.Done():
+	}
+
+	slog.Info("Shutting down, waiting for in-flight requests", "timeout", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("Forced shutdown", "err", err)
+	}
+	<-watchDone
+	// Workers may be mid-write, so they must stop before the database closes
+	queue.stop()
+
+	if err := store.Close(); err != nil {
+		slog.Error("Failed to close database", "err", err)
+	}
+	slog.Info("Server stopped")
+}
+
+// seedStore loads the prompts and vectors in the JSONL file at path into
+// store, unless it already has prompts
+func seedStore(store *db.Store, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	seeded, skipped, err := store.SeedFromReader(f)
+	if errors.Is(err, db.ErrNotEmpty) {
+		slog.Info("Database already has prompts, not seeding", "path", path)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	slog.Info("Seeded database", "path", path, "prompts", seeded, "duplicates", skipped)
+	return nil
+}
+
+// checkPrompt rejects text there is no point embedding, returning the status
+// to answer with: 400 for text of only whitespace, which is stored as given
+// but has nothing to embed, and 413 for text longer than
+// VECVIZ_MAX_PROMPT_LENGTH characters
+func (s *Server) checkPrompt(text string) (int, error) {
+	if strings.TrimSpace(text) == "" {
+		return http.StatusBadRequest, errors.New("Prompt is required")
+	}
+	if n := utf8.RuneCountInString(text); n > s.maxPromptLength {
+		return http.StatusRequestEntityTooLarge, fmt.Errorf("Prompt is %d characters, more than the limit of %d (VECVIZ_MAX_PROMPT_LENGTH)", n, s.maxPromptLength)
+	}
+	return 0, nil
+}
+
+// POST /embed?force=true - Add a new embedding, reusing a stored one unless forced
+// With ?async=true the prompt is queued and embedded in the background.
+// With ?novelty=true the response includes the distance to the nearest existing embedding.
+// With ?project=true the response includes a provisional x/y/z in the current layout.
+// With ?fail_on_exists=true an already stored prompt is a 409 and is left untouched.
+// "ollama_url" routes the request to one of the Ollama servers in VECVIZ_OLLAMA_URLS.
+// The response's "created" says whether the prompt was new. When Ollama
+// embedded it, "usage" has the time Ollama took ("total_duration_ms", of
+// which "load_duration_ms" loading the model) and the input's token count
+// ("prompt_eval_count").
+// {"fields": {"title": ..., "body": ...}, "template": "{{.title}}\n{{.body}}"}
+// in place of "prompt" embeds the rendered Go text/template, storing it as the
+// prompt text and the fields as metadata.
+// A prompt of only whitespace is a 400, and one longer than
+// VECVIZ_MAX_PROMPT_LENGTH characters a 413.
+// An Idempotency-Key header makes retries safe: a successful response is
+// recorded for 24 hours and repeated, with Idempotent-Replayed: true, for
+// the same key without embedding again.
+func (s *Server) handleEmbed(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	idempotency, done := s.checkIdempotencyKey(w, r)
+	if done {
+		return
+	}
+	if idempotency != nil {
+		w = idempotency
+		defer idempotency.save()
+	}
+
+	var req struct {
+		Prompt  string `json:"prompt"`
+		Context string `json:"context"`
+		// ID optionally assigns the prompt's primary key, e.g. to mirror an upstream catalog
+		ID   *int64   `json:"id"`
+		Tags []string `json:"tags"`
+		// Metadata holds key/value details such as a source URL or author
+		Metadata map[string]string `json:"metadata"`
+		// OllamaURL optionally sends this request to another Ollama server
+		// from VECVIZ_OLLAMA_URLS
+		OllamaURL string `json:"ollama_url"`
+		// Fields and Template build the prompt from a multi-field document
+		// in place of Prompt
+		Fields   map[string]string `json:"fields"`
+		Template string            `json:"template"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	if req.Fields != nil || req.Template != "" {
+		if req.Prompt != "" {
+			writeJSONError(w, http.StatusBadRequest, "Give either prompt or fields and template, not both")
+			return
+		}
+		if req.Template == "" {
+			writeJSONError(w, http.StatusBadRequest, "Template is required with fields")
+			return
+		}
+		prompt, err := renderPromptTemplate(req.Template, req.Fields)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Failed to render template: "+err.Error())
+			return
+		}
+		req.Prompt = prompt
+
+		// Keep the original fields, without overriding metadata given explicitly
+		if req.Metadata == nil {
+			req.Metadata = make(map[string]string, len(req.Fields))
+		}
+		for name, value := range req.Fields {
+			if _, ok := req.Metadata[name]; !ok {
+				req.Metadata[name] = value
+			}
+		}
+	}
+
+	if status, err := s.checkPrompt(req.Prompt); err != nil {
+		writeJSONError(w, status, err.Error())
+		return
+	}
+
+	async := r.URL.Query().Get("async") == "true"
+	if async && req.OllamaURL != "" {
+		writeJSONError(w, http.StatusBadRequest, "ollama_url can't be used with async=true; queued prompts use the default server")
+		return
+	}
+	embedder, err := s.embedderFor(req.OllamaURL)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Check if prompt already exists
+	var existingID int64
+	var created bool
+	if req.ID != nil {
+		if *req.ID < 1 {
+			writeJSONError(w, http.StatusBadRequest, "Prompt id must be positive")
+			return
+		}
+		existingID = *req.ID
+		created, err = s.store.InsertPromptWithID(*req.ID, req.Prompt)
+		if errors.Is(err, db.ErrPromptConflict) {
+			writeJSONError(w, http.StatusConflict, err.Error())
+			return
+		}
+	} else {
+		existingID, created, err = s.store.EnsurePrompt(req.Prompt)
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to store prompt: "+err.Error())
+		return
+	}
+	if !created && r.URL.Query().Get("fail_on_exists") == "true" {
+		writeJSONError(w, http.StatusConflict, fmt.Sprintf("Prompt already exists with id %d", existingID))
+		return
+	}
+
+	for _, tag := range req.Tags {
+		if tag == "" {
+			continue
+		}
+		if err := s.store.AddTag(existingID, tag); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to store tag: "+err.Error())
+			return
+		}
+	}
+
+	if len(req.Metadata) > 0 {
+		if err := s.store.SetMetadata(existingID, req.Metadata); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to store metadata: "+err.Error())
+			return
+		}
+	}
+
+	if async {
+		if err := s.queue.enqueue(existingID, req.Context); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to queue prompt: "+err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":      existingID,
+			"prompt":  req.Prompt,
+			"created": created,
+			"status":  "pending",
+		})
+		return
+	}
+
+	// Reuse a stored embedding rather than calling the backend again,
+	// unless ?force=true asks for a fresh one
+	force := r.URL.Query().Get("force") == "true"
+	reused := false
+	var embedding []float32
+	if !force {
+		stored, err := s.store.GetEmbedding(r.Context(), existingID)
+		if err != nil && !errors.Is(err, db.ErrEmbeddingNotFound) {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to get embedding: "+err.Error())
+			return
+		}
+		embedding, reused = stored, err == nil
+	}
+
+	var usage map[string]interface{}
+	if !reused {
+		var result *ollama.EmbeddingResult
+		if force {
+			result, err = s.reembed(r.Context(), embedder, existingID, req.Prompt)
+		} else {
+			result, err = s.embedAndStore(r.Context(), embedder, existingID, req.Prompt)
+		}
+		if err != nil {
+			slog.Error("Embed failed", "prompt_id", existingID, "chars", len(req.Prompt), "err", err)
+			writeJSONError(w, embedErrorStatus(err), "Failed to embed prompt: "+err.Error())
+			return
+		}
+		embedding, usage = result.Embedding, embedUsage(result)
+	}
+
+	// Record the system prompt/template for reproducibility; a reused
+	// embedding keeps the context it was generated with
+	if req.Context != "" && !reused {
+		if err := s.store.SetEmbeddingContext(existingID, req.Context); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to store context: "+err.Error())
+			return
+		}
+	}
+
+	needsUpdate, err := s.projectionsStale(r.Context())
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to check projections: "+err.Error())
+		return
+	}
+
+	resp := map[string]interface{}{
+		"id":                existingID,
+		"prompt":            req.Prompt,
+		"embedding_dim":     len(embedding),
+		"created":           created,
+		"reused":            reused,
+		"needs_tsne_update": needsUpdate,
+	}
+	if usage != nil {
+		resp["usage"] = usage
+	}
+
+	// Novelty is the distance to the nearest other embedding under the
+	// database's metric: with l2 it is in the raw (unnormalized) embedding
+	// space of the model, with cosine it is 1 - cosine similarity in [0, 2].
+	// 0 means an identical vector exists, and larger values mean less similar.
+	// It is null for the first prompt.
+	if r.URL.Query().Get("novelty") == "true" {
+		distance, ok, err := s.store.NearestDistance(r.Context(), existingID, embedding)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to compute novelty: "+err.Error())
+			return
+		}
+		if ok {
+			resp["novelty"] = distance
+		} else {
+			resp["novelty"] = nil
+		}
+	}
+
+	// The provisional position is where the next incremental run would seed
+	// the point, so a client can show it at once and move it when projections
+	// are recomputed. It is null until something has been projected.
+	if r.URL.Query().Get("project") == "true" {
+		x, y, z, err := s.store.ProjectNewPoint(r.Context(), embedding)
+		switch {
+		case errors.Is(err, db.ErrNoProjectedNeighbors):
+			resp["x"], resp["y"], resp["z"] = nil, nil, nil
+		case err != nil:
+			writeJSONError(w, http.StatusInternalServerError, "Failed to project: "+err.Error())
+			return
+		default:
+			resp["x"], resp["y"], resp["z"] = x, y, z
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// embedAndStore fetches the embedding for a prompt from embedder and stores it.
+// Concurrent calls for the same text share a single embed call and insert,
+// so simultaneous submissions of a new prompt embed it once, even if they
+// name different Ollama servers: only one embedding is kept per prompt. The
+// shared call is detached from ctx's cancellation so one client
+// disconnecting doesn't fail the others waiting on it. Callers sharing a
+// call all get its reported cost.
+func (s *Server) embedAndStore(ctx context.Context, embedder Embedder, promptID int64, text string) (*ollama.EmbeddingResult, error) {
+	v, err, _ := s.embedGroup.Do(text, func() (interface{}, error) {
+		result, err := embedResult(context.WithoutCancel(ctx), embedder, text)
+		if err != nil {
+			return nil, fmt.Errorf("get embedding: %w", err)
+		}
+		err = s.store.InsertEmbedding(promptID, result.Embedding)
+		if err != nil && !errors.Is(err, db.ErrEmbeddingExists) {
+			return nil, fmt.Errorf("store embedding: %w", err)
+		}
+		if err == nil {
+			publishPointAdded(s.events, s.store, promptID, text, result.Embedding)
+		}
+		return result, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*ollama.EmbeddingResult), nil
+}
+
+// reembed fetches a fresh embedding for a prompt from embedder and replaces the stored one
+func (s *Server) reembed(ctx context.Context, embedder Embedder, promptID int64, text string) (*ollama.EmbeddingResult, error) {
+	result, err := embedResult(ctx, embedder, text)
+	if err != nil {
+		return nil, fmt.Errorf("get embedding: %w", err)
+	}
+	if err := s.store.ReplaceEmbedding(promptID, result.Embedding); err != nil {
+		return nil, fmt.Errorf("store embedding: %w", err)
+	}
+	return result, nil
+}
+
+// embedUsage describes what an embed call cost, or is nil if the backend
+// didn't say
+func embedUsage(result *ollama.EmbeddingResult) map[string]interface{} {
+	if result.TotalDuration == 0 && result.PromptEvalCount == 0 {
+		return nil
+	}
+	return map[string]interface{}{
+		"total_duration_ms": float64(result.TotalDuration) / float64(time.Millisecond),
+		"load_duration_ms":  float64(result.LoadDuration) / float64(time.Millisecond),
+		"prompt_eval_count": result.PromptEvalCount,
+	}
+}
+
+// GET /queue - Get the background embedding queue depth
+func (s *Server) handleQueue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	stats, err := s.store.GetQueueStats(r.Context(), maxQueueAttempts)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to get queue: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"pending":     stats.Pending,
+		"in_progress": stats.InProgress,
+		"failed":      stats.Failed,
+	})
+}
+
+// embedAll fetches embeddings for several texts, running at most
+// maxConcurrentEmbeds calls at once. Results and errors are indexed like texts.
+func (s *Server) embedAll(ctx context.Context, texts []string) ([][]float32, []error) {
+	embeddings := make([][]float32, len(texts))
+	errs := make([]error, len(texts))
+
+	sem := make(chan struct{}, maxConcurrentEmbeds)
+	var wg sync.WaitGroup
+	for i, text := range texts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			embeddings[i], errs[i] = s.embedder.Embed(ctx, text)
+		}()
+	}
+	wg.Wait()
+
+	return embeddings, errs
+}
+
+// POST /embed/batch?atomic=true - Add embeddings for many prompts at once
+// One prompt failing doesn't stop the rest: each result has a "status" of
+// "ok" (with its "id") or "error" (with an "error" to retry on), and the
+// response is a 207 when any failed. By default the embeddings that succeeded
+// are stored anyway; with ?atomic=true a failure stores none of them and their
+// results become "rolled_back". Prompt rows are kept either way, so a retry
+// reuses their IDs. "summary" counts the results of each status and says
+// whether anything was committed. Each prompt is checked as /embed checks it, so one of only
+// whitespace or over VECVIZ_MAX_PROMPT_LENGTH is an error result. A batch
+// may have at most maxBatchPrompts prompts.
+func (s *Server) handleEmbedBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req struct {
+		Prompts []string `json:"prompts"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	if len(req.Prompts) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "Prompts are required")
+		return
+	}
+	if len(req.Prompts) > maxBatchPrompts {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("At most %d prompts per batch", maxBatchPrompts))
+		return
+	}
+
+	results := make([]map[string]interface{}, len(req.Prompts))
+
+	// Resolve prompt ids and collect the ones that still need an embedding,
+	// embedding each distinct text only once
+	var pending []string
+	var pendingIDs []int64
+	resultsByID := make(map[int64][]map[string]interface{})
+	for i, prompt := range req.Prompts {
+		result := map[string]interface{}{"prompt": prompt}
+		results[i] = result
+
+		if _, err := s.checkPrompt(prompt); err != nil {
+			result["error"] = err.Error()
+			continue
+		}
+
+		id, err := s.store.InsertPrompt(prompt)
+		if err != nil {
+			result["error"] = "Failed to store prompt: " + err.Error()
+			continue
+		}
+		result["id"] = id
+
+		if _, ok := resultsByID[id]; ok {
+			resultsByID[id] = append(resultsByID[id], result)
+			continue
+		}
+		resultsByID[id] = []map[string]interface{}{result}
+
+		exists, err := s.store.HasEmbedding(r.Context(), id)
+		if err != nil {
+			result["error"] = "Failed to check embedding: " + err.Error()
+			continue
+		}
+		if exists {
+			result["reused"] = true
+			continue
+		}
+
+		pending = append(pending, prompt)
+		pendingIDs = append(pendingIDs, id)
+	}
+
+	embeddings, errs := s.embedAll(r.Context(), pending)
+
+	var toInsert []db.EmbeddingData
+	var insertedText []string
+	for i, id := range pendingIDs {
+		for _, result := range resultsByID[id] {
+			if errs[i] != nil {
+				result["error"] = "Failed to get embedding: " + errs[i].Error()
+			} else {
+				result["embedding_dim"] = len(embeddings[i])
+			}
+		}
+		if errs[i] == nil {
+			toInsert = append(toInsert, db.EmbeddingData{PromptID: id, Vector: embeddings[i], Model: s.store.Model})
+			insertedText = append(insertedText, pending[i])
+		}
+	}
+
+	failed := 0
+	for _, result := range results {
+		if _, ok := result["error"]; ok {
+			result["status"] = "error"
+			failed++
+		} else {
+			result["status"] = "ok"
+		}
+	}
+
+	atomic := r.URL.Query().Get("atomic") == "true"
+	committed := failed == 0 || !atomic
+	rolledBack := 0
+	if committed {
+		skipped, err := s.store.InsertEmbeddings(toInsert)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to store embeddings: "+err.Error())
+			return
+		}
+		// A prompt embedded by another request since this one checked was
+		// already announced by it
+		for i, e := range toInsert {
+			if slices.Contains(skipped, e.PromptID) {
+				continue
+			}
+			publishPointAdded(s.events, s.store, e.PromptID, insertedText[i], e.Vector)
+		}
+	} else {
+		for _, e := range toInsert {
+			for _, result := range resultsByID[e.PromptID] {
+				result["status"] = "rolled_back"
+				rolledBack++
+			}
+		}
+	}
+
+	status := http.StatusOK
+	if failed > 0 {
+		status = http.StatusMultiStatus
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"results": results,
+		"summary": map[string]interface{}{
+			"total":       len(results),
+			"succeeded":   len(results) - failed - rolledBack,
+			"failed":      failed,
+			"rolled_back": rolledBack,
+			"committed":   committed,
+		},
+	})
+}
+
+// POST /tsne/compute?method=tsne|pca|random_projection - Recompute projections
+// The run is skipped ("cached": true) if the embeddings and settings match the
+// stored projections; ?force=true recomputes anyway.
+// Accepts an optional JSON body of t-SNE hyperparameters:
+// {"dimensions": 3, "perplexity": 30, "iterations": 1000, "learning_rate": 200, "random_seed": 42,
+// "grid_resolution": 0, "jitter": 0, "jitter_seed": 0, "incremental": false, "normalize": false,
+// "timeout_seconds": 0, "init": "pca", "metric": "euclidean", "pca_components": 0}
+// With "normalize": true, each embedding is scaled to unit length first.
+// t-SNE starts from a PCA layout unless "init" is "random", which is slower to
+// converge and less stable between runs. "metric": "cosine" has t-SNE
+// compare embeddings by direction rather than euclidean distance, which
+// often separates clusters better. "pca_components": 50 first reduces
+// the embeddings to 50 dimensions with PCA, which speeds t-SNE up on large
+// sets at little cost to the layout.
+// dimensions may be 1 to 16; past 3, t-SNE uses its exact method, which is slow
+// for large sets, and /points reports every axis in "coords".
+// A Python reducer still running after timeout_seconds (by default a few minutes,
+// more for larger sets) is killed and the request fails with 504.
+// With "incremental": true, existing points keep their positions as a starting
+// layout and new points start near their nearest projected neighbors.
+// With "z_from_metadata": "created_at" or "embedded_at", the reducer computes
+// only X and Y and Z is that timestamp min-max normalized to [-1, 1], oldest
+// at -1 and newest at 1.
+// If the embeddings come from more than one model, the response carries a "warning".
+// The response includes t-SNE's final "kl_divergence", or for PCA each axis's
+// "explained_variance_ratio", to compare layouts by; a cached run repeats them.
+// With ?dry_run=true nothing is run: the response says how many points would
+// be projected, whether the run would be cached, and "estimated_ms" from the
+// per-point time of the method's last run (null if it hasn't run).
+// Runs happen one at a time. A request identical to the running one (same
+// method, parameters and force) shares its result rather than running again;
+// any other waits its turn.
+// {"ids": [...]} or {"tag": "..."} alongside the parameters lays out only
+// those prompts, updating their projections and leaving the rest as they are;
+// the run must have as many dimensions as the stored projections.
+// A run stopped by POST /tsne/cancel responds with "status": "cancelled" and
+// leaves the stored projections as they were.
+// Each run that replaces the projections gets a new "run_id". With
+// ?snapshot=true the projections being replaced are first kept under their
+// run ID ("snapshot_run_id") for GET /tsne/diff.
+func (s *Server) handleTSNECompute(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Hyperparameters are optional; an empty body uses the defaults
+	var req struct {
+		tsne.TSNEParams
+		// IDs or Tag limits the run to those prompts
+		IDs []int64 `json:"ids"`
+		Tag string  `json:"tag"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	params := req.TSNEParams
+	if params.Perplexity < 0 || params.Iterations < 0 || params.LearningRate < 0 || params.GridResolution < 0 || params.Jitter < 0 || params.NNeighbors < 0 || params.MinDist < 0 || params.TimeoutSeconds < 0 || params.PCAComponents < 0 {
+		writeJSONError(w, http.StatusBadRequest, "Parameters must not be negative")
+		return
+	}
+	if params.Init != "" && params.Init != tsne.InitPCA && params.Init != tsne.InitRandom {
+		writeJSONError(w, http.StatusBadRequest, "init must be pca or random")
+		return
+	}
+	if params.Metric != "" && params.Metric != tsne.MetricEuclidean && params.Metric != tsne.MetricCosine {
+		writeJSONError(w, http.StatusBadRequest, "metric must be euclidean or cosine")
+		return
+	}
+	if params.Dims() < 1 || params.Dims() > tsne.MaxDimensions {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Dimensions must be between 1 and %d", tsne.MaxDimensions))
+		return
+	}
+	// Incremental starting positions are only kept in three dimensions
+	if params.Incremental && params.Dims() > 3 {
+		writeJSONError(w, http.StatusBadRequest, "incremental requires at most 3 dimensions")
+		return
+	}
+	if params.ZFromMetadata != "" {
+		if _, ok := metadataFields[params.ZFromMetadata]; !ok {
+			writeJSONError(w, http.StatusBadRequest, "z_from_metadata must be created_at or embedded_at")
+			return
+		}
+		if params.Dims() != 3 {
+			writeJSONError(w, http.StatusBadRequest, "z_from_metadata requires 3 dimensions")
+			return
+		}
+	}
+
+	method := r.URL.Query().Get("method")
+	if method == "" {
+		method = defaultReducer
+	}
+	reducer, ok := s.reducers[method]
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, "Unknown method: "+method)
+		return
+	}
+
+	force := r.URL.Query().Get("force") == "true"
+
+	var subset []int64
+	switch {
+	case req.IDs != nil && req.Tag != "":
+		writeJSONError(w, http.StatusBadRequest, "Give either ids or tag, not both")
+		return
+	case req.IDs != nil:
+		if len(req.IDs) == 0 {
+			writeJSONError(w, http.StatusBadRequest, "ids must not be empty")
+			return
+		}
+		subset = req.IDs
+	case req.Tag != "":
+		var err error
+		subset, err = s.store.PromptIDsWithTag(r.Context(), req.Tag)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to get tagged prompts: "+err.Error())
+			return
+		}
+		if len(subset) == 0 {
+			writeJSONError(w, http.StatusBadRequest, "No prompts are tagged "+req.Tag)
+			return
+		}
+	}
+	if subset != nil {
+		// The rest of the layout keeps its axes, so the subset must have as many
+		// dimensions as the stored projections
+		stored, err := s.store.GetMeta("projection_dimensions")
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to get projection dimensions: "+err.Error())
+			return
+		}
+		if stored != "" && stored != strconv.Itoa(params.Dims()) {
+			writeJSONError(w, http.StatusBadRequest, "The stored projections have "+stored+" dimensions, and a run over ids or tag must match")
+			return
+		}
+	}
+
+	// Vectors from different models live in unrelated spaces, so a layout
+	// mixing them is meaningless; still run, but say so
+	models, err := s.store.EmbeddingModels(r.Context())
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to get embedding models: "+err.Error())
+		return
+	}
+	var warning string
+	if len(models) > 1 {
+		warning = fmt.Sprintf("embeddings come from %d models (%s), whose spaces aren't comparable; POST /reembed to embed everything with the current model",
+			len(models), strings.Join(models, ", "))
+		slog.Warn("Projecting embeddings from several models", "models", models)
+	}
+
+	if r.URL.Query().Get("dry_run") == "true" {
+		resp, err := s.estimateProjection(r.Context(), method, params, force, subset)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to estimate projection: "+err.Error())
+			return
+		}
+		if warning != "" {
+			resp["warning"] = warning
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	var snapshotRunID int64
+	if r.URL.Query().Get("snapshot") == "true" {
+		snapshotRunID, err = s.store.SnapshotProjections(s.projectionHistory)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to snapshot projections: "+err.Error())
+			return
+		}
+	}
+
+	start := time.Now()
+	result, err := s.computeProjections(method, reducer, params, force, subset)
+	if errors.Is(err, context.Canceled) {
+		slog.Info("Projection cancelled", "method", method, "run_id", result.runID)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(withRunID(map[string]interface{}{
+			"status":              "cancelled",
+			"points_processed":    0,
+			"computation_time_ms": time.Since(start).Milliseconds(),
+		}, result.runID))
+		return
+	}
+	if err != nil {
+		slog.Error("Projection failed", "method", method, "run_id", result.runID, "dimensions", params.Dims(), "embedding_dim", db.Dimension, "err", err)
+		if errors.Is(err, tsne.ErrTimeout) {
+			writeJSONError(w, http.StatusGatewayTimeout, "Projection failed: "+err.Error())
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, "Projection failed: "+err.Error())
+		return
+	}
+	elapsed := time.Since(start)
+
+	resp := map[string]interface{}{
+		"status":              "completed",
+		"cached":              result.cached,
+		"points_processed":    result.processed,
+		"computation_time_ms": elapsed.Milliseconds(),
+	}
+	if warning != "" {
+		resp["warning"] = warning
+	}
+	if snapshotRunID != 0 {
+		resp["snapshot_run_id"] = snapshotRunID
+	}
+	if result.processed > 0 {
+		resp["run_id"] = result.runID
+		quality := s.lastProjectionQuality()
+		if quality.KLDivergence != nil {
+			resp["kl_divergence"] = *quality.KLDivergence
+		}
+		if quality.ExplainedVarianceRatio != nil {
+			resp["explained_variance_ratio"] = quality.ExplainedVarianceRatio
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// POST /tsne/cancel - Stop the running projection
+//
+// The reducer's subprocess is killed and the stored projections are left as
+// they were. "cancelled" is false if nothing was running; runs waiting their
+// turn aren't affected.
+func (s *Server) handleTSNECancel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	s.cancelMu.Lock()
+	cancel := s.cancelProjection
+	s.cancelMu.Unlock()
+	if cancel != nil {
+		cancel()
+		slog.Info("Cancelling projection")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"cancelled": cancel != nil})
+}
+
+// computeProjections reduces every stored embedding with reducer, replaces the
+// stored projections and records the run's settings. It returns the number of
+// points projected and the run's ID. Each run that gets as far as reducing is
+// recorded in tsne_runs, however it ends. Progress is published to
+// /tsne/progress subscribers, tagged with the run ID.
+//
+// Unless force is set, the run is skipped (cached is true, with the ID of the
+// run that produced the stored projections) when they came from the same
+// embeddings, method and parameters.
+// A non-nil subset limits the run to those prompts, updating only their
+// projections; such a run is never cached.
+// POST /tsne/cancel stops the run, which then returns an error wrapping
+// context.Canceled. Identical calls made while one runs share its outcome.
+func (s *Server) computeProjections(method string, reducer tsne.Reducer, params tsne.TSNEParams, force bool, subset []int64) (projectionResult, error) {
+	runJSON, err := projectionRunJSON(method, params)
+	if err != nil {
+		return projectionResult{}, err
+	}
+	key := string(runJSON) + " force=" + strconv.FormatBool(force)
+	if subset != nil {
+		key += fmt.Sprint(" subset=", subset)
+	}
+	v, err, shared := s.projectionGroup.Do(key, func() (interface{}, error) {
+		return s.computeProjectionsExclusive(method, reducer, params, force, subset)
+	})
+	if shared {
+		slog.Debug("Shared a projection run with a concurrent request", "method", method)
+	}
+	// A failed run still has an ID if it got as far as being recorded
+	return v.(projectionResult), err
+}
+
+// projectionResult is what computeProjections shares between identical calls
+type projectionResult struct {
+	processed int
+	cached    bool
+	// runID identifies the run, 0 if there was nothing to project
+	runID int64
+}
+
+// computeProjectionsExclusive does the work of computeProjections, waiting
+// for any other run to finish first
+func (s *Server) computeProjectionsExclusive(method string, reducer tsne.Reducer, params tsne.TSNEParams, force bool, subset []int64) (projectionResult, error) {
+	s.projectionMu.Lock()
+	defer s.projectionMu.Unlock()
+
+	// The run outlives the request that started it, so only a cancel stops it
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancelMu.Lock()
+	s.cancelProjection = cancel
+	s.cancelMu.Unlock()
+	defer func() {
+		s.cancelMu.Lock()
+		s.cancelProjection = nil
+		s.cancelMu.Unlock()
+		cancel()
+	}()
+
+	start := time.Now()
+	result, err := s.runProjection(ctx, method, reducer, params, force, subset)
+	if errors.Is(err, context.Canceled) {
+		s.progress.publish("cancelled", withRunID(map[string]interface{}{}, result.runID))
+		return projectionResult{runID: result.runID}, err
+	}
+	if err != nil {
+		s.progress.publish("error", withRunID(map[string]interface{}{"error": err.Error()}, result.runID))
+		return projectionResult{runID: result.runID}, err
+	}
+	processed, cached := result.processed, result.cached
+	if !cached && processed > 0 {
+		perPoint := time.Since(start).Seconds() * 1000 / float64(processed)
+		if err := s.store.SetMeta(projectionCostKey(method), strconv.FormatFloat(perPoint, 'g', -1, 64)); err != nil {
+			slog.Warn("Failed to record projection cost", "err", err)
+		}
+	}
+	s.progress.publish("done", withRunID(map[string]interface{}{"points_processed": processed, "cached": cached}, result.runID))
+	if !cached && processed > 0 {
+		s.events.publish("projection_updated", map[string]interface{}{"method": method, "points_processed": processed, "run_id": result.runID})
+	}
+	return result, nil
+}
+
+// withRunID adds a run's ID to a progress event's data, unless it is 0
+func withRunID(data map[string]interface{}, runID int64) map[string]interface{} {
+	if runID != 0 {
+		data["run_id"] = runID
+	}
+	return data
+}
+
+// runProgress is a reducer's progress report tagged with the run it is for
+type runProgress struct {
+	tsne.Progress
+	RunID int64 `json:"run_id"`
+}
+
+// runProjection does the work of computeProjections. Embeddings are read
+// from the store as they are needed rather than all up front, and with a
+// tsne.StreamReducer they go straight to the reducer, so the set never has to
+// be in memory at once.
+func (s *Server) runProjection(ctx context.Context, method string, reducer tsne.Reducer, params tsne.TSNEParams, force bool, subset []int64) (result projectionResult, err error) {
+	var only map[int64]bool
+	if subset != nil {
+		only = make(map[int64]bool, len(subset))
+		for _, id := range subset {
+			only[id] = true
+		}
+	}
+
+	// An incremental run starts each point from its stored position. Points
+	// without one are placed among their projected neighbors, which takes a
+	// query per point; the store can't be queried while ForEachEmbedding's
+	// rows are open, so the first pass sets their vectors aside instead.
+	var layout map[int64][3]float64
+	var unplaced map[int64][]float32
+	if params.Incremental {
+		if layout, err = s.storedLayout(ctx); err != nil {
+			return result, fmt.Errorf("seed incremental layout: %w", err)
+		}
+		unplaced = make(map[int64][]float32)
+	}
+
+	// A first pass counts and hashes the embeddings to check the cache, and
+	// finds any of the wrong length (e.g. stored before a model change),
+	// which would make the reducer fail with an opaque error
+	count := 0
+	hasher := db.NewEmbeddingHasher()
+	var mismatched []int64
+	err = s.store.ForEachEmbedding(ctx, func(e db.EmbeddingData) error {
+		if only != nil && !only[e.PromptID] {
+			return nil
+		}
+		count++
+		hasher.Add(e)
+		if _, ok := layout[e.PromptID]; layout != nil && !ok {
+			unplaced[e.PromptID] = e.Vector
+		}
+		if len(e.Vector) != db.Dimension {
+			mismatched = append(mismatched, e.PromptID)
+		}
+		return nil
+	})
+	if err != nil {
+		return result, fmt.Errorf("get embeddings: %w", err)
+	}
+	if count == 0 {
+		return result, nil
+	}
+
+	runJSON, err := projectionRunJSON(method, params)
+	if err != nil {
+		return result, err
+	}
+
+	if !force && only == nil {
+		storedHash, _ := s.store.GetMeta("projection_hash")
+		storedRun, _ := s.store.GetMeta("projection_run")
+		if storedHash == hasher.Sum() && storedRun == string(runJSON) {
+			runID, err := s.store.ProjectionRunID()
+			if err != nil {
+				return result, fmt.Errorf("get projection run ID: %w", err)
+			}
+			return projectionResult{processed: count, cached: true, runID: runID}, nil
+		}
+	}
+
+	// From here the run is recorded in tsne_runs, ending however it ends
+	result.runID, err = s.store.StartProjectionRun(method, string(runJSON), count)
+	if err != nil {
+		return result, fmt.Errorf("record run: %w", err)
+	}
+	started := time.Now()
+	var historyJSON string
+	s.runningProjection.Store(result.runID)
+	defer func() {
+		s.runningProjection.Store(0)
+		status, errMsg := db.RunCompleted, ""
+		if errors.Is(err, context.Canceled) {
+			status = db.RunCancelled
+		} else if err != nil {
+			status, errMsg = db.RunFailed, err.Error()
+		}
+		if err := s.store.FinishProjectionRun(result.runID, status, time.Since(started), historyJSON, errMsg); err != nil {
+			slog.Warn("Failed to record projection run", "run_id", result.runID, "err", err)
+		}
+	}()
+	s.progress.publish("start", map[string]interface{}{"method": method, "points": count, "run_id": result.runID})
+	if len(mismatched) > 0 {
+		return result, fmt.Errorf("%w: prompts %v do not have %d-dimensional embeddings", db.ErrDimensionMismatch, mismatched, db.Dimension)
+	}
+
+	if err := s.placeUnprojected(ctx, layout, unplaced); err != nil {
+		return result, fmt.Errorf("seed incremental layout: %w", err)
+	}
+
+	// The second pass converts to t-SNE input format. It hashes what it
+	// yields, not the first pass, so a concurrent insert leaves the stored
+	// projections marked as stale. Norms come from the raw embeddings, so they
+	// carry signal even with Normalize set.
+	var hash string
+	norms := make(map[int64]float64, count)
+	stream := func(yield func(tsne.EmbeddingInput) error) error {
+		hasher := db.NewEmbeddingHasher()
+		err := s.store.ForEachEmbedding(ctx, func(e db.EmbeddingData) error {
+			if only != nil && !only[e.PromptID] {
+				return nil
+			}
+			hasher.Add(e)
+			norms[e.PromptID] = vectorNorm(e.Vector)
+			input := tsne.EmbeddingInput{ID: e.PromptID, Vector: e.Vector}
+			if init, ok := layout[e.PromptID]; ok {
+				input.Init = &init
+			}
+			if params.Normalize {
+				input.Vector = vecmath.Normalize(input.Vector)
+			}
+			return yield(input)
+		})
+		hash = hasher.Sum()
+		return err
+	}
+
+	// With Z taken from metadata the reducer only lays out X and Y
+	reduceParams := params
+	if params.ZFromMetadata != "" {
+		reduceParams.Dimensions = 2
+	}
+
+	var output *tsne.TSNEOutput
+	if streamer, ok := reducer.(tsne.StreamReducer); ok {
+		output, err = streamer.ReduceStream(ctx, count, stream, reduceParams)
+	} else {
+		var tsneInput []tsne.EmbeddingInput
+		err = stream(func(input tsne.EmbeddingInput) error {
+			tsneInput = append(tsneInput, input)
+			return nil
+		})
+		if err != nil {
+			return result, fmt.Errorf("get embeddings: %w", err)
+		}
+		output, err = reducer.Reduce(ctx, tsneInput, reduceParams)
+	}
+	if err != nil {
+		return result, fmt.Errorf("%s: %w", method, err)
+	}
+	// The pure Go reducers don't watch ctx, so a cancel during one lands here
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	if params.ZFromMetadata != "" {
+		if err := s.setZFromMetadata(ctx, output, params.ZFromMetadata); err != nil {
+			return result, fmt.Errorf("z from metadata: %w", err)
+		}
+	}
+	tsne.CompleteCoords(output, params.Dims())
+	tsne.SnapToGrid(output, params.GridResolution)
+	tsne.Jitter(output, params.Jitter, params.JitterSeedOrDefault(), params.Dims())
+
+	projections := make([]db.Projection, len(output.Projections))
+	for i, p := range output.Projections {
+		projections[i] = db.Projection{
+			PromptID: p.ID,
+			X:        p.X,
+			Y:        p.Y,
+			Z:        p.Z,
+			Coords:   p.Coords,
+			Norm:     norms[p.ID],
+		}
+	}
+
+	store := s.store.ReplaceAllProjections
+	if only != nil {
+		store = s.store.InsertProjections
+		// The layout now mixes runs, so no later run can be skipped as cached
+		hash = ""
+	}
+	if err := store(projections, result.runID); err != nil {
+		return result, fmt.Errorf("store projections: %w", err)
+	}
+	if err := s.store.SetMeta("projection_dimensions", strconv.Itoa(params.Dims())); err != nil {
+		slog.Warn("Failed to record projection dimensions", "err", err)
 	}
-	log.Println("Database initialized")
 
-	// Initialize Ollama client
-	ollamaClient = ollama.NewClient("")
+	if err := s.store.SetMeta("projection_run", string(runJSON)); err != nil {
+		slog.Warn("Failed to record projection params", "err", err)
+	}
+	if err := s.store.SetMeta("projection_hash", hash); err != nil {
+		slog.Warn("Failed to record embedding hash", "err", err)
+	}
+	if err := s.store.SetMeta(db.ProjectedAtKey, time.Now().UTC().Format(time.RFC3339)); err != nil {
+		slog.Warn("Failed to record projection time", "err", err)
+	}
 
-	// Set up routes
-	http.HandleFunc("/embed", handleEmbed)
-	http.HandleFunc("/tsne/compute", handleTSNECompute)
-	http.HandleFunc("/points", handlePoints)
-	http.Handle("/", http.FileServer(http.Dir("static")))
+	// Keep the convergence curve for /tsne/history (empty for non-iterative reducers)
+	history := output.History
+	if history == nil {
+		history = []tsne.HistoryPoint{}
+	}
+	if b, err := json.Marshal(history); err != nil {
+		slog.Warn("Failed to encode t-SNE history", "err", err)
+	} else {
+		historyJSON = string(b)
+	}
 
-	log.Println("Server starting on http://localhost:8080")
-	if err := http.ListenAndServe(":8080", nil); err != nil {
-		log.Fatalf("Server failed: %v", err)
+	quality := projectionQuality{KLDivergence: output.KLDivergence, ExplainedVarianceRatio: output.ExplainedVarianceRatio}
+	if qualityJSON, err := json.Marshal(quality); err != nil {
+		slog.Warn("Failed to encode projection quality", "err", err)
+	} else if err := s.store.SetMeta("projection_quality", string(qualityJSON)); err != nil {
+		slog.Warn("Failed to record projection quality", "err", err)
 	}
+
+	result.processed = len(projections)
+	return result, nil
 }
 
-// POST /embed - Add a new embedding
-func handleEmbed(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// lastProjectionQuality returns the quality measures recorded with the stored
+// projections, empty if the reducer gave none
+func (s *Server) lastProjectionQuality() projectionQuality {
+	var quality projectionQuality
+	v, _ := s.store.GetMeta("projection_quality")
+	if v == "" {
+		return quality
+	}
+	if err := json.Unmarshal([]byte(v), &quality); err != nil {
+		slog.Warn("Failed to decode projection quality", "err", err)
+	}
+	return quality
+}
+
+// projectionRunJSON encodes the effective settings of a run, which are
+// recorded so a layout can be reproduced and compared to tell a cached run
+func projectionRunJSON(method string, params tsne.TSNEParams) ([]byte, error) {
+	run := projectionRun{Method: method, TSNEParams: params}
+	run.Dimensions = params.Dims()
+	run.RandomSeed = params.Seed()
+	// The deadline doesn't change the layout, so it shouldn't defeat the cache
+	run.TimeoutSeconds = 0
+	if params.Jitter > 0 {
+		run.JitterSeed = params.JitterSeedOrDefault()
+	}
+	runJSON, err := json.Marshal(run)
+	if err != nil {
+		return nil, fmt.Errorf("encode projection params: %w", err)
+	}
+	return runJSON, nil
+}
+
+// projectionCostKey is the meta key holding how many milliseconds per point
+// the last uncached run of method took
+func projectionCostKey(method string) string {
+	return "projection_ms_per_point:" + method
+}
+
+// estimateProjection describes what computeProjections would do without
+// running a reducer or touching the projections. For a subset, points counts
+// the prompts asked for, some of which may have no embedding.
+func (s *Server) estimateProjection(ctx context.Context, method string, params tsne.TSNEParams, force bool, subset []int64) (map[string]interface{}, error) {
+	points, err := s.store.GetActiveEmbeddingCount(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if subset != nil {
+		points, force = len(subset), true
+	}
+	runJSON, err := projectionRunJSON(method, params)
+	if err != nil {
+		return nil, err
+	}
+
+	cached := false
+	if !force && points > 0 {
+		storedRun, err := s.store.GetMeta("projection_run")
+		if err != nil {
+			return nil, err
+		}
+		if storedRun == string(runJSON) {
+			stale, err := s.projectionsStale(ctx)
+			if err != nil {
+				return nil, err
+			}
+			cached = !stale
+		}
+	}
+
+	// Costs grow faster than linearly for t-SNE, so this is only a rough
+	// guide, best when the set hasn't changed much since the last run
+	var estimate interface{}
+	if cached || points == 0 {
+		estimate = 0
+	} else if v, err := s.store.GetMeta(projectionCostKey(method)); err != nil {
+		return nil, err
+	} else if perPoint, err := strconv.ParseFloat(v, 64); err == nil {
+		estimate = int64(perPoint * float64(points))
+	}
+
+	return map[string]interface{}{
+		"status":       "dry_run",
+		"method":       method,
+		"points":       points,
+		"cached":       cached,
+		"estimated_ms": estimate,
+	}, nil
+}
+
+// projectionsStale reports whether the stored embeddings differ from the ones
+// the stored projections were computed from. Comparing content rather than
+// counts catches a re-embedded prompt, which leaves both counts unchanged.
+// Projections from before the hash was recorded can't be checked, so they
+// count as stale whenever there are embeddings. The store keeps the hash
+// until the embeddings change, so checking on every request is cheap.
+func (s *Server) projectionsStale(ctx context.Context) (bool, error) {
+	storedHash, err := s.store.GetMeta("projection_hash")
+	if err != nil {
+		return false, err
+	}
+	if storedHash == "" {
+		embedCount, err := s.store.GetEmbeddingCount(ctx)
+		return embedCount > 0, err
+	}
+
+	hash, err := s.store.EmbeddingSetHash(ctx)
+	if err != nil {
+		return false, err
+	}
+	return hash != storedHash, nil
+}
+
+// lastProjectionRun returns the settings of the run that produced the stored
+// projections, or nil if none has run
+func (s *Server) lastProjectionRun() *projectionRun {
+	v, _ := s.store.GetMeta("projection_run")
+	if v == "" {
+		return nil
+	}
+	var run projectionRun
+	if err := json.Unmarshal([]byte(v), &run); err != nil {
+		slog.Warn("Failed to decode projection params", "err", err)
+		return nil
+	}
+	return &run
+}
+
+// storedLayout returns the stored projections' positions, keyed by prompt ID,
+// for an incremental run to start from. If nothing has been projected yet
+// there is no layout to keep, and it returns nil so inputs are left for a
+// full run.
+func (s *Server) storedLayout(ctx context.Context) (map[int64][3]float64, error) {
+	projections, err := s.store.GetAllProjections(ctx, false)
+	if err != nil || len(projections) == 0 {
+		return nil, err
+	}
+	layout := make(map[int64][3]float64, len(projections))
+	for _, p := range projections {
+		layout[p.PromptID] = [3]float64{p.X, p.Y, p.Z}
+	}
+	return layout, nil
+}
+
+// placeUnprojected adds to layout a starting position for each vector in
+// unplaced, the weighted average of its nearest projected neighbors. Raw
+// vectors are matched against the stored ones, so they must not be
+// normalized yet. It does nothing for a nil layout.
+func (s *Server) placeUnprojected(ctx context.Context, layout map[int64][3]float64, unplaced map[int64][]float32) error {
+	if layout == nil {
+		return nil
+	}
+	for id, vector := range unplaced {
+		x, y, z, err := s.store.ProjectNewPoint(ctx, vector)
+		if err != nil && !errors.Is(err, db.ErrNoProjectedNeighbors) {
+			return err
+		}
+		layout[id] = [3]float64{x, y, z}
+	}
+	return nil
+}
+
+// GET /tsne/history?run_id=3 - Get the convergence curve of a t-SNE run
+// run_id is one /tsne/compute returned, and defaults to the run the stored
+// projections came from. Runs of non-iterative reducers have an empty curve.
+func (s *Server) handleTSNEHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	var req struct {
-		Prompt string `json:"prompt"`
+	var runID int64
+	var err error
+	requested := r.URL.Query().Get("run_id")
+	if requested != "" {
+		runID, err = strconv.ParseInt(requested, 10, 64)
+		if err != nil || runID < 1 {
+			writeJSONError(w, http.StatusBadRequest, "run_id must be a run ID")
+			return
+		}
+	} else if runID, err = s.store.ProjectionRunID(); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to get projection run ID: "+err.Error())
+		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+
+	var stored string
+	run, err := s.store.GetProjectionRun(r.Context(), runID)
+	switch {
+	case err == nil:
+		stored = run.History
+	case errors.Is(err, db.ErrRunNotFound) && requested != "":
+		writeJSONError(w, http.StatusNotFound, "Run "+requested+" not found")
+		return
+	case errors.Is(err, db.ErrRunNotFound):
+		// Layouts computed before runs were recorded kept their curve in meta
+		if stored, err = s.store.GetMeta("tsne_history"); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to get history: "+err.Error())
+			return
+		}
+	default:
+		writeJSONError(w, http.StatusInternalServerError, "Failed to get history: "+err.Error())
 		return
 	}
 
-	if req.Prompt == "" {
-		http.Error(w, "Prompt is required", http.StatusBadRequest)
+	history := []tsne.HistoryPoint{}
+	if stored != "" {
+		if err := json.Unmarshal([]byte(stored), &history); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to decode history: "+err.Error())
+			return
+		}
+	}
+
+	resp := map[string]interface{}{
+		"run_id":  nil,
+		"history": history,
+	}
+	if runID != 0 {
+		resp["run_id"] = runID
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// GET /points?tag=foo&meta.author=bar&near=12&radius=0.5&include_deleted=true&include_norm=true -
+// Get all 3D projections, optionally filtered by tag and metadata. near and radius keep only points
+// within radius of prompt near in the original embedding space, adding each point's distance.
+// Soft-deleted prompts are hidden unless include_deleted is set. include_norm adds each embedding's
+// L2 norm as recorded by the last projection run, or null if that run predates it.
+// xmin, xmax, ymin, ymax, zmin and zmax keep only points inside that box, for loading just the
+// viewport. limit and offset page through the matching points; total counts them all.
+// Each point's cluster is the one from the last POST /cluster, or null, and its model is the one that
+// produced its embedding, or null if that wasn't recorded. color is the one set with
+// PATCH /prompts/{id}, or null.
+// bounds ({"min", "max"} corners) and centroid cover every matching point, not just the page, for
+// framing the scene; both are null when nothing matches.
+// coords holds all of a point's projected dimensions, however many the last run computed; x, y and z
+// are its first three, zero past the run's dimensions.
+// Coordinates are rounded to VECVIZ_COORD_PRECISION decimals, 4 by default, to keep the response
+// small; precision overrides it with 1 to 15 decimals or full. Stored projections aren't rounded.
+func (s *Server) handlePoints(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	// Check if prompt already exists
-	existingID, _ := db.InsertPrompt(req.Prompt)
+	box, err := parseBoundingBox(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Without a limit every matching point is returned
+	limit := 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPointsLimit {
+			writeJSONError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxPointsLimit))
+			return
+		}
+	}
+	offset := 0
+	if v := r.URL.Query().Get("offset"); v != "" {
+		offset, err = strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			writeJSONError(w, http.StatusBadRequest, "Invalid offset")
+			return
+		}
+	}
+
+	// ?near=12&radius=0.5 keeps points within radius of prompt 12's embedding
+	var distances map[int64]float64
+	near, radius := r.URL.Query().Get("near"), r.URL.Query().Get("radius")
+	if near != "" || radius != "" {
+		if near == "" || radius == "" {
+			writeJSONError(w, http.StatusBadRequest, "near and radius must be given together")
+			return
+		}
+		nearID, err := strconv.ParseInt(near, 10, 64)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid near prompt id")
+			return
+		}
+		maxDistance, err := strconv.ParseFloat(radius, 64)
+		if err != nil || maxDistance < 0 {
+			writeJSONError(w, http.StatusBadRequest, "radius must be a non-negative number")
+			return
+		}
+		distances, err = s.store.WithinDistance(r.Context(), nearID, maxDistance)
+		if errors.Is(err, db.ErrEmbeddingNotFound) {
+			writeJSONError(w, http.StatusNotFound, "Embedding not found")
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to search neighborhood: "+err.Error())
+			return
+		}
+	}
+
+	includeDeleted := r.URL.Query().Get("include_deleted") == "true"
+	includeNorm := r.URL.Query().Get("include_norm") == "true"
+	projections, err := s.store.GetAllProjections(r.Context(), includeDeleted)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to get projections: "+err.Error())
+		return
+	}
+
+	tags, err := s.store.GetAllTags(r.Context())
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to get tags: "+err.Error())
+		return
+	}
+
+	if tag := r.URL.Query().Get("tag"); tag != "" {
+		filtered := projections[:0]
+		for _, p := range projections {
+			if slices.Contains(tags[p.PromptID], tag) {
+				filtered = append(filtered, p)
+			}
+		}
+		projections = filtered
+	}
+
+	if distances != nil {
+		filtered := projections[:0]
+		for _, p := range projections {
+			if _, ok := distances[p.PromptID]; ok {
+				filtered = append(filtered, p)
+			}
+		}
+		projections = filtered
+	}
+
+	// ?xmin=-0.5&xmax=0.5 keeps points inside the viewport; unset bounds are open
+	inBox := projections[:0]
+	for _, p := range projections {
+		if box.contains(p) {
+			inBox = append(inBox, p)
+		}
+	}
+	projections = inBox
+
+	metadata, err := s.store.GetAllMetadata(r.Context())
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to get metadata: "+err.Error())
+		return
+	}
+
+	// ?meta.author=Alice keeps points whose author is Alice; all given fields must match
+	for param, values := range r.URL.Query() {
+		key, ok := strings.CutPrefix(param, "meta.")
+		if !ok {
+			continue
+		}
+		filtered := projections[:0]
+		for _, p := range projections {
+			if value, ok := metadata[p.PromptID][key]; ok && value == values[0] {
+				filtered = append(filtered, p)
+			}
+		}
+		projections = filtered
+	}
+
+	precision := s.coordPrecision
+	if v := r.URL.Query().Get("precision"); v != "" {
+		if precision, err = parseCoordPrecision(v); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	roundProjections(projections, precision)
 
-	// Check if embedding already exists for this prompt
-	embedCount, _ := db.GetEmbeddingCount()
-	projCount, _ := db.GetProjectionCount()
+	// Page after filtering, so total and offset count only matching points
+	total := len(projections)
+	bounds, centroid := extent(projections)
+	// Bounds come from rounded points, but their mean needs rounding again
+	if c, ok := centroid.(map[string]float64); ok {
+		for axis, v := range c {
+			c[axis] = roundTo(v, precision)
+		}
+	}
+	projections = projections[min(offset, total):]
+	if limit > 0 && len(projections) > limit {
+		projections = projections[:limit]
+	}
 
-	// Get embedding from Ollama
-	embedding, err := ollamaClient.GetEmbedding(req.Prompt)
+	needsUpdate, err := s.projectionsStale(r.Context())
 	if err != nil {
-		log.Printf("Ollama error: %v", err)
-		http.Error(w, "Failed to get embedding: "+err.Error(), http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to check projections: "+err.Error())
 		return
 	}
 
-	// Store embedding
-	if err := db.InsertEmbedding(existingID, embedding); err != nil {
-		// Might already exist, which is fine
-		log.Printf("Insert embedding: %v", err)
+	dimensions := tsne.DefaultDimensions
+	if v, _ := s.store.GetMeta("projection_dimensions"); v != "" {
+		dimensions, _ = strconv.Atoi(v)
+	}
+
+	// Settings of the run that produced these projections, or null if none has run
+	params := s.lastProjectionRun()
+
+	points := make([]map[string]interface{}, len(projections))
+	for i, p := range projections {
+		pointTags := tags[p.PromptID]
+		if pointTags == nil {
+			pointTags = []string{}
+		}
+		pointMetadata := metadata[p.PromptID]
+		if pointMetadata == nil {
+			pointMetadata = map[string]string{}
+		}
+		points[i] = map[string]interface{}{
+			"id":         p.PromptID,
+			"text":       p.Text,
+			"created_at": p.CreatedAt.UTC().Format(time.RFC3339),
+			"tags":       pointTags,
+			"metadata":   pointMetadata,
+			"x":          p.X,
+			"y":          p.Y,
+			"z":          p.Z,
+			"coords":     pointCoords(p, dimensions),
+		}
+		if p.Cluster != db.NoCluster {
+			points[i]["cluster"] = p.Cluster
+		} else {
+			points[i]["cluster"] = nil
+		}
+		if p.Model != "" {
+			points[i]["model"] = p.Model
+		} else {
+			points[i]["model"] = nil
+		}
+		if p.Color != "" {
+			points[i]["color"] = p.Color
+		} else {
+			points[i]["color"] = nil
+		}
+		if includeDeleted {
+			points[i]["deleted_at"] = formatDeletedAt(p.DeletedAt)
+		}
+		if distances != nil {
+			points[i]["distance"] = distances[p.PromptID]
+		}
+		if includeNorm {
+			if p.Norm != 0 {
+				points[i]["norm"] = p.Norm
+			} else {
+				points[i]["norm"] = nil
+			}
+		}
+	}
+
+	response := map[string]interface{}{
+		"points":       points,
+		"total":        total,
+		"dimensions":   dimensions,
+		"params":       params,
+		"needs_update": needsUpdate,
+		"bounds":       bounds,
+		"centroid":     centroid,
+	}
+	if limit > 0 {
+		response["limit"] = limit
+		response["offset"] = offset
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"id":                existingID,
-		"prompt":            req.Prompt,
-		"embedding_dim":     len(embedding),
-		"needs_tsne_update": embedCount != projCount,
-	})
+	json.NewEncoder(w).Encode(response)
 }
 
-// POST /tsne/compute - Recompute t-SNE projections
-func handleTSNECompute(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// pointCoords returns a point's coordinates in a projection of dims dimensions.
+// Projections stored before coords were kept only have X, Y and Z.
+func pointCoords(p db.Projection, dims int) []float64 {
+	if len(p.Coords) == dims {
+		return p.Coords
+	}
+	coords := make([]float64, dims)
+	copy(coords, []float64{p.X, p.Y, p.Z})
+	return coords
+}
+
+// GET /points/{id}/embedding - Get a prompt's raw embedding vector and how it was generated
+func (s *Server) handlePointEmbedding(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	start := time.Now()
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid prompt id")
+		return
+	}
+
+	vector, err := s.store.GetEmbedding(r.Context(), id)
+	if errors.Is(err, db.ErrEmbeddingNotFound) {
+		writeJSONError(w, http.StatusNotFound, "Embedding not found")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to get embedding: "+err.Error())
+		return
+	}
+
+	context, err := s.store.GetEmbeddingContext(r.Context(), id)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to get embedding context: "+err.Error())
+		return
+	}
+	embeddedAt, err := s.store.GetEmbeddedAt(r.Context(), id)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to get embedding time: "+err.Error())
+		return
+	}
+
+	resp := map[string]interface{}{
+		"id":          id,
+		"context":     context,
+		"embedded_at": nil,
+		"dimension":   len(vector),
+		"embedding":   vector,
+	}
+	if !embeddedAt.IsZero() {
+		resp["embedded_at"] = embeddedAt.UTC().Format(time.RFC3339)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
 
-	// Get all embeddings
-	embeddings, err := db.GetAllEmbeddings()
+// DELETE /prompts/{id}?soft=true - Remove a prompt with its embedding and projection.
+// With soft=true the prompt is only hidden, and POST /prompts/{id}/restore brings it back.
+func (s *Server) handleDeletePrompt(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
 	if err != nil {
-		http.Error(w, "Failed to get embeddings: "+err.Error(), http.StatusInternalServerError)
+		writeJSONError(w, http.StatusBadRequest, "Invalid prompt id")
 		return
 	}
 
-	if len(embeddings) == 0 {
+	if r.URL.Query().Get("soft") == "true" {
+		if err := s.store.SoftDelete(id); err != nil {
+			if errors.Is(err, db.ErrPromptNotFound) {
+				writeJSONError(w, http.StatusNotFound, "Prompt not found")
+				return
+			}
+			writeJSONError(w, http.StatusInternalServerError, "Failed to delete prompt: "+err.Error())
+			return
+		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
-			"status":              "completed",
-			"points_processed":    0,
-			"computation_time_ms": 0,
+			"id":           id,
+			"soft_deleted": true,
 		})
 		return
 	}
 
-	// Convert to t-SNE input format
-	tsneInput := make([]tsne.EmbeddingInput, len(embeddings))
-	for i, e := range embeddings {
-		tsneInput[i] = tsne.EmbeddingInput{
-			ID:     e.PromptID,
-			Vector: e.Vector,
+	if err := s.store.DeletePrompt(id); err != nil {
+		if errors.Is(err, db.ErrPromptNotFound) {
+			writeJSONError(w, http.StatusNotFound, "Prompt not found")
+			return
 		}
+		writeJSONError(w, http.StatusInternalServerError, "Failed to delete prompt: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":      id,
+		"deleted": []string{"prompt", "embedding", "projection"},
+	})
+}
+
+// POST /project - Show where a text would land in the current layout without storing it
+//
+// The text is embedded and placed the way an incremental run seeds a new
+// point, from the projections of its nearest stored neighbors. Nothing is
+// written: not the prompt, its embedding, nor its position, so this is safe
+// to call on every keystroke of a "where would this land?" box. x, y and z
+// are null until something has been projected.
+func (s *Server) handleProject(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req struct {
+		Prompt string `json:"prompt"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	if status, err := s.checkPrompt(req.Prompt); err != nil {
+		writeJSONError(w, status, err.Error())
+		return
 	}
 
-	// Run t-SNE
-	output, err := tsne.ComputeTSNE(tsneInput)
+	result, err := embedResult(r.Context(), s.embedder, req.Prompt)
 	if err != nil {
-		log.Printf("t-SNE error: %v", err)
-		http.Error(w, "t-SNE failed: "+err.Error(), http.StatusInternalServerError)
+		writeJSONError(w, embedErrorStatus(err), "Failed to get embedding: "+err.Error())
 		return
 	}
 
-	// Store projections
-	projections := make([]db.Projection, len(output.Projections))
-	for i, p := range output.Projections {
-		projections[i] = db.Projection{
-			PromptID: p.ID,
-			X:        p.X,
-			Y:        p.Y,
-			Z:        p.Z,
-		}
+	resp := map[string]interface{}{"prompt": req.Prompt}
+	x, y, z, err := s.store.ProjectNewPoint(r.Context(), result.Embedding)
+	switch {
+	case errors.Is(err, db.ErrNoProjectedNeighbors):
+		resp["x"], resp["y"], resp["z"] = nil, nil, nil
+	case err != nil:
+		writeJSONError(w, http.StatusInternalServerError, "Failed to project: "+err.Error())
+		return
+	default:
+		resp["x"], resp["y"], resp["z"] = x, y, z
+	}
+	if usage := embedUsage(result); usage != nil {
+		resp["usage"] = usage
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// POST /project/batch - Place several texts into the current layout without storing them
+// Each text is checked and placed as /project places one, at most
+// maxBatchPrompts of them. One that can't be embedded has an "error"; x, y
+// and z are null until something has been projected.
+func (s *Server) handleProjectBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req struct {
+		Prompts []string `json:"prompts"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+		return
 	}
 
-	if err := db.InsertProjections(projections); err != nil {
-		http.Error(w, "Failed to store projections: "+err.Error(), http.StatusInternalServerError)
+	if len(req.Prompts) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "Prompts are required")
+		return
+	}
+	if len(req.Prompts) > maxBatchPrompts {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("At most %d prompts per batch", maxBatchPrompts))
 		return
 	}
 
-	elapsed := time.Since(start)
+	results := make([]map[string]interface{}, len(req.Prompts))
+	var valid []string
+	var validIndex []int
+	for i, prompt := range req.Prompts {
+		results[i] = map[string]interface{}{"prompt": prompt}
+		if _, err := s.checkPrompt(prompt); err != nil {
+			results[i]["error"] = err.Error()
+			continue
+		}
+		valid = append(valid, prompt)
+		validIndex = append(validIndex, i)
+	}
+
+	embeddings, errs := s.embedAll(r.Context(), valid)
+
+	for j, i := range validIndex {
+		result := results[i]
+		if errs[j] != nil {
+			result["error"] = "Failed to get embedding: " + errs[j].Error()
+			continue
+		}
+
+		x, y, z, err := s.store.ProjectNewPoint(r.Context(), embeddings[j])
+		switch {
+		case errors.Is(err, db.ErrNoProjectedNeighbors):
+			result["x"], result["y"], result["z"] = nil, nil, nil
+		case err != nil:
+			result["error"] = "Failed to project: " + err.Error()
+		default:
+			result["x"], result["y"], result["z"] = x, y, z
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status":              "completed",
-		"points_processed":    len(projections),
-		"computation_time_ms": elapsed.Milliseconds(),
+		"projections": results,
 	})
 }
 
-// GET /points - Get all 3D projections
-func handlePoints(w http.ResponseWriter, r *http.Request) {
+// GET /search?q=...&k=10&dim=256 - Find the prompts nearest to a query
+// dim optionally compares only the leading dimensions (Matryoshka models only).
+// Each result has the database metric's "distance" and the cosine
+// "similarity" in [-1, 1], 1 for the same direction, which is easier to read.
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		writeJSONError(w, http.StatusBadRequest, "Query is required")
 		return
 	}
 
-	projections, err := db.GetAllProjections()
+	k, err := s.parseK(r.URL.Query().Get("k"))
 	if err != nil {
-		http.Error(w, "Failed to get projections: "+err.Error(), http.StatusInternalServerError)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	embedCount, _ := db.GetEmbeddingCount()
-	projCount, _ := db.GetProjectionCount()
+	// Optional Matryoshka truncation; see searchTruncated
+	dim := 0
+	if v := r.URL.Query().Get("dim"); v != "" {
+		var err error
+		dim, err = strconv.Atoi(v)
+		if err != nil || dim < 1 || dim > db.Dimension {
+			writeJSONError(w, http.StatusBadRequest, "dim must be between 1 and "+strconv.Itoa(db.Dimension))
+			return
+		}
+	}
 
-	points := make([]map[string]interface{}, len(projections))
-	for i, p := range projections {
-		points[i] = map[string]interface{}{
-			"id":   p.PromptID,
-			"text": p.Text,
-			"x":    p.X,
-			"y":    p.Y,
-			"z":    p.Z,
+	embedding, err := s.embedder.Embed(r.Context(), query)
+	if err != nil {
+		slog.Error("Embed failed", "query_chars", len(query), "err", err)
+		writeJSONError(w, embedErrorStatus(err), "Failed to get embedding: "+err.Error())
+		return
+	}
+
+	var matches []db.SearchResult
+	if dim > 0 && dim < len(embedding) {
+		matches, err = s.searchTruncated(r.Context(), embedding, k, dim)
+	} else {
+		matches, err = s.store.SearchNearest(r.Context(), embedding, k)
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Search failed: "+err.Error())
+		return
+	}
+
+	compared := embedding
+	if dim > 0 && dim < len(embedding) {
+		compared = embedding[:dim]
+	}
+	similarities, err := s.cosineSimilarities(r.Context(), compared, matches)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to compute similarities: "+err.Error())
+		return
+	}
+
+	results := make([]map[string]interface{}, len(matches))
+	for i, m := range matches {
+		results[i] = map[string]interface{}{
+			"id":         m.PromptID,
+			"text":       m.Text,
+			"distance":   m.Distance,
+			"similarity": similarityOrNil(similarities, m.PromptID),
 		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"points":       points,
-		"needs_update": embedCount != projCount,
+		"query":   query,
+		"metric":  s.store.Metric,
+		"results": results,
 	})
 }
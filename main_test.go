@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tlehman/vecviz/db"
+	"github.com/tlehman/vecviz/ollama"
+	"github.com/tlehman/vecviz/tsne"
+)
+
+// fakeEmbedder embeds each text as a fixed pseudo-random vector derived from
+// it, so the same text always gets the same embedding, and counts its calls
+type fakeEmbedder struct {
+	calls atomic.Int64
+}
+
+func (f *fakeEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	f.calls.Add(1)
+	return testVector(text), nil
+}
+
+func (f *fakeEmbedder) Ping(ctx context.Context) error { return nil }
+
+// testVector returns a db.Dimension vector seeded from text
+func testVector(text string) []float32 {
+	h := fnv.New64a()
+	h.Write([]byte(text))
+	rng := rand.New(rand.NewSource(int64(h.Sum64())))
+	v := make([]float32, db.Dimension)
+	for i := range v {
+		v[i] = rng.Float32()*2 - 1
+	}
+	return v
+}
+
+// newTestServer returns a Server on a fresh in-memory store that embeds with
+// embedder, or a fakeEmbedder if it is nil
+func newTestServer(t *testing.T, embedder Embedder) *Server {
+	t.Helper()
+	if embedder == nil {
+		embedder = &fakeEmbedder{}
+	}
+	store, err := db.Open(db.MemoryPath, "", "", 0)
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	cors, err := newCORSPolicy("", false, "GET, POST, OPTIONS")
+	if err != nil {
+		t.Fatalf("cors: %v", err)
+	}
+	return newServer(embedder, store, nil, nil, tsne.Runtime{}, nil, cors, cors)
+}
+
+// do sends a request with a JSON body, if any, and decodes the JSON response
+func do(t *testing.T, s *Server, method, target string, body interface{}) (int, map[string]interface{}) {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatalf("encode body: %v", err)
+		}
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(method, target, &buf))
+	var resp map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%s %s: decode response %q: %v", method, target, rec.Body.String(), err)
+	}
+	return rec.Code, resp
+}
+
+func TestEmbedRejectsBlankAndLongPrompts(t *testing.T) {
+	s := newTestServer(t, nil)
+	s.maxPromptLength = 10
+
+	tests := []struct {
+		name   string
+		prompt string
+		status int
+	}{
+		{"empty", "", http.StatusBadRequest},
+		{"whitespace only", " \t\n ", http.StatusBadRequest},
+		{"over the limit", strings.Repeat("x", 11), http.StatusRequestEntityTooLarge},
+		// The limit counts characters, not bytes
+		{"at the limit in multibyte characters", strings.Repeat("é", 10), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, resp := do(t, s, http.MethodPost, "/embed", map[string]string{"prompt": tt.prompt})
+			if status != tt.status {
+				t.Fatalf("status = %d, want %d (%v)", status, tt.status, resp)
+			}
+		})
+	}
+
+	count, err := s.store.GetPromptCount(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("stored %d prompts, want only the valid one", count)
+	}
+}
+
+func TestEmbedBatchChecksEachPrompt(t *testing.T) {
+	s := newTestServer(t, nil)
+	s.maxPromptLength = 10
+
+	prompts := []string{"fine", "   ", strings.Repeat("x", 11)}
+	_, resp := do(t, s, http.MethodPost, "/embed/batch", map[string]interface{}{"prompts": prompts})
+
+	results := resp["results"].([]interface{})
+	want := []string{"ok", "error", "error"}
+	for i, r := range results {
+		if status := r.(map[string]interface{})["status"]; status != want[i] {
+			t.Errorf("prompt %q: status %v, want %s", prompts[i], status, want[i])
+		}
+	}
+}
+
+func TestProjectBatch(t *testing.T) {
+	s := newTestServer(t, nil)
+
+	// Nothing is projected yet, so there is nowhere to place a text
+	_, resp := do(t, s, http.MethodPost, "/project/batch", map[string]interface{}{"prompts": []string{"alpha", " "}})
+	results := resp["projections"].([]interface{})
+	first, second := results[0].(map[string]interface{}), results[1].(map[string]interface{})
+	if x, ok := first["x"]; !ok || x != nil || first["error"] != nil {
+		t.Errorf("before any projection: got %v, want null x, y and z", first)
+	}
+	if second["error"] != "Prompt is required" {
+		t.Errorf("blank prompt: got %v, want a Prompt is required error", second)
+	}
+
+	for _, p := range []string{"alpha", "beta", "gamma"} {
+		if status, resp := do(t, s, http.MethodPost, "/embed", map[string]string{"prompt": p}); status != http.StatusOK {
+			t.Fatalf("embed %q: %d %v", p, status, resp)
+		}
+	}
+	if status, resp := do(t, s, http.MethodPost, "/tsne/compute?method=pca", nil); status != http.StatusOK {
+		t.Fatalf("compute: %d %v", status, resp)
+	}
+
+	_, resp = do(t, s, http.MethodPost, "/project/batch", map[string]interface{}{"prompts": []string{"beta"}})
+	result := resp["projections"].([]interface{})[0].(map[string]interface{})
+	if _, ok := result["x"].(float64); !ok {
+		t.Errorf("after projecting: got %v, want coordinates", result)
+	}
+
+	count, err := s.store.GetPromptCount(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("%d prompts stored, want the 3 embedded; projecting must not store any", count)
+	}
+}
+
+func TestBatchesAreBounded(t *testing.T) {
+	s := newTestServer(t, nil)
+	prompts := make([]string, maxBatchPrompts+1)
+	for i := range prompts {
+		prompts[i] = "p"
+	}
+	for _, target := range []string{"/embed/batch", "/project/batch"} {
+		if status, _ := do(t, s, http.MethodPost, target, map[string]interface{}{"prompts": prompts}); status != http.StatusBadRequest {
+			t.Errorf("%s with %d prompts: status %d, want 400", target, len(prompts), status)
+		}
+	}
+}
+
+// gatedEmbedder is a fakeEmbedder whose calls wait until release is closed
+type gatedEmbedder struct {
+	fakeEmbedder
+	release chan struct{}
+}
+
+func (g *gatedEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	<-g.release
+	return g.fakeEmbedder.Embed(ctx, text)
+}
+
+func TestConcurrentEmbedsOfOnePromptShareOneCall(t *testing.T) {
+	embedder := &gatedEmbedder{release: make(chan struct{})}
+	s := newTestServer(t, embedder)
+
+	// do can fail the test, which only the test goroutine may do, so the
+	// requests are served directly and their statuses checked afterwards
+	const requests = 8
+	var wg sync.WaitGroup
+	statuses := make([]int, requests)
+	for i := range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/embed", strings.NewReader(`{"prompt": "same text"}`)))
+			statuses[i] = rec.Code
+		}()
+	}
+	// Let every request reach the embedder before any call returns
+	time.Sleep(100 * time.Millisecond)
+	close(embedder.release)
+	wg.Wait()
+
+	for i, status := range statuses {
+		if status != http.StatusOK {
+			t.Errorf("request %d: status %d, want 200", i, status)
+		}
+	}
+	if calls := embedder.calls.Load(); calls != 1 {
+		t.Errorf("embedder called %d times, want 1", calls)
+	}
+	count, err := s.store.GetEmbeddingCount(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("stored %d embeddings, want 1", count)
+	}
+}
+
+func TestMissingModelIs424(t *testing.T) {
+	// Ollama's answer for a model that hasn't been pulled
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":"model \"llama3.2\" not found, try pulling it first"}`))
+	}))
+	defer ollamaServer.Close()
+	s := newTestServer(t, ollama.NewClient(ollamaServer.URL, "llama3.2"))
+
+	for _, target := range []string{"/embed", "/project"} {
+		status, resp := do(t, s, http.MethodPost, target, map[string]string{"prompt": "hello"})
+		if status != http.StatusFailedDependency {
+			t.Errorf("%s: status %d, want 424 (%v)", target, status, resp)
+		}
+		if msg, _ := resp["error"].(string); !strings.Contains(msg, "ollama pull llama3.2") {
+			t.Errorf("%s: error %q, want it to say how to pull the model", target, msg)
+		}
+	}
+}
+
+// shortEmbedder returns embeddings one value short of db.Dimension
+type shortEmbedder struct{ fakeEmbedder }
+
+func (s *shortEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	v, err := s.fakeEmbedder.Embed(ctx, text)
+	return v[1:], err
+}
+
+func TestEmbedDimensionMismatchIsAnError(t *testing.T) {
+	s := newTestServer(t, &shortEmbedder{})
+
+	status, resp := do(t, s, http.MethodPost, "/embed", map[string]string{"prompt": "hello"})
+	if status != http.StatusInternalServerError {
+		t.Errorf("status %d, want 500 (%v)", status, resp)
+	}
+	// Resubmitting must not find the prompt already embedded
+	status, resp = do(t, s, http.MethodPost, "/embed", map[string]string{"prompt": "hello"})
+	if status != http.StatusInternalServerError {
+		t.Errorf("resubmitted: status %d, want 500 (%v)", status, resp)
+	}
+}
+
+// failingEmbedder is a fakeEmbedder that fails for one text
+type failingEmbedder struct {
+	fakeEmbedder
+	fail string
+}
+
+func (f *failingEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	if text == f.fail {
+		return nil, errors.New("embedding failed")
+	}
+	return f.fakeEmbedder.Embed(ctx, text)
+}
+
+func TestAtomicEmbedBatchSummary(t *testing.T) {
+	s := newTestServer(t, &failingEmbedder{fail: "bad"})
+
+	status, resp := do(t, s, http.MethodPost, "/embed/batch?atomic=true", map[string]interface{}{"prompts": []string{"one", "two", "bad"}})
+	if status != http.StatusMultiStatus {
+		t.Fatalf("status %d, want 207 (%v)", status, resp)
+	}
+	summary := resp["summary"].(map[string]interface{})
+	want := map[string]interface{}{"total": 3.0, "succeeded": 0.0, "failed": 1.0, "rolled_back": 2.0, "committed": false}
+	for key, value := range want {
+		if summary[key] != value {
+			t.Errorf("summary %s = %v, want %v", key, summary[key], value)
+		}
+	}
+}
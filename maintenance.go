@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/tlehman/vecviz/db"
+)
+
+// GET /maintenance/gc - List embeddings and projections left without a prompt
+// POST /maintenance/gc - Remove them
+//
+// Orphans appear when prompts are deleted with plain SQL rather than through
+// the API, since nothing cascades to the embeddings table. Removing them also
+// drops the orphans' tags, metadata, queue entries, embedding metadata and
+// idempotency keys.
+func (s *Server) handleGC(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		embeddings, projections, err := s.store.FindOrphans(r.Context())
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to find orphans: "+err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"orphan_embeddings":  embeddings,
+			"orphan_projections": projections,
+		})
+	case http.MethodPost:
+		counts, err := s.store.RemoveOrphans()
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to remove orphans: "+err.Error())
+			return
+		}
+		if counts != (db.OrphanCounts{}) {
+			slog.Info("Removed orphaned rows", "embeddings", counts.Embeddings, "projections", counts.Projections, "other", counts.Other)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"embeddings_removed":  counts.Embeddings,
+			"projections_removed": counts.Projections,
+			"other_rows_removed":  counts.Other,
+		})
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// POST /reset - Delete every prompt, embedding and projection
+//
+// For demos and tests: everything goes in one transaction and prompt IDs
+// start again from 1, while the database's settings are kept. Disabled, with
+// 403, unless vecviz runs with -allow-reset or VECVIZ_ALLOW_RESET=true, and
+// like other writes it needs VECVIZ_API_KEY when that is set.
+func (s *Server) handleReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !s.allowReset {
+		writeJSONError(w, http.StatusForbidden, "Reset is disabled; start vecviz with -allow-reset or VECVIZ_ALLOW_RESET=true")
+		return
+	}
+
+	counts, err := s.store.Reset()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to reset: "+err.Error())
+		return
+	}
+	slog.Warn("Reset the database", "prompts", counts.Prompts, "embeddings", counts.Embeddings, "projections", counts.Projections)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"prompts_removed":     counts.Prompts,
+		"embeddings_removed":  counts.Embeddings,
+		"projections_removed": counts.Projections,
+	})
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tlehman/vecviz/db"
+	"github.com/tlehman/vecviz/ollama"
+	"github.com/tlehman/vecviz/vecmath"
+)
+
+// Ways multiModelEmbedder merges its models' vectors, chosen by VECVIZ_COMBINE
+const (
+	// combineMean averages the vectors, so every model must produce db.Dimension values
+	combineMean = "mean"
+	// combineConcat places the vectors end to end, so the models' dimensions
+	// must add up to db.Dimension
+	combineConcat = "concat"
+)
+
+// modelPart is one of a multiModelEmbedder's models
+type modelPart struct {
+	Embedder
+	model  string
+	weight float64
+}
+
+// multiModelEmbedder embeds text with each of several models in turn and
+// merges their vectors into one. Each vector is scaled to unit length and
+// then by its model's weight, so a model with larger raw values doesn't
+// dominate. The merged vector's length is fixed by the strategy and must be
+// db.Dimension, as with a single model.
+type multiModelEmbedder struct {
+	parts    []modelPart
+	strategy string
+}
+
+// newMultiModelEmbedder parses VECVIZ_MODELS, comma-separated models each
+// optionally followed by =weight (1 by default), e.g.
+// "llama3.2=2,nomic-embed-text". strategy is combineMean, the default if
+// empty, or combineConcat. newClient builds each model's client.
+func newMultiModelEmbedder(models, strategy string, newClient func(model string, expectedDim int) Embedder) (*multiModelEmbedder, error) {
+	if strategy == "" {
+		strategy = combineMean
+	}
+	// Under mean every vector must already have the database's length, so
+	// clients that check as they answer can; under concat only the total can
+	expectedDim := 0
+	switch strategy {
+	case combineMean:
+		expectedDim = db.Dimension
+	case combineConcat:
+	default:
+		return nil, fmt.Errorf("unknown VECVIZ_COMBINE %q, expected %s or %s", strategy, combineMean, combineConcat)
+	}
+
+	m := &multiModelEmbedder{strategy: strategy}
+	for _, entry := range strings.Split(models, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		// Ollama model names may contain ':' (e.g. nomic-embed-text:v1.5), so
+		// the weight follows '='
+		model, weightText, hasWeight := strings.Cut(entry, "=")
+		weight := 1.0
+		if hasWeight {
+			var err error
+			weight, err = strconv.ParseFloat(weightText, 64)
+			if err != nil || weight <= 0 {
+				return nil, fmt.Errorf("invalid weight %q for model %s in VECVIZ_MODELS, expected a positive number", weightText, model)
+			}
+		}
+		m.parts = append(m.parts, modelPart{Embedder: newClient(model, expectedDim), model: model, weight: weight})
+	}
+	if len(m.parts) < 2 {
+		return nil, fmt.Errorf("VECVIZ_MODELS needs at least two models, got %q; use VECVIZ_MODEL for one", models)
+	}
+	return m, nil
+}
+
+// Model describes the models and how they're merged, e.g.
+// "mean(llama3.2=2,nomic-embed-text)", and is what embeddings record as their model
+func (m *multiModelEmbedder) Model() string {
+	names := make([]string, len(m.parts))
+	for i, p := range m.parts {
+		names[i] = p.model
+		if p.weight != 1 {
+			names[i] += "=" + strconv.FormatFloat(p.weight, 'g', -1, 64)
+		}
+	}
+	return m.strategy + "(" + strings.Join(names, ",") + ")"
+}
+
+func (m *multiModelEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	result, err := m.EmbedResult(ctx, text)
+	if err != nil {
+		return nil, err
+	}
+	return result.Embedding, nil
+}
+
+// EmbedResult merges the models' vectors, reporting their total cost
+func (m *multiModelEmbedder) EmbedResult(ctx context.Context, text string) (*ollama.EmbeddingResult, error) {
+	merged := &ollama.EmbeddingResult{}
+	vectors := make([][]float32, len(m.parts))
+	total := 0
+	for i, p := range m.parts {
+		result, err := embedResult(ctx, p.Embedder, text)
+		if err != nil {
+			return nil, fmt.Errorf("model %s: %w", p.model, err)
+		}
+		merged.TotalDuration += result.TotalDuration
+		merged.LoadDuration += result.LoadDuration
+		merged.PromptEvalCount += result.PromptEvalCount
+		vectors[i] = vecmath.Normalize(result.Embedding)
+		total += len(result.Embedding)
+	}
+
+	switch m.strategy {
+	case combineConcat:
+		if total != db.Dimension {
+			return nil, fmt.Errorf("models %s concatenate to dimension %d, but the database stores %d", m.Model(), total, db.Dimension)
+		}
+		merged.Embedding = make([]float32, 0, total)
+		for i, v := range vectors {
+			for _, x := range v {
+				merged.Embedding = append(merged.Embedding, float32(float64(x)*m.parts[i].weight))
+			}
+		}
+	default:
+		var weights float64
+		sum := make([]float64, db.Dimension)
+		for i, v := range vectors {
+			if len(v) != db.Dimension {
+				return nil, fmt.Errorf("model %s returned dimension %d, but averaging needs every model to produce the database's %d", m.parts[i].model, len(v), db.Dimension)
+			}
+			for j, x := range v {
+				sum[j] += float64(x) * m.parts[i].weight
+			}
+			weights += m.parts[i].weight
+		}
+		merged.Embedding = make([]float32, db.Dimension)
+		for j, x := range sum {
+			merged.Embedding[j] = float32(x / weights)
+		}
+	}
+	return merged, nil
+}
+
+// Ping checks every model's backend
+func (m *multiModelEmbedder) Ping(ctx context.Context) error {
+	for _, p := range m.parts {
+		if err := p.Ping(ctx); err != nil {
+			return fmt.Errorf("model %s: %w", p.model, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/tlehman/vecviz/db"
+)
+
+// GET /points/{id}/neighbors?k=10 - Nearest prompts to a stored prompt
+//
+// Distances use the database's metric in the original embedding space, not the projection, so
+// this shows whether a cluster in the layout reflects real neighbors. The
+// prompt itself is not included. Neighbors come from the graph POST
+// /graph/build stores when it covers k, and from a live KNN query otherwise;
+// "source" says which. Each neighbor also has its cosine "similarity" in
+// [-1, 1], computed from the two stored vectors.
+func (s *Server) handlePointNeighbors(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid prompt id")
+		return
+	}
+
+	k, err := s.parseK(r.URL.Query().Get("k"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	embedding, err := s.store.GetEmbedding(r.Context(), id)
+	if errors.Is(err, db.ErrEmbeddingNotFound) {
+		writeJSONError(w, http.StatusNotFound, "Embedding not found")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to get embedding: "+err.Error())
+		return
+	}
+
+	source := "graph"
+	matches, ok, err := s.store.GraphNeighbors(r.Context(), id, k)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to read neighbor graph: "+err.Error())
+		return
+	}
+	if !ok {
+		source = "live"
+		// The prompt is its own nearest match, so ask for one extra
+		matches, err = s.store.SearchNearest(r.Context(), embedding, k+1)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Search failed: "+err.Error())
+			return
+		}
+	}
+
+	similarities, err := s.cosineSimilarities(r.Context(), embedding, matches)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to compute similarities: "+err.Error())
+		return
+	}
+
+	neighbors := []map[string]interface{}{}
+	for _, m := range matches {
+		if m.PromptID == id || len(neighbors) == k {
+			continue
+		}
+		neighbors = append(neighbors, map[string]interface{}{
+			"id":         m.PromptID,
+			"text":       m.Text,
+			"distance":   m.Distance,
+			"similarity": similarityOrNil(similarities, m.PromptID),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":        id,
+		"metric":    s.store.Metric,
+		"source":    source,
+		"neighbors": neighbors,
+	})
+}
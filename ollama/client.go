@@ -2,69 +2,218 @@ package ollama
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"strings"
+	"time"
 )
 
 const (
 	DefaultBaseURL = "http://localhost:11434"
-	Model          = "llama3.2"
+	// DefaultModel produces the 3072-dimensional vectors the embeddings table expects
+	DefaultModel      = "llama3.2"
+	DefaultMaxRetries = 3
+	DefaultRetryDelay = 500 * time.Millisecond
+	// DefaultTimeout bounds a whole request, including loading the model on first use
+	DefaultTimeout = 60 * time.Second
+	// DefaultConnectTimeout bounds connecting, so a dead server fails fast
+	// even though a loading model is allowed the full DefaultTimeout
+	DefaultConnectTimeout = 5 * time.Second
 )
 
+// ErrModelNotFound is returned when Ollama doesn't have the model, which
+// usually means it hasn't been pulled
+var ErrModelNotFound = errors.New("model not found")
+
 type Client struct {
 	baseURL string
 	http    *http.Client
+
+	// Model is the Ollama model used to generate embeddings
+	Model string
+
+	// MaxRetries is how many times a transient failure (connection error or 5xx) is retried
+	MaxRetries int
+	// RetryDelay is the base delay before the first retry; it doubles on each attempt
+	RetryDelay time.Duration
+
+	// ExpectedDim, if non-zero, is the length every embedding must have. A
+	// mismatch (e.g. Model is a chat model) fails the call straight away.
+	ExpectedDim int
+}
+
+// Option configures a Client in NewClient
+type Option func(*clientOptions)
+
+type clientOptions struct {
+	timeout        time.Duration
+	connectTimeout time.Duration
 }
 
-func NewClient(baseURL string) *Client {
+// WithTimeout sets how long a single request may take end to end. Raise it
+// for large models that take a while to load on first use.
+func WithTimeout(d time.Duration) Option {
+	return func(o *clientOptions) { o.timeout = d }
+}
+
+// WithConnectTimeout sets how long establishing a connection may take. A
+// server that is down or unreachable fails within this, regardless of WithTimeout.
+func WithConnectTimeout(d time.Duration) Option {
+	return func(o *clientOptions) { o.connectTimeout = d }
+}
+
+func NewClient(baseURL, model string, opts ...Option) *Client {
 	if baseURL == "" {
 		baseURL = DefaultBaseURL
 	}
+	if model == "" {
+		model = DefaultModel
+	}
+
+	o := clientOptions{timeout: DefaultTimeout, connectTimeout: DefaultConnectTimeout}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: o.connectTimeout}).DialContext
+
 	return &Client{
-		baseURL: baseURL,
-		http:    &http.Client{},
+		baseURL:    baseURL,
+		http:       &http.Client{Timeout: o.timeout, Transport: transport},
+		Model:      model,
+		MaxRetries: DefaultMaxRetries,
+		RetryDelay: DefaultRetryDelay,
 	}
 }
 
+// WithBaseURL returns a copy of c that calls the Ollama server at baseURL.
+// The copy shares c's connection pool and settings.
+func (c *Client) WithBaseURL(baseURL string) *Client {
+	copied := *c
+	copied.baseURL = baseURL
+	return &copied
+}
+
 type embedRequest struct {
 	Model string `json:"model"`
 	Input string `json:"input"`
+	// Stream is always false; some proxies stream unless told otherwise
+	Stream bool `json:"stream"`
 }
 
 type embedResponse struct {
 	Embeddings [][]float64 `json:"embeddings"`
+	// Durations are in nanoseconds
+	TotalDuration   int64 `json:"total_duration"`
+	LoadDuration    int64 `json:"load_duration"`
+	PromptEvalCount int   `json:"prompt_eval_count"`
+}
+
+// EmbeddingResult is an embedding along with what Ollama reports it cost.
+// Servers that don't report a field leave it zero.
+type EmbeddingResult struct {
+	Embedding []float32
+	// TotalDuration is Ollama's time for the whole request, LoadDuration included
+	TotalDuration time.Duration
+	// LoadDuration is the time spent loading the model, near zero once it's loaded
+	LoadDuration time.Duration
+	// PromptEvalCount is the number of tokens in the input
+	PromptEvalCount int
 }
 
 // GetEmbedding calls the Ollama embed API and returns the embedding vector
 func (c *Client) GetEmbedding(text string) ([]float32, error) {
+	return c.GetEmbeddingContext(context.Background(), text)
+}
+
+// GetEmbeddingContext is like GetEmbedding but gives up when ctx is canceled.
+// Transient failures are retried with exponential backoff.
+func (c *Client) GetEmbeddingContext(ctx context.Context, text string) ([]float32, error) {
+	result, err := c.GetEmbeddingResult(ctx, text)
+	if err != nil {
+		return nil, err
+	}
+	return result.Embedding, nil
+}
+
+// GetEmbeddingResult is like GetEmbeddingContext but also returns the
+// timing and token count Ollama reports. They cover the final attempt only.
+func (c *Client) GetEmbeddingResult(ctx context.Context, text string) (*EmbeddingResult, error) {
+	delay := c.RetryDelay
+	for attempt := 0; ; attempt++ {
+		result, retryable, err := c.embed(ctx, text)
+		if err == nil {
+			return result, nil
+		}
+		if !retryable || attempt >= c.MaxRetries {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w (last error: %v)", ctx.Err(), err)
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// embed makes a single embed API call. retryable reports whether a failure is transient.
+func (c *Client) embed(ctx context.Context, text string) (result *EmbeddingResult, retryable bool, err error) {
 	reqBody := embedRequest{
-		Model: Model,
+		Model: c.Model,
 		Input: text,
 	}
 
 	jsonBody, err := json.Marshal(reqBody)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
+		return nil, false, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	resp, err := c.http.Post(c.baseURL+"/api/embed", "application/json", bytes.NewReader(jsonBody))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/api/embed", bytes.NewReader(jsonBody))
 	if err != nil {
-		return nil, fmt.Errorf("failed to call ollama: %w", err)
+		return nil, false, fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		// Canceled requests are not worth retrying
+		return nil, ctx.Err() == nil, fmt.Errorf("failed to call ollama: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("ollama returned status %d", resp.StatusCode)
+		return nil, resp.StatusCode >= 500, c.statusError(resp)
 	}
 
+	// Standard Ollama sends a single object, but a proxy that streams anyway
+	// sends a sequence of them; keep the last one carrying embeddings
 	var embedResp embedResponse
-	if err := json.NewDecoder(resp.Body).Decode(&embedResp); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var chunk embedResponse
+		if err := dec.Decode(&chunk); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, false, fmt.Errorf("failed to decode response: %w", err)
+		}
+		if len(chunk.Embeddings) > 0 {
+			embedResp = chunk
+		}
 	}
 
 	if len(embedResp.Embeddings) == 0 {
-		return nil, fmt.Errorf("no embeddings returned")
+		return nil, false, fmt.Errorf("no embeddings returned")
+	}
+
+	if c.ExpectedDim != 0 && len(embedResp.Embeddings[0]) != c.ExpectedDim {
+		return nil, false, fmt.Errorf("model %s returned an embedding of dimension %d, expected %d; is it an embedding model?", c.Model, len(embedResp.Embeddings[0]), c.ExpectedDim)
 	}
 
 	// Convert float64 to float32
@@ -73,5 +222,58 @@ func (c *Client) GetEmbedding(text string) ([]float32, error) {
 		embedding[i] = float32(v)
 	}
 
-	return embedding, nil
+	return &EmbeddingResult{
+		Embedding:       embedding,
+		TotalDuration:   time.Duration(embedResp.TotalDuration),
+		LoadDuration:    time.Duration(embedResp.LoadDuration),
+		PromptEvalCount: embedResp.PromptEvalCount,
+	}, false, nil
+}
+
+// statusError describes a response other than 200 OK, including the message
+// from Ollama's {"error": "..."} body if there is one. A 404 saying the model
+// was not found is ErrModelNotFound; a bare 404 is more likely a server too
+// old to have /api/embed, or a proxy in the way.
+func (c *Client) statusError(resp *http.Response) error {
+	var body struct {
+		Error string `json:"error"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body)
+	if body.Error == "" {
+		return fmt.Errorf("ollama returned status %d", resp.StatusCode)
+	}
+	if resp.StatusCode == http.StatusNotFound && strings.Contains(body.Error, "not found") {
+		return fmt.Errorf("%w: ollama has no model %s; pull it with \"ollama pull %s\"", ErrModelNotFound, c.Model, c.Model)
+	}
+	return fmt.Errorf("ollama returned status %d: %s", resp.StatusCode, body.Error)
+}
+
+// Ping checks that Ollama is reachable by fetching its version
+func (c *Client) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/api/version", nil)
+	if err != nil {
+		return fmt.Errorf("failed to build request: %w", err)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call ollama: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("ollama returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Embed is GetEmbeddingContext under the name other embedding backends share
+func (c *Client) Embed(ctx context.Context, text string) ([]float32, error) {
+	return c.GetEmbeddingContext(ctx, text)
+}
+
+// EmbedResult is GetEmbeddingResult under a name matching Embed
+func (c *Client) EmbedResult(ctx context.Context, text string) (*EmbeddingResult, error) {
+	return c.GetEmbeddingResult(ctx, text)
 }
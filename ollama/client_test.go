@@ -0,0 +1,88 @@
+package ollama
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// errorServer answers every request with status and body
+func errorServer(t *testing.T, status int, body string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestMissingModelIsErrModelNotFound(t *testing.T) {
+	// What Ollama sends for a model that hasn't been pulled
+	srv := errorServer(t, http.StatusNotFound, `{"error":"model \"llama3.2\" not found, try pulling it first"}`)
+	client := NewClient(srv.URL, "llama3.2")
+
+	_, err := client.Embed(context.Background(), "hello")
+	if !errors.Is(err, ErrModelNotFound) {
+		t.Fatalf("err = %v, want ErrModelNotFound", err)
+	}
+	if !strings.Contains(err.Error(), "ollama pull llama3.2") {
+		t.Errorf("err = %q, want it to say how to pull the model", err)
+	}
+}
+
+func TestOtherErrorsAreNotErrModelNotFound(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   string
+	}{
+		// A bare 404 is more likely a proxy or a server without /api/embed
+		{"unknown route", http.StatusNotFound, "404 page not found", "status 404"},
+		{"server error", http.StatusInternalServerError, `{"error":"out of memory"}`, "out of memory"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewClient(errorServer(t, tt.status, tt.body).URL, "")
+			client.MaxRetries = 0
+
+			_, err := client.Embed(context.Background(), "hello")
+			if err == nil || errors.Is(err, ErrModelNotFound) {
+				t.Fatalf("err = %v, want an error other than ErrModelNotFound", err)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %q, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientGivesUpOnSlowServer(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	// Close waits for the handler, so let it go first
+	defer srv.Close()
+	defer close(release)
+
+	client := NewClient(srv.URL, "", WithTimeout(100*time.Millisecond))
+	client.MaxRetries = 0
+
+	start := time.Now()
+	_, err := client.Embed(context.Background(), "hello")
+	if err == nil {
+		t.Fatal("Embed succeeded against a server that never answers")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("gave up after %s, want about the 100ms timeout", elapsed)
+	}
+}
@@ -0,0 +1,155 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	DefaultBaseURL = "https://api.openai.com"
+	// DefaultModel produces 3072-dimensional vectors, matching the embeddings table
+	DefaultModel      = "text-embedding-3-large"
+	DefaultMaxRetries = 3
+	DefaultRetryDelay = 500 * time.Millisecond
+)
+
+// Client calls an OpenAI-compatible /v1/embeddings API
+type Client struct {
+	baseURL string
+	apiKey  string
+	http    *http.Client
+
+	// Model is the embedding model to request
+	Model string
+
+	// MaxRetries is how many times a transient failure (connection error, 429 or 5xx) is retried
+	MaxRetries int
+	// RetryDelay is the base delay before the first retry; it doubles on each attempt
+	RetryDelay time.Duration
+}
+
+// NewClient returns a client for the API at baseURL, authenticating with
+// apiKey as a bearer token if it is not empty
+func NewClient(baseURL, model, apiKey string) *Client {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	if model == "" {
+		model = DefaultModel
+	}
+	return &Client{
+		baseURL:    baseURL,
+		apiKey:     apiKey,
+		http:       &http.Client{},
+		Model:      model,
+		MaxRetries: DefaultMaxRetries,
+		RetryDelay: DefaultRetryDelay,
+	}
+}
+
+type embeddingsRequest struct {
+	Model string `json:"model"`
+	Input string `json:"input"`
+}
+
+type embeddingsResponse struct {
+	Data []struct {
+		Embedding []float64 `json:"embedding"`
+	} `json:"data"`
+}
+
+// Embed returns the embedding of text, retrying transient failures with
+// exponential backoff until ctx is canceled
+func (c *Client) Embed(ctx context.Context, text string) ([]float32, error) {
+	delay := c.RetryDelay
+	for attempt := 0; ; attempt++ {
+		embedding, retryable, err := c.embed(ctx, text)
+		if err == nil {
+			return embedding, nil
+		}
+		if !retryable || attempt >= c.MaxRetries {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w (last error: %v)", ctx.Err(), err)
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// embed makes a single embeddings API call. retryable reports whether a failure is transient.
+func (c *Client) embed(ctx context.Context, text string) (embedding []float32, retryable bool, err error) {
+	jsonBody, err := json.Marshal(embeddingsRequest{Model: c.Model, Input: text})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/v1/embeddings", bytes.NewReader(jsonBody))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	c.authorize(req)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		// Canceled requests are not worth retrying
+		return nil, ctx.Err() == nil, fmt.Errorf("failed to call embeddings API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return nil, retryable, fmt.Errorf("embeddings API returned status %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+
+	var embedResp embeddingsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&embedResp); err != nil {
+		return nil, false, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if len(embedResp.Data) == 0 {
+		return nil, false, fmt.Errorf("no embeddings returned")
+	}
+
+	embedding = make([]float32, len(embedResp.Data[0].Embedding))
+	for i, v := range embedResp.Data[0].Embedding {
+		embedding[i] = float32(v)
+	}
+	return embedding, false, nil
+}
+
+// Ping checks that the API is reachable and accepts the key by listing models
+func (c *Client) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/v1/models", nil)
+	if err != nil {
+		return fmt.Errorf("failed to build request: %w", err)
+	}
+	c.authorize(req)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call embeddings API: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("embeddings API returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (c *Client) authorize(req *http.Request) {
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+}
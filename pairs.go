@@ -0,0 +1,133 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/tlehman/vecviz/vecmath"
+)
+
+const (
+	// defaultTopPairs is the number of pairs /pairs/top returns when n is not given
+	defaultTopPairs = 20
+	// maxTopPairs bounds n for /pairs/top
+	maxTopPairs = 100
+	// maxPairsPerPointK bounds how many neighbors are fetched per point
+	maxPairsPerPointK = 10
+)
+
+// promptPair is two distinct prompts and the distance between their embeddings
+type promptPair struct {
+	a, b     int64
+	distance float64
+}
+
+// pairHeap is a max-heap on distance, so the farthest kept pair is evicted first
+type pairHeap []promptPair
+
+func (h pairHeap) Len() int           { return len(h) }
+func (h pairHeap) Less(i, j int) bool { return h[i].distance > h[j].distance }
+func (h pairHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *pairHeap) Push(x any)        { *h = append(*h, x.(promptPair)) }
+func (h *pairHeap) Pop() any {
+	old := *h
+	p := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return p
+}
+
+// GET /pairs/top?n=20 - Find the most similar distinct prompt pairs
+//
+// Each point contributes only its k = min(n, 10) nearest neighbors, so the
+// result is exact for n <= 10 and approximate beyond that: a pair is missed
+// if both points have k closer neighbors of their own. The cost is one KNN
+// scan per stored embedding.
+func (s *Server) handleTopPairs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	n := defaultTopPairs
+	if v := r.URL.Query().Get("n"); v != "" {
+		var err error
+		n, err = strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxTopPairs {
+			writeJSONError(w, http.StatusBadRequest, "n must be between 1 and "+strconv.Itoa(maxTopPairs))
+			return
+		}
+	}
+	k := min(n, maxPairsPerPointK)
+
+	embeddings, err := s.store.GetAllEmbeddings(r.Context())
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to get embeddings: "+err.Error())
+		return
+	}
+
+	vectors := make(map[int64][]float32, len(embeddings))
+	for _, e := range embeddings {
+		vectors[e.PromptID] = e.Vector
+	}
+
+	h := &pairHeap{}
+	seen := make(map[[2]int64]bool)
+	for _, e := range embeddings {
+		// Ask for one extra neighbor since the point matches itself
+		neighbors, err := s.store.SearchNearest(r.Context(), e.Vector, k+1)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Search failed: "+err.Error())
+			return
+		}
+
+		for _, nb := range neighbors {
+			if nb.PromptID == e.PromptID {
+				continue
+			}
+			key := [2]int64{min(e.PromptID, nb.PromptID), max(e.PromptID, nb.PromptID)}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			if h.Len() < n {
+				heap.Push(h, promptPair{a: key[0], b: key[1], distance: nb.Distance})
+			} else if nb.Distance < (*h)[0].distance {
+				(*h)[0] = promptPair{a: key[0], b: key[1], distance: nb.Distance}
+				heap.Fix(h, 0)
+			}
+		}
+	}
+
+	// Drain the heap farthest-first to produce a closest-first list
+	pairs := make([]map[string]interface{}, h.Len())
+	for i := len(pairs) - 1; i >= 0; i-- {
+		p := heap.Pop(h).(promptPair)
+
+		textA, err := s.store.GetPromptText(r.Context(), p.a)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to get prompt: "+err.Error())
+			return
+		}
+		textB, err := s.store.GetPromptText(r.Context(), p.b)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to get prompt: "+err.Error())
+			return
+		}
+
+		pairs[i] = map[string]interface{}{
+			"a":          map[string]interface{}{"id": p.a, "text": textA},
+			"b":          map[string]interface{}{"id": p.b, "text": textB},
+			"distance":   p.distance,
+			"similarity": vecmath.Cosine(vectors[p.a], vectors[p.b]),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"pairs":       pairs,
+		"per_point_k": k,
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/tlehman/vecviz/db"
+)
+
+const (
+	// defaultCoordPrecision is how many decimals /points keeps in coordinates
+	// unless VECVIZ_COORD_PRECISION or ?precision= says otherwise. Layouts
+	// span [-1, 1], so 4 decimals resolves 1/20000 of the scene, far finer
+	// than a plot can show, where full precision spends 17 digits.
+	defaultCoordPrecision = 4
+	// maxCoordPrecision is the most decimals worth asking for; float64 holds
+	// no more past it
+	maxCoordPrecision = 15
+	// fullPrecision leaves coordinates as stored, for "full"
+	fullPrecision = -1
+)
+
+// parseCoordPrecision reads a precision setting: a number of decimals from 1
+// to maxCoordPrecision, or "full" for fullPrecision
+func parseCoordPrecision(s string) (int, error) {
+	if s == "full" {
+		return fullPrecision, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || n > maxCoordPrecision {
+		return 0, fmt.Errorf("precision must be 1 to %d decimals or full", maxCoordPrecision)
+	}
+	return n, nil
+}
+
+// roundTo rounds v to decimals places, or returns it as is for fullPrecision
+func roundTo(v float64, decimals int) float64 {
+	if decimals == fullPrecision {
+		return v
+	}
+	scale := math.Pow(10, float64(decimals))
+	// Adding 0 turns the -0 that tiny negatives round to into 0
+	return math.Round(v*scale)/scale + 0
+}
+
+// roundProjections rounds each projection's coordinates to decimals places
+// in place. Coords is copied first, as it may be shared.
+func roundProjections(projections []db.Projection, decimals int) {
+	if decimals == fullPrecision {
+		return
+	}
+	round := func(v float64) float64 { return roundTo(v, decimals) }
+	for i := range projections {
+		p := &projections[i]
+		p.X, p.Y, p.Z = round(p.X), round(p.Y), round(p.Z)
+		if p.Coords != nil {
+			coords := make([]float64, len(p.Coords))
+			for j, v := range p.Coords {
+				coords[j] = round(v)
+			}
+			p.Coords = coords
+		}
+	}
+}
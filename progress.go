@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// progressEvent is one event sent to /tsne/progress or /ws subscribers
+type progressEvent struct {
+	Name string
+	Data interface{}
+}
+
+// progressHub fans events out to streaming subscribers: projection progress
+// to /tsne/progress, and new points and layouts to /ws
+type progressHub struct {
+	mu     sync.Mutex
+	subs   map[chan progressEvent]struct{}
+	closed bool
+}
+
+// newProgressHub returns a hub with no subscribers
+func newProgressHub() *progressHub {
+	return &progressHub{subs: make(map[chan progressEvent]struct{})}
+}
+
+// subscribe returns a channel receiving every event published from now on.
+// It is closed by unsubscribe or when the hub shuts down.
+func (h *progressHub) subscribe() chan progressEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ch := make(chan progressEvent, 16)
+	if h.closed {
+		close(ch)
+		return ch
+	}
+	h.subs[ch] = struct{}{}
+	return ch
+}
+
+func (h *progressHub) unsubscribe(ch chan progressEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[ch]; ok {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+// active reports whether anyone is subscribed, so publishers can skip
+// building events nobody would receive
+func (h *progressHub) active() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs) > 0
+}
+
+// publish sends an event to every subscriber. Subscribers that have fallen
+// behind miss it rather than stalling the run.
+func (h *progressHub) publish(name string, data interface{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- progressEvent{Name: name, Data: data}:
+		default:
+		}
+	}
+}
+
+// close ends every subscription so open streams don't hold up shutdown
+func (h *progressHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for ch := range h.subs {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+// GET /tsne/progress - Stream projection progress as Server-Sent Events
+//
+// Every run sends "start" ({"method", "points"}) and then "done"
+// ({"points_processed"}), "error" ({"error"}) or, when stopped by POST
+// /tsne/cancel, "cancelled" ({}). t-SNE runs also send
+// "progress" ({"iteration", "iterations", "kl_divergence"}) as sklearn
+// reports iterations; other reducers, or a script that reports nothing,
+// only send start and done.
+func (s *Server) handleTSNEProgress(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, "Streaming not supported")
+		return
+	}
+
+	events := s.progress.subscribe()
+	defer s.progress.unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(event.Data)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Name, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
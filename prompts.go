@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tlehman/vecviz/db"
+)
+
+const (
+	// defaultPromptsLimit is the page size for /prompts when limit is not given
+	defaultPromptsLimit = 100
+	// maxPromptsLimit bounds the page size for /prompts
+	maxPromptsLimit = 1000
+)
+
+// GET /prompts?limit=100&offset=0&include_deleted=true - List stored prompts with pipeline status.
+// Soft-deleted prompts are left out unless include_deleted is set.
+// Offsets shift when prompts are added or deleted between pages, so clients
+// walking the whole list should instead pass ?cursor= with the previous
+// page's "next_cursor", starting with cursor=0; it is null once a page comes
+// back short.
+func (s *Server) handleListPrompts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	limit := defaultPromptsLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPromptsLimit {
+			writeJSONError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxPromptsLimit))
+			return
+		}
+	}
+
+	offset := 0
+	if v := r.URL.Query().Get("offset"); v != "" {
+		var err error
+		offset, err = strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			writeJSONError(w, http.StatusBadRequest, "Invalid offset")
+			return
+		}
+	}
+
+	var cursor int64
+	cursorParam := r.URL.Query().Get("cursor")
+	if cursorParam != "" {
+		var err error
+		cursor, err = strconv.ParseInt(cursorParam, 10, 64)
+		if err != nil || cursor < 0 {
+			writeJSONError(w, http.StatusBadRequest, "Invalid cursor")
+			return
+		}
+		if offset != 0 {
+			writeJSONError(w, http.StatusBadRequest, "cursor and offset can't be used together")
+			return
+		}
+	}
+
+	includeDeleted := r.URL.Query().Get("include_deleted") == "true"
+
+	var prompts []db.PromptInfo
+	var err error
+	if cursorParam != "" {
+		prompts, err = s.store.ListPromptsAfter(r.Context(), cursor, limit, includeDeleted)
+	} else {
+		prompts, err = s.store.ListPrompts(r.Context(), limit, offset, includeDeleted)
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to list prompts: "+err.Error())
+		return
+	}
+
+	total, err := s.store.GetPromptCount(r.Context(), includeDeleted)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to count prompts: "+err.Error())
+		return
+	}
+
+	results := make([]map[string]interface{}, len(prompts))
+	for i, p := range prompts {
+		results[i] = map[string]interface{}{
+			"id":             p.ID,
+			"text":           p.Text,
+			"created_at":     p.CreatedAt.UTC().Format(time.RFC3339),
+			"has_embedding":  p.HasEmbedding,
+			"has_projection": p.HasProjection,
+		}
+		if includeDeleted {
+			results[i]["deleted_at"] = formatDeletedAt(p.DeletedAt)
+		}
+	}
+
+	// A short page is the last one
+	var nextCursor interface{}
+	if len(prompts) == limit {
+		nextCursor = prompts[len(prompts)-1].ID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"prompts":     results,
+		"total":       total,
+		"limit":       limit,
+		"offset":      offset,
+		"next_cursor": nextCursor,
+	})
+}
+
+// /prompts/{id} - Dispatch single-prompt requests by method
+func (s *Server) handlePrompt(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodDelete:
+		s.handleDeletePrompt(w, r)
+	case http.MethodPatch:
+		s.handleUpdatePrompt(w, r)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// GET /prompts/lookup?text=...&include_deleted=true - Find a prompt by its text
+//
+// The text is compared as /embed compares prompts for duplicates, so with
+// VECVIZ_NORMALIZE_PROMPTS the stored text may differ from the one asked for.
+// The response says whether the prompt has an embedding and gives its
+// projection, or null. An unknown prompt is a 404, as is a soft-deleted one
+// unless include_deleted is set.
+func (s *Server) handleLookupPrompt(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	text := r.URL.Query().Get("text")
+	if text == "" {
+		writeJSONError(w, http.StatusBadRequest, "text is required")
+		return
+	}
+	includeDeleted := r.URL.Query().Get("include_deleted") == "true"
+
+	prompt, projection, err := s.store.LookupPrompt(r.Context(), text, includeDeleted)
+	if errors.Is(err, db.ErrPromptNotFound) {
+		writeJSONError(w, http.StatusNotFound, "Prompt not found")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to look up prompt: "+err.Error())
+		return
+	}
+
+	resp := map[string]interface{}{
+		"id":            prompt.ID,
+		"text":          prompt.Text,
+		"created_at":    prompt.CreatedAt.UTC().Format(time.RFC3339),
+		"has_embedding": prompt.HasEmbedding,
+		"projection":    nil,
+	}
+	if projection != nil {
+		resp["projection"] = map[string]interface{}{"x": projection.X, "y": projection.Y, "z": projection.Z}
+	}
+	if includeDeleted {
+		resp["deleted_at"] = formatDeletedAt(prompt.DeletedAt)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// POST /prompts/delete - Delete several prompts at once
+//
+// {"ids": [...]} or {"tag": "..."} picks the prompts, which are removed with
+// their embeddings and projections in one transaction, as DELETE
+// /prompts/{id} removes one. "deleted" counts them; unknown IDs are skipped.
+func (s *Server) handleDeletePrompts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req struct {
+		IDs []int64 `json:"ids"`
+		Tag string  `json:"tag"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	var deleted int
+	var err error
+	switch {
+	case req.IDs != nil && req.Tag != "":
+		writeJSONError(w, http.StatusBadRequest, "Give either ids or tag, not both")
+		return
+	case len(req.IDs) > 0:
+		deleted, err = s.store.DeletePrompts(req.IDs)
+	case req.Tag != "":
+		deleted, err = s.store.DeletePromptsWithTag(req.Tag)
+	default:
+		writeJSONError(w, http.StatusBadRequest, "ids or tag is required")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to delete prompts: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"deleted": deleted})
+}
+
+// POST /prompts/{id}/restore - Bring back a soft-deleted prompt
+func (s *Server) handleRestorePrompt(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid prompt id")
+		return
+	}
+
+	err = s.store.Restore(id)
+	if errors.Is(err, db.ErrPromptNotFound) {
+		writeJSONError(w, http.StatusNotFound, "Prompt not found")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to restore prompt: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":       id,
+		"restored": true,
+	})
+}
+
+// formatDeletedAt renders a deletion time for JSON, or nil for a live prompt
+func formatDeletedAt(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// hexColor matches the colors PATCH /prompts/{id} accepts: #rgb or #rrggbb
+var hexColor = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// PATCH /prompts/{id} - Change a prompt's text, keeping its embedding, or its color
+//
+// {"text": "..."} replaces the text; {"color": "#ff8800"} sets the color
+// /points reports for the prompt, as #rgb or #rrggbb hex, and "" clears it.
+// Either or both may be given.
+func (s *Server) handleUpdatePrompt(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid prompt id")
+		return
+	}
+
+	var req struct {
+		Text  *string `json:"text"`
+		Color *string `json:"color"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	if req.Text == nil && req.Color == nil {
+		writeJSONError(w, http.StatusBadRequest, "Give text or color")
+		return
+	}
+	if req.Text != nil && *req.Text == "" {
+		writeJSONError(w, http.StatusBadRequest, "Text cannot be empty")
+		return
+	}
+	if req.Color != nil && *req.Color != "" && !hexColor.MatchString(*req.Color) {
+		writeJSONError(w, http.StatusBadRequest, "Color must be a hex color such as #ff8800")
+		return
+	}
+
+	previous, err := s.store.GetPromptText(r.Context(), id)
+	if errors.Is(err, db.ErrPromptNotFound) {
+		writeJSONError(w, http.StatusNotFound, "Prompt not found")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to load prompt: "+err.Error())
+		return
+	}
+
+	text := previous
+	if req.Text != nil {
+		text = *req.Text
+		err = s.store.UpdatePromptText(id, text)
+		if errors.Is(err, db.ErrPromptNotFound) {
+			writeJSONError(w, http.StatusNotFound, "Prompt not found")
+			return
+		}
+		if errors.Is(err, db.ErrPromptTextExists) {
+			writeJSONError(w, http.StatusConflict, err.Error())
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to update prompt: "+err.Error())
+			return
+		}
+	}
+
+	resp := map[string]interface{}{"id": id, "text": text}
+	if req.Color != nil {
+		color := strings.ToLower(*req.Color)
+		err = s.store.SetPromptColor(id, color)
+		if errors.Is(err, db.ErrPromptNotFound) {
+			writeJSONError(w, http.StatusNotFound, "Prompt not found")
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to set color: "+err.Error())
+			return
+		}
+		resp["color"] = nil
+		if color != "" {
+			resp["color"] = color
+		}
+	}
+
+	// The stored vector still describes the old text
+	embedded, err := s.store.HasEmbedding(r.Context(), id)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to check embedding: "+err.Error())
+		return
+	}
+	resp["embedding_stale"] = embedded && previous != text
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/tlehman/vecviz/db"
+)
+
+const (
+	// embedQueueWorkers is how many queued prompts are embedded concurrently
+	embedQueueWorkers = maxConcurrentEmbeds
+	// maxQueueAttempts is how often a queued prompt is tried before it is left as failed
+	maxQueueAttempts = 5
+	// queuePollInterval is how often idle workers check the queue without being woken
+	queuePollInterval = 5 * time.Second
+	// queueRetryDelay is how long a worker pauses after a failed embedding
+	queueRetryDelay = 2 * time.Second
+)
+
+// embedQueue embeds prompts queued by /embed?async=true in the background.
+// The queue lives in the embed_queue table, so it survives restarts.
+type embedQueue struct {
+	store    *db.Store
+	embedder Embedder
+	// events announces newly embedded prompts to /ws
+	events *progressHub
+	wake   chan struct{}
+
+	// ctx is cancelled by stop, and wg waits for the workers to return
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// startEmbedQueue releases claims left by a previous run and starts the
+// workers, which run until stop
+func startEmbedQueue(store *db.Store, embedder Embedder, events *progressHub, workers int) (*embedQueue, error) {
+	if err := store.ReleaseClaims(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &embedQueue{store: store, embedder: embedder, events: events, wake: make(chan struct{}, workers), ctx: ctx, cancel: cancel}
+	q.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer q.wg.Done()
+			q.work()
+		}()
+	}
+	return q, nil
+}
+
+// stop cancels embed calls in progress and waits for every worker to
+// return, so the store can be closed. A prompt whose embedding was cut short
+// stays claimed, and the next startEmbedQueue releases it without counting
+// an attempt.
+func (q *embedQueue) stop() {
+	if q == nil {
+		return
+	}
+	q.cancel()
+	q.wg.Wait()
+}
+
+// errQueueNotRunning is returned by enqueue on a Server built without a queue
+var errQueueNotRunning = errors.New("background embedding is not running")
+
+// enqueue persists a prompt to the queue and wakes an idle worker
+func (q *embedQueue) enqueue(promptID int64, context string) error {
+	if q == nil {
+		return errQueueNotRunning
+	}
+	if err := q.store.EnqueueEmbedding(promptID, context); err != nil {
+		return err
+	}
+	q.notify(1)
+	return nil
+}
+
+// enqueueReembedAll queues every prompt to have its embedding replaced and
+// wakes all idle workers. It returns how many prompts were queued.
+func (q *embedQueue) enqueueReembedAll() (int, error) {
+	if q == nil {
+		return 0, errQueueNotRunning
+	}
+	n, err := q.store.EnqueueReembedAll()
+	if err != nil {
+		return 0, err
+	}
+	q.notify(n)
+	return n, nil
+}
+
+// notify wakes up to n idle workers; busy ones pick up new work when they finish
+func (q *embedQueue) notify(n int) {
+	for range min(n, cap(q.wake)) {
+		select {
+		case q.wake <- struct{}{}:
+		default:
+			return
+		}
+	}
+}
+
+func (q *embedQueue) work() {
+	for q.ctx.Err() == nil {
+		job, err := q.store.ClaimQueued(maxQueueAttempts)
+		if err != nil {
+			slog.Error("Embed queue: claim failed", "err", err)
+		}
+		if job == nil {
+			q.wait(queuePollInterval)
+			continue
+		}
+
+		if err := q.embed(job); err != nil {
+			// Shutting down isn't the prompt's fault
+			if q.ctx.Err() != nil {
+				return
+			}
+			slog.Error("Embed queue: embed failed", "prompt_id", job.PromptID, "err", err)
+			if err := q.store.FailQueued(job.PromptID, err.Error()); err != nil {
+				slog.Error("Embed queue: failed to record failure", "prompt_id", job.PromptID, "err", err)
+			}
+			select {
+			case <-time.After(queueRetryDelay):
+			case <-q.ctx.Done():
+			}
+			continue
+		}
+		if err := q.store.CompleteQueued(job.PromptID); err != nil {
+			slog.Error("Embed queue: failed to dequeue", "prompt_id", job.PromptID, "err", err)
+		}
+	}
+}
+
+// wait idles a worker for d, until it is woken, or until stop
+func (q *embedQueue) wait(d time.Duration) {
+	select {
+	case <-q.wake:
+	case <-time.After(d):
+	case <-q.ctx.Done():
+	}
+}
+
+// embed embeds and stores one queued prompt. A reembed job replaces the
+// stored embedding; any other job keeps one that is already there.
+func (q *embedQueue) embed(job *db.QueuedPrompt) error {
+	exists, err := q.store.HasEmbedding(context.Background(), job.PromptID)
+	if err != nil {
+		return err
+	}
+
+	if !exists || job.Reembed {
+		text, err := q.store.GetPromptText(context.Background(), job.PromptID)
+		if err != nil {
+			return err
+		}
+		embedding, err := q.embedder.Embed(q.ctx, text)
+		if err != nil {
+			return err
+		}
+		if job.Reembed {
+			err = q.store.ReplaceEmbedding(job.PromptID, embedding)
+		} else {
+			err = q.store.InsertEmbedding(job.PromptID, embedding)
+			if err == nil {
+				publishPointAdded(q.events, q.store, job.PromptID, text, embedding)
+			}
+		}
+		if err != nil && !errors.Is(err, db.ErrEmbeddingExists) {
+			return err
+		}
+	}
+
+	if job.Context != "" {
+		return q.store.SetEmbeddingContext(job.PromptID, job.Context)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/tlehman/vecviz/db"
+)
+
+// blockingEmbedder signals started when a call begins and then blocks until
+// the call's context is cancelled
+type blockingEmbedder struct {
+	started chan struct{}
+}
+
+func (b blockingEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	b.started <- struct{}{}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (b blockingEmbedder) Ping(ctx context.Context) error { return nil }
+
+func TestEmbedQueueStopWaitsForWorkers(t *testing.T) {
+	store, err := db.Open(db.MemoryPath, "", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	embedder := blockingEmbedder{started: make(chan struct{}, 1)}
+	q, err := startEmbedQueue(store, embedder, newProgressHub(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := store.InsertPrompt("queued")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.enqueue(id, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-embedder.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no worker picked up the queued prompt")
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		q.stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stop did not return while a worker was embedding")
+	}
+
+	// The interrupted prompt is still queued, not failed, and the next start
+	// releases it
+	if err := store.ReleaseClaims(); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := store.GetQueueStats(context.Background(), maxQueueAttempts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Pending != 1 || stats.Failed != 0 {
+		t.Errorf("after stop: %+v, want the prompt pending again", stats)
+	}
+}
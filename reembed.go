@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/tlehman/vecviz/db"
+)
+
+// POST /reembed - Replace every prompt's embedding using the current embedder
+// GET /reembed - Report how far the last re-embedding has got
+//
+// Prompts go through the background queue, so the run shares the embedding
+// concurrency limit and carries on after a restart. Posting again while a run
+// is unfinished just reports its progress; ?restart=true queues every prompt
+// again. Before queueing anything, one prompt is embedded to check that the
+// embedder works and returns vectors the database can store. Projections are
+// cleared since they no longer match the embeddings.
+func (s *Server) handleReembed(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.writeReembedProgress(r.Context(), w, http.StatusOK, false)
+	case http.MethodPost:
+		s.startReembed(w, r)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func (s *Server) startReembed(w http.ResponseWriter, r *http.Request) {
+	if s.queue == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, errQueueNotRunning.Error())
+		return
+	}
+
+	if r.URL.Query().Get("restart") != "true" {
+		stats, err := s.store.GetReembedStats(r.Context(), maxQueueAttempts)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to get queue: "+err.Error())
+			return
+		}
+		if stats.Pending+stats.InProgress > 0 {
+			s.writeReembedProgress(r.Context(), w, http.StatusAccepted, true)
+			return
+		}
+	}
+
+	sample, err := s.store.ListPrompts(r.Context(), 1, 0, false)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to list prompts: "+err.Error())
+		return
+	}
+	if len(sample) > 0 {
+		// A wrong model fails here once rather than for every queued prompt
+		if _, err := s.embedder.Embed(r.Context(), sample[0].Text); err != nil {
+			writeJSONError(w, http.StatusBadGateway, "Embedder check failed: "+err.Error())
+			return
+		}
+	}
+
+	if err := s.store.ClearProjections(); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to clear projections: "+err.Error())
+		return
+	}
+	total, err := s.queue.enqueueReembedAll()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to queue prompts: "+err.Error())
+		return
+	}
+	if err := s.store.SetMeta("reembed_total", strconv.Itoa(total)); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to record re-embedding: "+err.Error())
+		return
+	}
+
+	s.writeReembedProgress(r.Context(), w, http.StatusAccepted, false)
+}
+
+// writeReembedProgress responds with the progress of the last re-embedding.
+// resumed says whether a POST found a run already underway.
+func (s *Server) writeReembedProgress(ctx context.Context, w http.ResponseWriter, status int, resumed bool) {
+	stats, err := s.store.GetReembedStats(ctx, maxQueueAttempts)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to get queue: "+err.Error())
+		return
+	}
+	total := 0
+	if v, _ := s.store.GetMeta("reembed_total"); v != "" {
+		total, _ = strconv.Atoi(v)
+	}
+	// Prompts leave the queue once re-embedded, so whatever isn't in it is done
+	done := max(total-stats.Pending-stats.InProgress-stats.Failed, 0)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"total":       total,
+		"done":        done,
+		"pending":     stats.Pending,
+		"in_progress": stats.InProgress,
+		"failed":      stats.Failed,
+		"complete":    stats.Pending+stats.InProgress == 0,
+		"resumed":     resumed,
+		"dimension":   db.Dimension,
+	})
+}
@@ -0,0 +1,10 @@
+// Package scripts holds the Python reducer scripts. They are embedded so a
+// built vecviz binary can run them without the source tree.
+package scripts
+
+import "embed"
+
+// FS holds every reducer script, named by file
+//
+//go:embed *.py
+var FS embed.FS
@@ -1,20 +1,82 @@
 #!/usr/bin/env python3
 """
 t-SNE dimensionality reduction script.
-Reads embeddings from stdin as JSON, outputs 3D projections to stdout.
+Reads embeddings from stdin as JSON, outputs projections to stdout with
+params.dimensions axes (3 by default) in coords, the first three also as
+x, y and z. Axes past the dimensions are 0 in x, y and z.
+
+While t-SNE runs, a "PROGRESS {...}" line is written to stdout for each
+iteration sklearn reports, ahead of the final JSON result.
 """
 
+import io
+import re
 import sys
 import json
+from contextlib import redirect_stdout
+
 import numpy as np
+from sklearn.decomposition import PCA
 from sklearn.manifold import TSNE
 
+# Keep the convergence history small no matter how many iterations run
+MAX_HISTORY = 200
+
+# sklearn's verbose progress line, e.g.
+# [t-SNE] Iteration 50: error = 52.1, gradient norm = 0.0123 (50 iterations in 0.1s)
+ITERATION_RE = re.compile(
+    r"Iteration (\d+): error = ([-\d.e+]+), gradient norm = ([-\d.e+]+)"
+)
+
+
+# Marks a progress line on stdout, as opposed to the final result
+PROGRESS_PREFIX = "PROGRESS "
+
+
+class ProgressLog(io.StringIO):
+    """Collects sklearn's verbose log, reporting each iteration to out as it happens."""
+
+    def __init__(self, out, iterations):
+        super().__init__()
+        self.out = out
+        self.iterations = iterations
+        self.partial = ""
+
+    def write(self, s):
+        self.partial += s
+        *lines, self.partial = self.partial.split("\n")
+        for line in lines:
+            match = ITERATION_RE.search(line)
+            if match:
+                progress = {
+                    "iteration": int(match.group(1)),
+                    "iterations": self.iterations,
+                    "kl_divergence": float(match.group(2)),
+                }
+                self.out.write(PROGRESS_PREFIX + json.dumps(progress) + "\n")
+                self.out.flush()
+        return super().write(s)
+
+
+def parse_history(log):
+    """Extract (iteration, KL divergence, gradient norm) from sklearn's verbose log."""
+    history = []
+    for match in ITERATION_RE.finditer(log):
+        history.append({
+            "iteration": int(match.group(1)),
+            "kl_divergence": float(match.group(2)),
+            "grad_norm": float(match.group(3)),
+        })
+    return history[-MAX_HISTORY:]
+
 
 def main():
     # Read JSON from stdin
     data = json.load(sys.stdin)
 
     embeddings = data.get("embeddings", [])
+    params = data.get("params") or {}
+    dimensions = params.get("dimensions") or 3
     n_samples = len(embeddings)
 
     if n_samples == 0:
@@ -42,17 +104,48 @@ def main():
         return
 
     # Adjust perplexity for small datasets (must be < n_samples)
-    perplexity = min(30, max(5, (n_samples - 1) // 3))
+    perplexity = params.get("perplexity") or min(30, max(5, (n_samples - 1) // 3))
+    perplexity = min(perplexity, n_samples - 1)
+
+    # Reducing to a few dozen dimensions first keeps most of the neighborhood
+    # structure and makes t-SNE's neighbor search far cheaper
+    components = params.get("pca_components") or 0
+    if 0 < components < min(vectors.shape):
+        vectors = PCA(n_components=components, random_state=params.get("random_seed", 42)).fit_transform(vectors)
+
+    # Incremental runs start from the previous layout and only refine it, so
+    # skip early exaggeration and run the fewest iterations sklearn allows
+    init = params.get("init") or "pca"
+    early_exaggeration = 12.0
+    default_iterations = 1000
+    if params.get("incremental") and all(item.get("init") for item in embeddings):
+        init = np.array([item["init"][:dimensions] for item in embeddings], dtype=np.float32)
+        early_exaggeration = 1.0
+        default_iterations = 250
 
     # Run t-SNE
+    metric = params.get("metric") or "euclidean"
+    iterations = params.get("iterations") or default_iterations
     tsne = TSNE(
-        n_components=3,
+        n_components=dimensions,
         perplexity=perplexity,
-        random_state=42,
-        max_iter=1000,
-        init="pca",
+        random_state=params.get("random_seed", 42),
+        max_iter=iterations,
+        learning_rate=params.get("learning_rate") or "auto",
+        early_exaggeration=early_exaggeration,
+        init=init,
+        metric=metric,
+        # Barnes-Hut only handles up to 3 components; exact is quadratic in
+        # the number of points, so more dimensions are much slower
+        method="barnes_hut" if dimensions <= 3 else "exact",
+        verbose=2,
     )
-    projections = tsne.fit_transform(vectors)
+    # sklearn reports progress on stdout, which is reserved for our own
+    # progress lines and JSON
+    log = ProgressLog(sys.stdout, iterations)
+    with redirect_stdout(log):
+        projections = tsne.fit_transform(vectors)
+    history = parse_history(log.getvalue())
 
     # Normalize to [-1, 1] range for visualization
     max_abs = np.abs(projections).max()
@@ -60,16 +153,25 @@ def main():
         projections = projections / max_abs
 
     # Build output
+    # x, y and z are the first three axes, zero past the dimensions asked for,
+    # and coords carries every axis
     results = []
     for i, proj in enumerate(projections):
+        coords = [float(v) for v in proj]
+        xyz = (coords + [0.0, 0.0, 0.0])[:3]
         results.append({
             "id": ids[i],
-            "x": float(proj[0]),
-            "y": float(proj[1]),
-            "z": float(proj[2]),
+            "x": xyz[0],
+            "y": xyz[1],
+            "z": xyz[2],
+            "coords": coords,
         })
 
-    json.dump({"projections": results}, sys.stdout)
+    json.dump({
+        "projections": results,
+        "history": history,
+        "kl_divergence": float(tsne.kl_divergence_),
+    }, sys.stdout)
 
 
 if __name__ == "__main__":
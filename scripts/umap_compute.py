@@ -0,0 +1,88 @@
+#!/usr/bin/env python3
+"""
+UMAP dimensionality reduction script.
+Reads embeddings from stdin as JSON, outputs projections to stdout in the
+same format as tsne_compute.py. Takes the same input too; of the params it
+uses dimensions, random_seed, n_neighbors, min_dist and incremental.
+"""
+
+import sys
+import json
+from contextlib import redirect_stdout
+
+import numpy as np
+import umap
+
+
+def main():
+    # Read JSON from stdin
+    data = json.load(sys.stdin)
+
+    embeddings = data.get("embeddings", [])
+    params = data.get("params") or {}
+    dimensions = params.get("dimensions") or 3
+    n_samples = len(embeddings)
+
+    if n_samples == 0:
+        json.dump({"projections": []}, sys.stdout)
+        return
+
+    # Extract IDs and vectors
+    ids = [item["id"] for item in embeddings]
+    vectors = np.array([item["vector"] for item in embeddings], dtype=np.float32)
+
+    # UMAP's spectral initialization needs more points than output dimensions
+    if n_samples <= dimensions + 1:
+        results = []
+        for i, item_id in enumerate(ids):
+            x = -1.0 + 2.0 * i / (n_samples - 1) if n_samples > 1 else 0.0
+            results.append({"id": item_id, "x": x, "y": 0.0, "z": 0.0})
+        json.dump({"projections": results}, sys.stdout)
+        return
+
+    # n_neighbors must be smaller than the number of samples
+    n_neighbors = params.get("n_neighbors") or 15
+    n_neighbors = max(2, min(n_neighbors, n_samples - 1))
+
+    # Incremental runs start from the previous layout
+    init = "spectral"
+    if params.get("incremental") and all(item.get("init") for item in embeddings):
+        init = np.array([item["init"][:dimensions] for item in embeddings], dtype=np.float32)
+
+    reducer = umap.UMAP(
+        n_components=dimensions,
+        n_neighbors=n_neighbors,
+        min_dist=params.get("min_dist") or 0.1,
+        random_state=params.get("random_seed", 42),
+        init=init,
+    )
+    # stdout is reserved for the JSON result
+    with redirect_stdout(sys.stderr):
+        projections = reducer.fit_transform(vectors)
+
+    # Normalize to [-1, 1] range for visualization
+    projections = projections - projections.mean(axis=0)
+    max_abs = np.abs(projections).max()
+    if max_abs > 0:
+        projections = projections / max_abs
+
+    # Build output
+    # x, y and z are the first three axes, zero past the dimensions asked for,
+    # and coords carries every axis
+    results = []
+    for i, proj in enumerate(projections):
+        coords = [float(v) for v in proj]
+        xyz = (coords + [0.0, 0.0, 0.0])[:3]
+        results.append({
+            "id": ids[i],
+            "x": xyz[0],
+            "y": xyz[1],
+            "z": xyz[2],
+            "coords": coords,
+        })
+
+    json.dump({"projections": results}, sys.stdout)
+
+
+if __name__ == "__main__":
+    main()
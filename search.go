@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/tlehman/vecviz/db"
+	"github.com/tlehman/vecviz/vecmath"
+)
+
+// defaultMaxSearchK is the most results a search or neighbor lookup returns
+// unless VECVIZ_MAX_SEARCH_K says otherwise. Each result costs a KNN row and
+// a prompt lookup, so an unbounded k would scan and send the whole database.
+const defaultMaxSearchK = 100
+
+// parseK reads a k query parameter: defaultSearchK if it is empty, otherwise
+// a whole number from 1 to s.maxSearchK
+func (s *Server) parseK(v string) (int, error) {
+	if v == "" {
+		return defaultSearchK, nil
+	}
+	// 0 is only "not given" in JSON; here it is out of range
+	k, err := strconv.Atoi(v)
+	if err != nil || k < 1 {
+		return 0, s.errInvalidK()
+	}
+	return s.checkK(k)
+}
+
+// checkK validates k from a JSON body, where 0 means it wasn't given and
+// defaultSearchK applies
+func (s *Server) checkK(k int) (int, error) {
+	if k == 0 {
+		return defaultSearchK, nil
+	}
+	if k < 1 || k > s.maxSearchK {
+		return 0, s.errInvalidK()
+	}
+	return k, nil
+}
+
+// errInvalidK is the error for a k out of range
+func (s *Server) errInvalidK() error {
+	return fmt.Errorf("k must be between 1 and %d", s.maxSearchK)
+}
+
+// searchTruncated finds the k nearest prompts using only the first dim
+// components of each vector, re-normalized to unit length, and compares them
+// with the database's metric. This is only
+// meaningful for Matryoshka-trained models, whose leading dimensions carry
+// most of the signal; for other models the truncated vectors are noise.
+// It scans every embedding in Go rather than using the vec0 index.
+func (s *Server) searchTruncated(ctx context.Context, query []float32, k, dim int) ([]db.SearchResult, error) {
+	embeddings, err := s.store.GetAllEmbeddings(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	q := vecmath.Normalize(query[:dim])
+	results := make([]db.SearchResult, 0, len(embeddings))
+	for _, e := range embeddings {
+		if len(e.Vector) < dim {
+			continue
+		}
+		results = append(results, db.SearchResult{
+			PromptID: e.PromptID,
+			Distance: distance(s.store.Metric, q, vecmath.Normalize(e.Vector[:dim])),
+		})
+	}
+
+	// Equal distances fall back to prompt ID, so ties rank the same every time
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Distance != results[j].Distance {
+			return results[i].Distance < results[j].Distance
+		}
+		return results[i].PromptID < results[j].PromptID
+	})
+	if len(results) > k {
+		results = results[:k]
+	}
+
+	for i := range results {
+		results[i].Text, err = s.store.GetPromptText(ctx, results[i].PromptID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// cosineSimilarities returns each match's cosine similarity to query, in
+// [-1, 1] whatever the database's metric, from its stored vector. Vectors are
+// cut to query's length, so a truncated query compares truncated vectors. A
+// match whose embedding has since been deleted is left out.
+func (s *Server) cosineSimilarities(ctx context.Context, query []float32, matches []db.SearchResult) (map[int64]float64, error) {
+	similarities := make(map[int64]float64, len(matches))
+	for _, m := range matches {
+		vector, err := s.store.GetEmbedding(ctx, m.PromptID)
+		if errors.Is(err, db.ErrEmbeddingNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(vector) > len(query) {
+			vector = vector[:len(query)]
+		}
+		similarities[m.PromptID] = vecmath.Cosine(query, vector)
+	}
+	return similarities, nil
+}
+
+// similarityOrNil returns id's entry in similarities, or nil if it has none
+func similarityOrNil(similarities map[int64]float64, id int64) interface{} {
+	if v, ok := similarities[id]; ok {
+		return v
+	}
+	return nil
+}
+
+// vectorNorm returns the L2 norm of v
+func vectorNorm(v []float32) float64 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	return math.Sqrt(sum)
+}
+
+// distance compares two vectors the way the vec0 table does for metric
+func distance(metric string, a, b []float32) float64 {
+	if metric == db.MetricCosine {
+		return 1 - vecmath.Cosine(a, b)
+	}
+	return vecmath.L2Distance(a, b)
+}
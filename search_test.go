@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestParseK(t *testing.T) {
+	s := &Server{maxSearchK: 100}
+	tests := []struct {
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{"", defaultSearchK, false},
+		{"1", 1, false},
+		{"100", 100, false},
+		{"0", 0, true},
+		{"-5", 0, true},
+		{"101", 0, true},
+		{"1000000", 0, true},
+		{"99999999999999999999", 0, true},
+		{"ten", 0, true},
+		{"2.5", 0, true},
+	}
+	for _, tt := range tests {
+		k, err := s.parseK(tt.value)
+		if (err != nil) != tt.wantErr || k != tt.want {
+			t.Errorf("parseK(%q) = %d, %v, want %d with error %v", tt.value, k, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestCheckK(t *testing.T) {
+	s := &Server{maxSearchK: 100}
+	// In a JSON body 0 means k wasn't given
+	if k, err := s.checkK(0); err != nil || k != defaultSearchK {
+		t.Errorf("checkK(0) = %d, %v, want the default %d", k, err, defaultSearchK)
+	}
+	for _, k := range []int{-1, 101} {
+		if _, err := s.checkK(k); err == nil {
+			t.Errorf("checkK(%d) accepted", k)
+		}
+	}
+}
+
+func TestSearchRejectsBadK(t *testing.T) {
+	s := newTestServer(t, nil)
+	for _, target := range []string{"/search?q=hello&k=-1", "/search?q=hello&k=1000000", "/points/1/neighbors?k=0"} {
+		if status, resp := do(t, s, http.MethodGet, target, nil); status != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400 (%v)", target, status, resp)
+		}
+	}
+}
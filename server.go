@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+	"github.com/tlehman/vecviz/db"
+	"github.com/tlehman/vecviz/tsne"
+	"golang.org/x/sync/singleflight"
+)
+
+// Server holds everything the HTTP handlers share. Its methods are the
+// handlers, so separate Servers, each with its own Store and Embedder, can
+// run side by side.
+type Server struct {
+	embedder Embedder
+	store    *db.Store
+	// queue embeds prompts submitted with /embed?async=true in the background
+	queue *embedQueue
+	// progress reports the progress of projection runs to /tsne/progress
+	progress *progressHub
+	// events reports new points and recomputed layouts to /ws
+	events *progressHub
+	// upgrader accepts /ws connections
+	upgrader *websocket.Upgrader
+	// reducers are the dimensionality reducers selectable via ?method= on /tsne/compute
+	reducers map[string]tsne.Reducer
+	// apiKey, if set, must be presented as a bearer token on write routes
+	apiKey string
+	// ollamaURLs are the Ollama servers /embed may send a request to with "ollama_url"
+	ollamaURLs map[string]bool
+	// maxDistancePoints caps how many embeddings /distances returns a full matrix for
+	maxDistancePoints int
+	// maxPromptLength caps how many characters /embed accepts in a prompt
+	maxPromptLength int
+	// maxSearchK caps k for /search, /search/farthest, /embed/combined and
+	// neighbor lookups
+	maxSearchK int
+	// coordPrecision is how many decimals /points rounds coordinates to, or fullPrecision
+	coordPrecision int
+	// projectionHistory is how many snapshots /tsne/compute?snapshot=true keeps for /tsne/diff
+	projectionHistory int
+	// allowReset enables POST /reset
+	allowReset bool
+
+	// embedGroup coalesces concurrent embeds of the same prompt text
+	embedGroup singleflight.Group
+	// projectionMu serializes projection runs so concurrent recomputes don't interleave their writes
+	projectionMu sync.Mutex
+	// projectionGroup coalesces concurrent identical projection runs
+	projectionGroup singleflight.Group
+	// cancelMu guards cancelProjection, which stops the running projection
+	// and is nil when none is running
+	cancelMu         sync.Mutex
+	cancelProjection context.CancelFunc
+	// runningProjection is the ID of the run reducing now, 0 between runs,
+	// so the reducer's progress reports can say which run they belong to
+	runningProjection atomic.Int64
+
+	handler http.Handler
+}
+
+// newServer returns a Server embedding with client and storing in store,
+// running Python reducers with python and applying the given CORS policies
+// on read and write routes. Nil policies add no CORS headers. queue may be
+// nil if nothing is embedded in the background, in which case
+// /embed?async=true fails. events is shared with the queue so its points
+// reach /ws; nil gives the Server a hub of its own. static holds the web UI
+// served under /; nil serves only the API. The Server touches no global mux,
+// so tests can serve it with httptest against a fake Embedder.
+func newServer(client Embedder, store *db.Store, queue *embedQueue, events *progressHub, python tsne.Runtime, static fs.FS, readCORS, writeCORS *corsPolicy) *Server {
+	if events == nil {
+		events = newProgressHub()
+	}
+	s := &Server{
+		embedder: client,
+		store:    store,
+		queue:    queue,
+		progress: newProgressHub(),
+		events:   events,
+		upgrader: newUpgrader(readCORS),
+
+		maxDistancePoints: defaultMaxDistancePoints,
+		maxPromptLength:   defaultMaxPromptLength,
+		maxSearchK:        defaultMaxSearchK,
+		projectionHistory: defaultProjectionHistory,
+		coordPrecision:    defaultCoordPrecision,
+	}
+	s.reducers = map[string]tsne.Reducer{
+		"tsne":              tsne.PythonReducer{OnProgress: func(p tsne.Progress) { s.progress.publish("progress", runProgress{p, s.runningProjection.Load()}) }, Runtime: python},
+		"random_projection": tsne.RandomProjectionReducer{},
+		"pca":               tsne.PCAReducer{},
+		"umap":              tsne.UMAPReducer{Runtime: python},
+	}
+
+	// Write routes share a CORS policy and, if VECVIZ_API_KEY is set, need
+	// the key. CORS runs first so preflights, which carry no credentials, work.
+	write := func(h http.HandlerFunc) http.HandlerFunc { return writeCORS.wrap(s.requireAPIKey(h)) }
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/embed", write(s.handleEmbed))
+	mux.HandleFunc("/embed/batch", write(s.handleEmbedBatch))
+	mux.HandleFunc("/embed/combined", write(s.handleEmbedCombined))
+	mux.HandleFunc("/queue", readCORS.wrap(s.handleQueue))
+	mux.HandleFunc("/reembed", write(s.handleReembed))
+	mux.HandleFunc("/maintenance/gc", write(s.handleGC))
+	mux.HandleFunc("/reset", write(s.handleReset))
+	mux.HandleFunc("/import/openai-jsonl", write(s.handleImportOpenAIJSONL))
+	mux.HandleFunc("/export", readCORS.wrap(s.handleExport))
+	mux.HandleFunc("/import", write(s.handleImport))
+	mux.HandleFunc("/tsne/compute", write(s.handleTSNECompute))
+	mux.HandleFunc("/tsne/cancel", write(s.handleTSNECancel))
+	mux.HandleFunc("/tsne/backfill", write(s.handleTSNEBackfill))
+	mux.HandleFunc("/tsne/history", readCORS.wrap(s.handleTSNEHistory))
+	mux.HandleFunc("/tsne/runs", readCORS.wrap(s.handleTSNERuns))
+	mux.HandleFunc("/tsne/diff", readCORS.wrap(s.handleTSNEDiff))
+	mux.HandleFunc("/tsne/progress", readCORS.wrap(s.handleTSNEProgress))
+	mux.HandleFunc("/cluster", write(s.handleCluster))
+	mux.HandleFunc("/points", readCORS.wrap(s.handlePoints))
+	mux.HandleFunc("/points/grid", readCORS.wrap(s.handlePointsGrid))
+	mux.HandleFunc("/points/{id}/embedding", readCORS.wrap(s.handlePointEmbedding))
+	mux.HandleFunc("/points/{id}/neighbors", readCORS.wrap(s.handlePointNeighbors))
+	mux.HandleFunc("/graph/build", write(s.handleGraphBuild))
+	mux.HandleFunc("/prompts", readCORS.wrap(s.handleListPrompts))
+	mux.HandleFunc("/prompts/lookup", readCORS.wrap(s.handleLookupPrompt))
+	mux.HandleFunc("/prompts/delete", write(s.handleDeletePrompts))
+	mux.HandleFunc("/prompts/{id}", write(s.handlePrompt))
+	mux.HandleFunc("/prompts/{id}/restore", write(s.handleRestorePrompt))
+	mux.HandleFunc("/project", readCORS.wrap(s.handleProject))
+	mux.HandleFunc("/project/batch", readCORS.wrap(s.handleProjectBatch))
+	mux.HandleFunc("/search", readCORS.wrap(s.handleSearch))
+	mux.HandleFunc("/search/farthest", readCORS.wrap(s.handleSearchFarthest))
+	mux.HandleFunc("/pairs/top", readCORS.wrap(s.handleTopPairs))
+	mux.HandleFunc("/distances", readCORS.wrap(s.handleDistances))
+	mux.HandleFunc("/stats", readCORS.wrap(s.handleStats))
+	mux.HandleFunc("/stats/centroid-trajectory", readCORS.wrap(s.handleCentroidTrajectory))
+	mux.HandleFunc("/stats/embedding-ages", readCORS.wrap(s.handleEmbeddingAges))
+	mux.HandleFunc("/ws", readCORS.wrap(s.handleWS))
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/version", readCORS.wrap(s.handleVersion))
+	if static != nil {
+		mux.Handle("/", http.FileServerFS(static))
+	}
+
+	s.handler = logRequests(recoverPanics(compressResponses(mux)))
+	return s
+}
+
+// ServeHTTP serves every route, with request logging, panic recovery and
+// response compression
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// recoverPanics turns a panicking handler into a logged stack trace and a
+// 500 response instead of a dropped connection
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			// The server uses this to abort a response on purpose
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			slog.Error("Handler panicked",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"panic", v,
+				"stack", string(debug.Stack()),
+			)
+			writeJSONError(w, http.StatusInternalServerError, "internal server error")
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeJSONError responds with status and {"error": msg}, so clients can
+// decode every response as JSON, failures included. Like http.Error, it
+// leaves the handler to return without writing anything else.
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	h := w.Header()
+	// Set for the response the handler meant to send, they'd be wrong now
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"error": msg})
+}
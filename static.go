@@ -0,0 +1,44 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// embeddedStatic holds the web UI, so a built binary serves it from any
+// working directory
+//
+//go:embed static
+var embeddedStatic embed.FS
+
+// staticFS returns the web UI's files: dir if it is set, or else the copy
+// embedded in the binary. A dir that isn't a directory is an error, so a
+// typo doesn't quietly serve nothing.
+func staticFS(dir string) (fs.FS, error) {
+	if dir == "" {
+		return fs.Sub(embeddedStatic, "static")
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	return os.DirFS(dir), nil
+}
+
+// staticDirFromEnv returns the directory VECVIZ_STATIC_DIR names, or the
+// static/ folder of a VECVIZ_ROOT checkout, or "" for the embedded copy
+func staticDirFromEnv() string {
+	if dir := os.Getenv("VECVIZ_STATIC_DIR"); dir != "" {
+		return dir
+	}
+	if root := os.Getenv("VECVIZ_ROOT"); root != "" {
+		return filepath.Join(root, "static")
+	}
+	return ""
+}
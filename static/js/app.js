@@ -212,8 +212,37 @@ async function fetchPoints() {
   }
 }
 
+// writeFetch calls a write route with the API key saved in this browser. If
+// the server refuses it, it asks for the key once and retries.
+async function writeFetch(url, options = {}) {
+  const send = () => {
+    const key = localStorage.getItem("vecvizApiKey");
+    const headers = { ...options.headers };
+    if (key) headers.Authorization = `Bearer ${key}`;
+    return fetch(url, { ...options, headers });
+  };
+  let response = await send();
+  if (response.status === 401) {
+    const key = window.prompt("This server needs an API key for changes:");
+    if (key) {
+      localStorage.setItem("vecvizApiKey", key);
+      response = await send();
+    }
+  }
+  if (!response.ok) {
+    // Errors come back as {"error": "..."}; fall back to the raw body
+    const body = await response.text();
+    let message = body.trim();
+    try {
+      message = JSON.parse(body).error || message;
+    } catch {}
+    throw new Error(message);
+  }
+  return response;
+}
+
 async function submitEmbed(prompt) {
-  const response = await fetch("/embed", {
+  const response = await writeFetch("/embed?project=true", {
     method: "POST",
     headers: { "Content-Type": "application/json" },
     body: JSON.stringify({ prompt }),
@@ -222,7 +251,7 @@ async function submitEmbed(prompt) {
 }
 
 async function recomputeTSNE() {
-  const response = await fetch("/tsne/compute", { method: "POST" });
+  const response = await writeFetch("/tsne/compute", { method: "POST" });
   return response.json();
 }
 
@@ -238,6 +267,42 @@ async function refreshVisualization() {
   }
 }
 
+// Show a newly embedded prompt where the server placed it among its
+// neighbors, until the next recompute gives it a real position
+function addProvisionalPoint(result) {
+  const point = {
+    id: result.id,
+    text: result.prompt,
+    x: result.x,
+    y: result.y,
+    z: result.z,
+  };
+  const i = pointsData.findIndex((p) => p.id === result.id);
+  if (i >= 0) {
+    pointsData[i] = { ...pointsData[i], ...point };
+  } else {
+    pointsData.push(point);
+  }
+  renderPoints();
+}
+
+// Follow /ws so points embedded elsewhere appear at once and the layout
+// reloads whenever projections are recomputed
+function connectLiveUpdates() {
+  const protocol = location.protocol === "https:" ? "wss:" : "ws:";
+  const socket = new WebSocket(`${protocol}//${location.host}/ws`);
+  socket.addEventListener("message", (e) => {
+    const event = JSON.parse(e.data);
+    if (event.type === "point_added" && event.data.x != null) {
+      addProvisionalPoint(event.data);
+    } else if (event.type === "projection_updated") {
+      refreshVisualization();
+    }
+  });
+  // Reconnect after the server restarts
+  socket.addEventListener("close", () => setTimeout(connectLiveUpdates, 5000));
+}
+
 function setStatus(message, type = "") {
   const status = document.getElementById("status");
   status.textContent = message;
@@ -255,10 +320,18 @@ document.getElementById("embed-form").addEventListener("submit", async (e) => {
   try {
     const result = await submitEmbed(prompt);
     input.value = "";
-    setStatus(
-      `Embedding added (${result.embedding_dim}D). Recompute t-SNE to visualize.`,
-      "success",
-    );
+    if (result.x != null) {
+      addProvisionalPoint(result);
+      setStatus(
+        `Embedding added (${result.embedding_dim}D) at a provisional position. Recompute t-SNE to refine.`,
+        "success",
+      );
+    } else {
+      setStatus(
+        `Embedding added (${result.embedding_dim}D). Recompute t-SNE to visualize.`,
+        "success",
+      );
+    }
   } catch (error) {
     setStatus("Failed to add embedding: " + error.message, "error");
   }
@@ -286,3 +359,4 @@ document.getElementById("search-input").addEventListener("input", (e) => {
 // Initialize
 initScene();
 refreshVisualization();
+connectLiveUpdates();
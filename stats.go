@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/tlehman/vecviz/tsne"
+	"github.com/tlehman/vecviz/vecmath"
+)
+
+// truncateToBucket returns the start of the bucket containing t, and false for an unknown bucket size
+func truncateToBucket(t time.Time, bucket string) (time.Time, bool) {
+	t = t.UTC()
+	switch bucket {
+	case "hour":
+		return t.Truncate(time.Hour), true
+	case "day":
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), true
+	case "week":
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		// Weeks start on Monday
+		offset := (int(day.Weekday()) + 6) % 7
+		return day.AddDate(0, 0, -offset), true
+	case "month":
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC), true
+	}
+	return time.Time{}, false
+}
+
+// GET /stats - Summarize the database for monitoring
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	stats, err := s.store.Stats(r.Context())
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to get stats: "+err.Error())
+		return
+	}
+
+	var projectedAt interface{}
+	if !stats.ProjectedAt.IsZero() {
+		projectedAt = stats.ProjectedAt.Format(time.RFC3339)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"prompts":           stats.Prompts,
+		"embeddings":        stats.Embeddings,
+		"projections":       stats.Projections,
+		"dimension":         stats.Dimension,
+		"metric":            stats.Metric,
+		"last_projected_at": projectedAt,
+		"db_size_bytes":     stats.SizeBytes,
+	})
+}
+
+// GET /stats/centroid-trajectory?bucket=day&method=pca - Track how the average embedding drifts over time
+//
+// Prompts are bucketed by created_at (hour, day, week, or month), each
+// bucket's embeddings are averaged into a centroid, and the centroids are
+// projected together with the chosen reducer (PCA by default, since there
+// are usually only a handful of buckets).
+func (s *Server) handleCentroidTrajectory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	bucket := r.URL.Query().Get("bucket")
+	if bucket == "" {
+		bucket = "day"
+	}
+	if _, ok := truncateToBucket(time.Time{}, bucket); !ok {
+		writeJSONError(w, http.StatusBadRequest, "Bucket must be hour, day, week, or month")
+		return
+	}
+
+	method := r.URL.Query().Get("method")
+	if method == "" {
+		method = "pca"
+	}
+	reducer, ok := s.reducers[method]
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, "Unknown method: "+method)
+		return
+	}
+
+	embeddings, err := s.store.GetAllEmbeddings(r.Context())
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to get embeddings: "+err.Error())
+		return
+	}
+	createdAt, err := s.store.GetPromptCreationTimes(r.Context())
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to get prompts: "+err.Error())
+		return
+	}
+
+	buckets := make(map[time.Time][][]float32)
+	for _, e := range embeddings {
+		t, ok := createdAt[e.PromptID]
+		if !ok {
+			continue
+		}
+		start, _ := truncateToBucket(t, bucket)
+		buckets[start] = append(buckets[start], e.Vector)
+	}
+
+	starts := make([]time.Time, 0, len(buckets))
+	for start := range buckets {
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+
+	centroids := make([]tsne.EmbeddingInput, len(starts))
+	for i, start := range starts {
+		centroids[i] = tsne.EmbeddingInput{ID: int64(i), Vector: vecmath.Mean(buckets[start])}
+	}
+
+	output, err := reducer.Reduce(r.Context(), centroids, tsne.TSNEParams{})
+	if err != nil {
+		slog.Error("Centroid trajectory failed", "centroids", len(centroids), "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "Projection failed: "+err.Error())
+		return
+	}
+
+	positions := make(map[int64]tsne.ProjectionOutput, len(output.Projections))
+	for _, p := range output.Projections {
+		positions[p.ID] = p
+	}
+
+	trajectory := make([]map[string]interface{}, len(starts))
+	for i, start := range starts {
+		p := positions[int64(i)]
+		trajectory[i] = map[string]interface{}{
+			"bucket": start.Format(time.RFC3339),
+			"count":  len(buckets[start]),
+			"x":      p.X,
+			"y":      p.Y,
+			"z":      p.Z,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"bucket":     bucket,
+		"method":     method,
+		"trajectory": trajectory,
+	})
+}
+
+// GET /stats/embedding-ages?bucket=day - Summarize when embeddings were generated
+//
+// Reports the oldest and newest embedded_at and how many embeddings fall in
+// each bucket, to find stale embeddings that predate a model change.
+// Embeddings stored before embedded_at was tracked use their prompt's created_at.
+func (s *Server) handleEmbeddingAges(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	bucket := r.URL.Query().Get("bucket")
+	if bucket == "" {
+		bucket = "day"
+	}
+	if _, ok := truncateToBucket(time.Time{}, bucket); !ok {
+		writeJSONError(w, http.StatusBadRequest, "Bucket must be hour, day, week, or month")
+		return
+	}
+
+	embeddedAt, err := s.store.GetEmbeddedTimes(r.Context())
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to get embedding times: "+err.Error())
+		return
+	}
+
+	var oldest, newest time.Time
+	counts := make(map[time.Time]int)
+	for _, t := range embeddedAt {
+		if oldest.IsZero() || t.Before(oldest) {
+			oldest = t
+		}
+		if newest.IsZero() || t.After(newest) {
+			newest = t
+		}
+		start, _ := truncateToBucket(t, bucket)
+		counts[start]++
+	}
+
+	starts := make([]time.Time, 0, len(counts))
+	for start := range counts {
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+
+	buckets := make([]map[string]interface{}, len(starts))
+	for i, start := range starts {
+		buckets[i] = map[string]interface{}{
+			"bucket": start.Format(time.RFC3339),
+			"count":  counts[start],
+		}
+	}
+
+	resp := map[string]interface{}{
+		"bucket":  bucket,
+		"count":   len(embeddedAt),
+		"oldest":  nil,
+		"newest":  nil,
+		"buckets": buckets,
+	}
+	if len(embeddedAt) > 0 {
+		resp["oldest"] = oldest.UTC().Format(time.RFC3339)
+		resp["newest"] = newest.UTC().Format(time.RFC3339)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// maxRenderedPrompt bounds the text a prompt template may produce, since
+// constructs such as {{range 1000000000}} can otherwise expand without limit
+const maxRenderedPrompt = 1 << 20
+
+// errPromptTooLong is returned once a template's output passes maxRenderedPrompt
+var errPromptTooLong = fmt.Errorf("rendered prompt is longer than %d bytes", maxRenderedPrompt)
+
+// renderPromptTemplate executes a text/template over a document's fields to
+// produce the text to embed, e.g. "{{.title}}\n{{.body}}". Referring to a
+// field that isn't given is an error rather than "<no value>".
+func renderPromptTemplate(text string, fields map[string]string) (string, error) {
+	tmpl, err := template.New("prompt").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	out := &limitedBuilder{limit: maxRenderedPrompt}
+	if err := tmpl.Execute(out, fields); err != nil {
+		// Execute wraps writer errors, so unwrap to report the limit plainly
+		if errors.Is(err, errPromptTooLong) {
+			return "", errPromptTooLong
+		}
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// limitedBuilder is a strings.Builder that refuses to grow past limit bytes
+type limitedBuilder struct {
+	strings.Builder
+	limit int
+}
+
+func (b *limitedBuilder) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.limit {
+		return 0, errPromptTooLong
+	}
+	return b.Builder.Write(p)
+}
@@ -0,0 +1,61 @@
+package tsne
+
+// MaxDimensions bounds TSNEParams.Dimensions. Axes past the third are kept
+// only in ProjectionOutput.Coords.
+const MaxDimensions = 16
+
+// newProjection returns a projection at coords
+func newProjection(id int64, coords []float64) ProjectionOutput {
+	p := ProjectionOutput{ID: id}
+	p.setCoords(coords)
+	return p
+}
+
+// setCoords replaces p's coordinates, keeping X, Y and Z as the first three
+// (zero for axes it doesn't have)
+func (p *ProjectionOutput) setCoords(coords []float64) {
+	p.Coords = coords
+	var xyz [3]float64
+	copy(xyz[:], coords)
+	p.X, p.Y, p.Z = xyz[0], xyz[1], xyz[2]
+}
+
+// CompleteCoords makes every projection's Coords dims long. Missing axes
+// come from X, Y and Z, or are zero past those, which covers reducers that
+// only report X, Y and Z and a Z set after reducing, e.g. from metadata.
+func CompleteCoords(output *TSNEOutput, dims int) {
+	for i := range output.Projections {
+		p := &output.Projections[i]
+		if len(p.Coords) >= dims {
+			continue
+		}
+		xyz := [3]float64{p.X, p.Y, p.Z}
+		coords := make([]float64, dims)
+		copy(coords, p.Coords)
+		for axis := len(p.Coords); axis < dims && axis < 3; axis++ {
+			coords[axis] = xyz[axis]
+		}
+		p.setCoords(coords)
+	}
+}
+
+// scaleToUnit divides every coordinate by the largest magnitude, so the
+// layout fits in [-1, 1] on every axis like the Python scripts' output
+func scaleToUnit(projections []ProjectionOutput) {
+	var maxAbs float64
+	for _, p := range projections {
+		for _, v := range p.Coords {
+			maxAbs = max(maxAbs, v, -v)
+		}
+	}
+	if maxAbs == 0 {
+		return
+	}
+	for i := range projections {
+		coords := projections[i].Coords
+		for axis := range coords {
+			coords[axis] /= maxAbs
+		}
+		projections[i].setCoords(coords)
+	}
+}
@@ -0,0 +1,127 @@
+package tsne
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultDebugRuns is how many runs Runtime.DebugDir keeps when DebugRuns is zero
+const DefaultDebugRuns = 20
+
+// debugRunLayout timestamps a run's directory name, so names sort by start time
+const debugRunLayout = "20060102T150405.000000000"
+
+// debugRun records one script run in its own directory under
+// Runtime.DebugDir: the exact JSON written to stdin as input.json, and
+// everything the script printed as stdout and stderr. Running the script
+// by hand with input.json on stdin reproduces it. A nil debugRun records
+// nothing.
+type debugRun struct {
+	dir    string
+	input  *os.File
+	stdout *os.File
+}
+
+// startDebugRun creates the directory for a run of script and removes the
+// oldest runs beyond rt.DebugRuns. It returns nil without a DebugDir, or if
+// the files can't be created, which is logged rather than failing the run.
+func (rt Runtime) startDebugRun(script string) *debugRun {
+	if rt.DebugDir == "" {
+		return nil
+	}
+	d := &debugRun{dir: filepath.Join(rt.DebugDir, time.Now().UTC().Format(debugRunLayout)+"-"+strings.TrimSuffix(script, ".py"))}
+	err := os.MkdirAll(d.dir, 0o755)
+	if err == nil {
+		d.input, err = os.Create(filepath.Join(d.dir, "input.json"))
+	}
+	if err == nil {
+		d.stdout, err = os.Create(filepath.Join(d.dir, "stdout"))
+	}
+	if err != nil {
+		slog.Warn("Failed to record reducer run", "dir", d.dir, "err", err)
+		d.close()
+		return nil
+	}
+	rt.pruneDebugRuns()
+	return d
+}
+
+// pruneDebugRuns removes all but the newest rt.DebugRuns run directories.
+// Anything else in DebugDir is left alone.
+func (rt Runtime) pruneDebugRuns() {
+	keep := rt.DebugRuns
+	if keep <= 0 {
+		keep = DefaultDebugRuns
+	}
+	entries, err := os.ReadDir(rt.DebugDir)
+	if err != nil {
+		slog.Warn("Failed to prune reducer debug runs", "dir", rt.DebugDir, "err", err)
+		return
+	}
+	// ReadDir sorts by name, which for runs is oldest first
+	var runs []string
+	for _, e := range entries {
+		name := e.Name()
+		if !e.IsDir() || len(name) <= len(debugRunLayout) {
+			continue
+		}
+		if _, err := time.Parse(debugRunLayout, name[:len(debugRunLayout)]); err == nil {
+			runs = append(runs, name)
+		}
+	}
+	for len(runs) > keep {
+		if err := os.RemoveAll(filepath.Join(rt.DebugDir, runs[0])); err != nil {
+			slog.Warn("Failed to prune reducer debug run", "dir", runs[0], "err", err)
+		}
+		runs = runs[1:]
+	}
+}
+
+// teeInput returns a writer that writes to w and records what is written
+func (d *debugRun) teeInput(w io.Writer) io.Writer {
+	if d == nil {
+		return w
+	}
+	return io.MultiWriter(w, ignoreErrors{d.input})
+}
+
+// writeStdout records a chunk of the script's stdout
+func (d *debugRun) writeStdout(b []byte) {
+	if d != nil {
+		d.stdout.Write(b)
+	}
+}
+
+// finish records the script's stderr and closes the run's files
+func (d *debugRun) finish(stderr []byte) {
+	if d == nil {
+		return
+	}
+	if err := os.WriteFile(filepath.Join(d.dir, "stderr"), stderr, 0o644); err != nil {
+		slog.Warn("Failed to record reducer stderr", "dir", d.dir, "err", err)
+	}
+	d.close()
+}
+
+// close closes whichever of the run's files are open
+func (d *debugRun) close() {
+	if d.input != nil {
+		d.input.Close()
+	}
+	if d.stdout != nil {
+		d.stdout.Close()
+	}
+}
+
+// ignoreErrors writes to w but always succeeds, so a debug file that can't be
+// written never fails the run it records
+type ignoreErrors struct{ w io.Writer }
+
+func (i ignoreErrors) Write(b []byte) (int, error) {
+	i.w.Write(b)
+	return len(b), nil
+}
@@ -0,0 +1,46 @@
+package tsne
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxReportedIDs bounds how many mismatched embedding IDs an error lists
+const maxReportedIDs = 20
+
+// checkDimensions returns the vector length shared by all embeddings. If
+// lengths differ, the most common one is taken as expected and the error
+// lists the IDs of the embeddings that don't match it.
+func checkDimensions(embeddings []EmbeddingInput) (int, error) {
+	counts := make(map[int]int)
+	for _, e := range embeddings {
+		counts[len(e.Vector)]++
+	}
+	if len(counts) <= 1 {
+		return len(embeddings[0].Vector), nil
+	}
+
+	dim := 0
+	for d, n := range counts {
+		if n > counts[dim] || (n == counts[dim] && d > dim) {
+			dim = d
+		}
+	}
+
+	var ids []string
+	mismatched := 0
+	for _, e := range embeddings {
+		if len(e.Vector) == dim {
+			continue
+		}
+		mismatched++
+		if len(ids) < maxReportedIDs {
+			ids = append(ids, fmt.Sprintf("%d (%d)", e.ID, len(e.Vector)))
+		}
+	}
+	list := strings.Join(ids, ", ")
+	if mismatched > len(ids) {
+		list += fmt.Sprintf(" and %d more", mismatched-len(ids))
+	}
+	return 0, fmt.Errorf("embeddings have mixed dimensions: expected %d, but these ids (dimension) differ: %s", dim, list)
+}
@@ -0,0 +1,26 @@
+package tsne
+
+import "math"
+
+// SnapToGrid rounds every coordinate to the nearest multiple of 1/resolution.
+// This trades precision for reproducibility: small floating-point differences
+// between environments disappear, so snapped layouts compare byte-for-byte.
+// A resolution of 0 or less leaves the output untouched.
+func SnapToGrid(output *TSNEOutput, resolution int) {
+	if resolution <= 0 {
+		return
+	}
+	res := float64(resolution)
+	snap := func(v float64) float64 {
+		return math.Round(v*res) / res
+	}
+	for i := range output.Projections {
+		p := &output.Projections[i]
+		p.X = snap(p.X)
+		p.Y = snap(p.Y)
+		p.Z = snap(p.Z)
+		for axis, v := range p.Coords {
+			p.Coords[axis] = snap(v)
+		}
+	}
+}
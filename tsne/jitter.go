@@ -0,0 +1,57 @@
+package tsne
+
+import (
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// Jitter offsets every point that shares its exact coordinates with another
+// (common after SnapToGrid) by a uniform amount in [-amount, amount] per axis,
+// so overlapping points stay visible. Offsets come from a PRNG seeded with
+// seed and are drawn in ID order, so the same input and seed always produce
+// the same layout. Only the first dims axes move, and Coords must already be
+// dims long (see CompleteCoords). An amount of 0 or less does nothing.
+func Jitter(output *TSNEOutput, amount float64, seed int64, dims int) {
+	if amount <= 0 {
+		return
+	}
+
+	counts := make(map[string]int)
+	for _, p := range output.Projections {
+		counts[coordsKey(p.Coords)]++
+	}
+
+	order := make([]int, len(output.Projections))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		return output.Projections[order[a]].ID < output.Projections[order[b]].ID
+	})
+
+	rng := rand.New(rand.NewSource(seed))
+	offset := func() float64 {
+		return (rng.Float64()*2 - 1) * amount
+	}
+	for _, i := range order {
+		p := &output.Projections[i]
+		if counts[coordsKey(p.Coords)] < 2 {
+			continue
+		}
+		for axis := range p.Coords[:dims] {
+			p.Coords[axis] += offset()
+		}
+		p.setCoords(p.Coords)
+	}
+}
+
+// coordsKey identifies a point's exact position, for finding overlaps
+func coordsKey(coords []float64) string {
+	key := make([]byte, 0, 8*len(coords))
+	for _, v := range coords {
+		key = binary.LittleEndian.AppendUint64(key, math.Float64bits(v))
+	}
+	return string(key)
+}
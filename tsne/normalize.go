@@ -0,0 +1,16 @@
+package tsne
+
+import "github.com/tlehman/vecviz/vecmath"
+
+// NormalizeInputs scales every input vector to unit L2 norm in place, so the
+// reducer compares directions rather than magnitudes. Backends don't all return
+// unit-length embeddings, and doing it here keeps every reducer consistent.
+// Zero vectors are left unchanged. With normalize false it does nothing.
+func NormalizeInputs(inputs []EmbeddingInput, normalize bool) {
+	if !normalize {
+		return
+	}
+	for i := range inputs {
+		inputs[i].Vector = vecmath.Normalize(inputs[i].Vector)
+	}
+}
@@ -0,0 +1,46 @@
+package tsne
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+// norm returns v's L2 norm
+func norm(v []float32) float64 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	return math.Sqrt(sum)
+}
+
+func TestNormalizeInputs(t *testing.T) {
+	inputs := randomInputs(20, 3072)
+	for i := range inputs {
+		// Spread the magnitudes, as some backends do
+		for j := range inputs[i].Vector {
+			inputs[i].Vector[j] *= float32(i + 1)
+		}
+	}
+	inputs = append(inputs, EmbeddingInput{ID: 100, Vector: make([]float32, 3072)})
+
+	NormalizeInputs(inputs, true)
+
+	for _, in := range inputs[:len(inputs)-1] {
+		if n := norm(in.Vector); math.Abs(n-1) > 1e-5 {
+			t.Errorf("input %d has norm %v, want 1", in.ID, n)
+		}
+	}
+	if zero := inputs[len(inputs)-1].Vector; slices.ContainsFunc(zero, func(x float32) bool { return x != 0 }) {
+		t.Error("a zero vector changed")
+	}
+}
+
+func TestNormalizeInputsOff(t *testing.T) {
+	inputs := []EmbeddingInput{{ID: 1, Vector: []float32{3, 4}}}
+	NormalizeInputs(inputs, false)
+	if !slices.Equal(inputs[0].Vector, []float32{3, 4}) {
+		t.Errorf("vector = %v, want it untouched", inputs[0].Vector)
+	}
+}
@@ -0,0 +1,153 @@
+package tsne
+
+import (
+	"context"
+	"math"
+	"math/rand"
+)
+
+const (
+	// pcaMaxIterations bounds the power iteration for each component
+	pcaMaxIterations = 500
+	// pcaTolerance stops the power iteration once a component stops moving
+	pcaTolerance = 1e-9
+)
+
+// PCAReducer projects onto the top principal components in pure Go.
+// It never needs a subprocess, so it works without Python or scikit-learn.
+type PCAReducer struct{}
+
+// Reduce runs ComputePCA
+func (PCAReducer) Reduce(_ context.Context, inputs []EmbeddingInput, params TSNEParams) (*TSNEOutput, error) {
+	return ComputePCA(inputs, params)
+}
+
+// ComputePCA centers the embeddings and projects them onto their top
+// principal components, found by power iteration with deflation.
+// Each iteration multiplies by XᵀX implicitly, so the d×d covariance
+// matrix is never built.
+func ComputePCA(embeddings []EmbeddingInput, params TSNEParams) (*TSNEOutput, error) {
+	if len(embeddings) == 0 {
+		return &TSNEOutput{Projections: []ProjectionOutput{}}, nil
+	}
+
+	dim, err := checkDimensions(embeddings)
+	if err != nil {
+		return nil, err
+	}
+
+	// Center the data
+	mean := make([]float64, dim)
+	for _, e := range embeddings {
+		for j, v := range e.Vector {
+			mean[j] += float64(v)
+		}
+	}
+	for j := range mean {
+		mean[j] /= float64(len(embeddings))
+	}
+	centered := make([][]float64, len(embeddings))
+	for i, e := range embeddings {
+		centered[i] = make([]float64, dim)
+		for j, v := range e.Vector {
+			centered[i][j] = float64(v) - mean[j]
+		}
+	}
+
+	rng := rand.New(rand.NewSource(params.Seed()))
+	components := make([][]float64, 0, params.Dims())
+	for len(components) < params.Dims() {
+		components = append(components, powerIterate(centered, components, rng))
+	}
+
+	// Each axis's share of the total variance is its sum of squared
+	// coordinates over the sum of squares of the centered data
+	var totalVariance float64
+	axisVariance := make([]float64, len(components))
+	projections := make([]ProjectionOutput, len(embeddings))
+	for i, row := range centered {
+		totalVariance += dot(row, row)
+		coords := make([]float64, len(components))
+		for axis, c := range components {
+			coords[axis] = dot(row, c)
+			axisVariance[axis] += coords[axis] * coords[axis]
+		}
+		projections[i] = newProjection(embeddings[i].ID, coords)
+	}
+	explained := make([]float64, len(components))
+	if totalVariance > 0 {
+		for axis, v := range axisVariance {
+			explained[axis] = v / totalVariance
+		}
+	}
+
+	// Normalize to [-1, 1] range for visualization, like the t-SNE script
+	scaleToUnit(projections)
+
+	return &TSNEOutput{Projections: projections, ExplainedVarianceRatio: explained}, nil
+}
+
+// powerIterate finds the dominant eigenvector of XᵀX orthogonal to found.
+// If the remaining variance is zero it returns an arbitrary orthogonal unit vector.
+func powerIterate(x [][]float64, found [][]float64, rng *rand.Rand) []float64 {
+	dim := len(x[0])
+	v := make([]float64, dim)
+	for j := range v {
+		v[j] = rng.NormFloat64()
+	}
+	orthonormalize(v, found)
+
+	xv := make([]float64, len(x))
+	for iter := 0; iter < pcaMaxIterations; iter++ {
+		// next = Xᵀ(Xv)
+		for i, row := range x {
+			xv[i] = dot(row, v)
+		}
+		next := make([]float64, dim)
+		for i, row := range x {
+			for j, val := range row {
+				next[j] += val * xv[i]
+			}
+		}
+		if !orthonormalize(next, found) {
+			return v
+		}
+
+		var diff float64
+		for j := range v {
+			diff += (next[j] - v[j]) * (next[j] - v[j])
+		}
+		v = next
+		if diff < pcaTolerance {
+			break
+		}
+	}
+	return v
+}
+
+// orthonormalize removes the components of v along each of basis and scales
+// it to unit length. It reports false if nothing is left of v.
+func orthonormalize(v []float64, basis [][]float64) bool {
+	for _, b := range basis {
+		d := dot(v, b)
+		for j := range v {
+			v[j] -= d * b[j]
+		}
+	}
+	norm := math.Sqrt(dot(v, v))
+	if norm == 0 {
+		return false
+	}
+	for j := range v {
+		v[j] /= norm
+	}
+	return true
+}
+
+func dot(a, b []float64) float64 {
+	var sum float64
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
@@ -0,0 +1,53 @@
+package tsne
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPCAExplainedVariance(t *testing.T) {
+	// A pair of points at ±scale on each axis: the data is already centered,
+	// axis i holds 2*scale² of the variance, and the principal components
+	// are the axes in order of scale
+	scales := []float32{4, 3, 2, 1}
+	var inputs []EmbeddingInput
+	for axis, scale := range scales {
+		for _, sign := range []float32{1, -1} {
+			v := make([]float32, len(scales))
+			v[axis] = sign * scale
+			inputs = append(inputs, EmbeddingInput{ID: int64(len(inputs)), Vector: v})
+		}
+	}
+
+	output, err := ComputePCA(inputs, TSNEParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Total variance is 2*(16+9+4+1) = 60
+	want := []float64{32.0 / 60, 18.0 / 60, 8.0 / 60}
+	if len(output.ExplainedVarianceRatio) != len(want) {
+		t.Fatalf("explained variance = %v, want %v", output.ExplainedVarianceRatio, want)
+	}
+	for i, ratio := range output.ExplainedVarianceRatio {
+		if math.Abs(ratio-want[i]) > 1e-6 {
+			t.Errorf("axis %d explains %.6f of the variance, want %.6f", i, ratio, want[i])
+		}
+	}
+
+	// Each component is one input axis, so each point lands on the output
+	// axis for its input axis, at its scale over the largest one, and the
+	// pair on the dropped axis lands at the origin
+	for i, p := range output.Projections {
+		axis := i / 2
+		for j, c := range []float64{p.X, p.Y, p.Z} {
+			var want float64
+			if j == axis {
+				want = float64(scales[axis] / scales[0])
+			}
+			if math.Abs(math.Abs(c)-want) > 1e-3 {
+				t.Errorf("point %d has %.6f on axis %d, want ±%.6f", i, c, j, want)
+			}
+		}
+	}
+}
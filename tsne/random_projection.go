@@ -0,0 +1,49 @@
+package tsne
+
+import (
+	"math"
+	"math/rand"
+)
+
+// ComputeRandomProjection projects embeddings to params.Dims() axes by multiplying them with a
+// fixed random Gaussian matrix. It is much faster than t-SNE and needs no
+// subprocess, but only roughly preserves distances. Only params.RandomSeed is
+// used, so the same seed always yields the same matrix.
+func ComputeRandomProjection(embeddings []EmbeddingInput, params TSNEParams) (*TSNEOutput, error) {
+	if len(embeddings) == 0 {
+		return &TSNEOutput{Projections: []ProjectionOutput{}}, nil
+	}
+
+	dim, err := checkDimensions(embeddings)
+	if err != nil {
+		return nil, err
+	}
+
+	// One row of the matrix per output axis, scaled so each axis has unit variance
+	rng := rand.New(rand.NewSource(params.Seed()))
+	dims := params.Dims()
+	scale := 1 / math.Sqrt(float64(dims))
+	matrix := make([][]float64, dims)
+	for axis := range matrix {
+		matrix[axis] = make([]float64, dim)
+		for j := range matrix[axis] {
+			matrix[axis][j] = rng.NormFloat64() * scale
+		}
+	}
+
+	projections := make([]ProjectionOutput, len(embeddings))
+	for i, e := range embeddings {
+		coords := make([]float64, dims)
+		for axis := range matrix {
+			for j, v := range e.Vector {
+				coords[axis] += matrix[axis][j] * float64(v)
+			}
+		}
+		projections[i] = newProjection(e.ID, coords)
+	}
+
+	// Normalize to [-1, 1] range for visualization, like the t-SNE script
+	scaleToUnit(projections)
+
+	return &TSNEOutput{Projections: projections}, nil
+}
@@ -0,0 +1,49 @@
+package tsne
+
+import "context"
+
+// Reducer projects high-dimensional embeddings down to a few dimensions.
+// Canceling ctx stops a reducer that runs a subprocess; the pure Go ones
+// run to completion.
+type Reducer interface {
+	Reduce(ctx context.Context, inputs []EmbeddingInput, params TSNEParams) (*TSNEOutput, error)
+}
+
+// EmbeddingStream calls yield for each input in turn, stopping at and
+// returning the first error. It may be called more than once, e.g. when a
+// crashed script is rerun, and must yield the same inputs each time.
+type EmbeddingStream func(yield func(EmbeddingInput) error) error
+
+// StreamReducer is a Reducer that can also read its inputs from a stream,
+// so they never all have to be in memory at once
+type StreamReducer interface {
+	Reducer
+	// ReduceStream reduces the count inputs stream yields
+	ReduceStream(ctx context.Context, count int, stream EmbeddingStream, params TSNEParams) (*TSNEOutput, error)
+}
+
+// PythonReducer runs t-SNE in a scikit-learn subprocess
+type PythonReducer struct {
+	// OnProgress, if set, is called for each iteration the script reports
+	OnProgress func(Progress)
+	// Runtime picks the interpreter and script location
+	Runtime Runtime
+}
+
+// Reduce runs t-SNE like ComputeTSNEWithProgress, using r.Runtime
+func (r PythonReducer) Reduce(ctx context.Context, inputs []EmbeddingInput, params TSNEParams) (*TSNEOutput, error) {
+	return r.Runtime.computeWithScript(ctx, "t-SNE", "tsne_compute.py", inputs, params, r.OnProgress)
+}
+
+// ReduceStream runs t-SNE on a stream of inputs, using r.Runtime
+func (r PythonReducer) ReduceStream(ctx context.Context, count int, stream EmbeddingStream, params TSNEParams) (*TSNEOutput, error) {
+	return r.Runtime.computeStreamWithScript(ctx, "t-SNE", "tsne_compute.py", count, stream, params, r.OnProgress)
+}
+
+// RandomProjectionReducer projects with a seeded random Gaussian matrix in pure Go
+type RandomProjectionReducer struct{}
+
+// Reduce runs ComputeRandomProjection
+func (RandomProjectionReducer) Reduce(_ context.Context, inputs []EmbeddingInput, params TSNEParams) (*TSNEOutput, error) {
+	return ComputeRandomProjection(inputs, params)
+}
@@ -0,0 +1,58 @@
+package tsne
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+// randomInputs returns n random dim-dimensional inputs, the same for a given n and dim
+func randomInputs(n, dim int) []EmbeddingInput {
+	rng := rand.New(rand.NewSource(1))
+	inputs := make([]EmbeddingInput, n)
+	for i := range inputs {
+		v := make([]float32, dim)
+		for j := range v {
+			v[j] = rng.Float32()
+		}
+		inputs[i] = EmbeddingInput{ID: int64(i + 1), Vector: v}
+	}
+	return inputs
+}
+
+// reduceTwice runs reducer twice on the same inputs with params and fails
+// the test unless both runs give identical projections
+func reduceTwice(t *testing.T, reducer Reducer, params TSNEParams) {
+	t.Helper()
+	inputs := randomInputs(40, 16)
+	first, err := reducer.Reduce(context.Background(), inputs, params)
+	if err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+	second, err := reducer.Reduce(context.Background(), inputs, params)
+	if err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+	if !reflect.DeepEqual(first.Projections, second.Projections) {
+		t.Error("two runs with the same seed gave different layouts")
+	}
+}
+
+func TestSameSeedSameLayout(t *testing.T) {
+	for _, seed := range []int64{0, 7} {
+		params := TSNEParams{RandomSeed: seed}
+		t.Run(fmt.Sprintf("pca seed %d", seed), func(t *testing.T) { reduceTwice(t, PCAReducer{}, params) })
+		t.Run(fmt.Sprintf("random projection seed %d", seed), func(t *testing.T) { reduceTwice(t, RandomProjectionReducer{}, params) })
+	}
+}
+
+func TestSameSeedSameTSNELayout(t *testing.T) {
+	// t-SNE runs in scikit-learn, which must honor random_state itself
+	if err := exec.Command(defaultPython, "-c", "import numpy, sklearn").Run(); err != nil {
+		t.Skipf("t-SNE needs %s with numpy and scikit-learn: %v", defaultPython, err)
+	}
+	reduceTwice(t, PythonReducer{}, TSNEParams{RandomSeed: 7, Perplexity: 5})
+}
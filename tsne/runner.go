@@ -1,78 +1,476 @@
 package tsne
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"os"
 	"os/exec"
 	"path/filepath"
-	"runtime"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/tlehman/vecviz/scripts"
+)
+
+// ErrTimeout is returned when a reducer script runs past its deadline and is killed
+var ErrTimeout = errors.New("reducer timed out")
+
+const (
+	// baseScriptTimeout is the deadline for a reducer script on a tiny input
+	baseScriptTimeout = 5 * time.Minute
+	// perPointScriptTimeout extends the deadline for every embedding reduced
+	perPointScriptTimeout = 100 * time.Millisecond
+	// scriptWaitDelay bounds how long to wait for a killed script's pipes to close
+	scriptWaitDelay = 5 * time.Second
 )
 
+// restarts counts how many times a crashed reducer process has been rerun
+var restarts atomic.Int64
+
+// Restarts returns the number of times a crashed reducer process has been rerun
+func Restarts() int64 {
+	return restarts.Load()
+}
+
 // EmbeddingInput represents an embedding with its prompt ID
 type EmbeddingInput struct {
 	ID     int64     `json:"id"`
 	Vector []float32 `json:"vector"`
+	// Init is an optional starting position for incremental runs
+	Init *[3]float64 `json:"init,omitempty"`
+}
+
+const (
+	// DefaultRandomSeed is used when TSNEParams.RandomSeed is zero
+	DefaultRandomSeed = 42
+	// DefaultDimensions is used when TSNEParams.Dimensions is zero
+	DefaultDimensions = 3
+)
+
+// TSNEParams tunes a t-SNE run. Zero values fall back to the script's defaults.
+type TSNEParams struct {
+	// Dimensions is the target dimensionality, 1 to MaxDimensions. Axes the
+	// run doesn't have are zero in X, Y and Z.
+	Dimensions   int     `json:"dimensions,omitempty"`
+	Perplexity   float64 `json:"perplexity,omitempty"`
+	Iterations   int     `json:"iterations,omitempty"`
+	LearningRate float64 `json:"learning_rate,omitempty"`
+	RandomSeed   int64   `json:"random_seed,omitempty"`
+	// Init is how t-SNE places points before optimizing: "pca" (the default)
+	// starts from the first principal components, which converges faster and
+	// gives the same layout run to run; "random" starts from noise, which can
+	// untangle structure PCA hides but needs more iterations and varies with
+	// the seed. Ignored by incremental runs and other reducers.
+	Init string `json:"init,omitempty"`
+	// Metric is how t-SNE measures distance between embeddings: "euclidean"
+	// (the default) or "cosine", which compares only direction and often
+	// clusters embeddings better. Ignored by other reducers.
+	Metric string `json:"metric,omitempty"`
+	// PCAComponents, if set, reduces the embeddings to this many dimensions
+	// with PCA before t-SNE. Around 50 keeps nearly all neighborhood
+	// structure while making t-SNE's neighbor search much faster on large
+	// sets. Zero, the default, runs t-SNE on the full vectors.
+	PCAComponents int `json:"pca_components,omitempty"`
+	// Incremental starts t-SNE from each input's Init position and only runs a
+	// short refinement, keeping an existing layout stable. Requires every Init.
+	Incremental bool `json:"incremental,omitempty"`
+	// GridResolution snaps coordinates to multiples of 1/GridResolution after
+	// reducing (see SnapToGrid). Zero, the default, disables snapping.
+	GridResolution int `json:"grid_resolution,omitempty"`
+	// Jitter nudges points that land on identical coordinates apart by up to
+	// this much on each axis (see Jitter). Zero, the default, disables it.
+	Jitter float64 `json:"jitter,omitempty"`
+	// JitterSeed seeds the jitter offsets; zero falls back to the run's seed.
+	// The same seed and input always give the same offsets, and changing the
+	// seed changes the jitter pattern.
+	JitterSeed int64 `json:"jitter_seed,omitempty"`
+	// ZFromMetadata names a numeric prompt field that sets Z instead of the
+	// reducer, which then only lays out X and Y. Applied by the caller.
+	ZFromMetadata string `json:"z_from_metadata,omitempty"`
+	// NNeighbors is UMAP's neighborhood size; larger values favor global
+	// structure. Ignored by other reducers.
+	NNeighbors int `json:"n_neighbors,omitempty"`
+	// MinDist is how tightly UMAP packs points together. Ignored by other reducers.
+	MinDist float64 `json:"min_dist,omitempty"`
+	// TimeoutSeconds is how long a Python reducer may run before it is killed.
+	// Zero scales the deadline with the number of points (see Timeout).
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+	// Normalize scales each embedding to unit length before reducing (see
+	// NormalizeInputs). Applied by the caller, so it works with every reducer.
+	Normalize bool `json:"normalize,omitempty"`
+}
+
+// t-SNE initializations accepted in TSNEParams.Init
+const (
+	InitPCA    = "pca"
+	InitRandom = "random"
+)
+
+// t-SNE distance metrics accepted in TSNEParams.Metric
+const (
+	MetricEuclidean = "euclidean"
+	MetricCosine    = "cosine"
+)
+
+// Seed returns the configured random seed, or DefaultRandomSeed if unset
+func (p TSNEParams) Seed() int64 {
+	if p.RandomSeed == 0 {
+		return DefaultRandomSeed
+	}
+	return p.RandomSeed
+}
+
+// JitterSeedOrDefault returns the configured jitter seed, or Seed() if unset
+func (p TSNEParams) JitterSeedOrDefault() int64 {
+	if p.JitterSeed == 0 {
+		return p.Seed()
+	}
+	return p.JitterSeed
+}
+
+// Timeout returns the deadline for reducing n points: TimeoutSeconds if set,
+// otherwise a base allowance plus a little per point
+func (p TSNEParams) Timeout(n int) time.Duration {
+	if p.TimeoutSeconds > 0 {
+		return time.Duration(p.TimeoutSeconds) * time.Second
+	}
+	return baseScriptTimeout + time.Duration(n)*perPointScriptTimeout
+}
+
+// Dims returns the target dimensionality, or DefaultDimensions if unset
+func (p TSNEParams) Dims() int {
+	if p.Dimensions == 0 {
+		return DefaultDimensions
+	}
+	return p.Dimensions
 }
 
 // TSNEInput is the input format for the Python script
 type TSNEInput struct {
 	Embeddings []EmbeddingInput `json:"embeddings"`
+	Params     TSNEParams       `json:"params"`
 }
 
-// ProjectionOutput represents a 3D projection
+// ProjectionOutput represents a projection. X, Y and Z are its first three
+// axes, zero past the run's dimensions; Coords holds every axis.
 type ProjectionOutput struct {
-	ID int64   `json:"id"`
-	X  float64 `json:"x"`
-	Y  float64 `json:"y"`
-	Z  float64 `json:"z"`
+	ID     int64     `json:"id"`
+	X      float64   `json:"x"`
+	Y      float64   `json:"y"`
+	Z      float64   `json:"z"`
+	Coords []float64 `json:"coords,omitempty"`
+}
+
+// HistoryPoint is one sample of t-SNE convergence, reported every 50 iterations
+type HistoryPoint struct {
+	Iteration    int     `json:"iteration"`
+	KLDivergence float64 `json:"kl_divergence"`
+	GradNorm     float64 `json:"grad_norm"`
 }
 
+// MaxHistory bounds how many convergence samples are kept from a run
+const MaxHistory = 200
+
 // TSNEOutput is the output format from the Python script
 type TSNEOutput struct {
 	Projections []ProjectionOutput `json:"projections"`
+	History     []HistoryPoint     `json:"history,omitempty"`
+	// KLDivergence is t-SNE's final KL divergence, lower for a layout that
+	// better keeps neighborhoods; nil from other reducers
+	KLDivergence *float64 `json:"kl_divergence,omitempty"`
+	// ExplainedVarianceRatio is, for PCA, the share of the variance each
+	// axis captures
+	ExplainedVarianceRatio []float64 `json:"explained_variance_ratio,omitempty"`
 }
 
-// getProjectRoot returns the project root directory
-func getProjectRoot() string {
-	_, filename, _, _ := runtime.Caller(0)
-	dir := filepath.Dir(filename)
-	return filepath.Join(dir, "..")
+// defaultPython is the interpreter used when Runtime.PythonPath is empty
+const defaultPython = "python3"
+
+// Runtime says how the Python reducer scripts are launched. The zero value
+// runs python3 on the scripts embedded in the binary.
+type Runtime struct {
+	// PythonPath is the interpreter to run, e.g. .venv/bin/python
+	PythonPath string
+	// Root, if set, is a project directory whose scripts/ are run instead of
+	// the embedded copies, so edits take effect without rebuilding
+	Root string
+	// DebugDir, if set, is a directory each script run records its input
+	// and output in, for reproducing it by hand. Off by default.
+	DebugDir string
+	// DebugRuns is how many runs DebugDir keeps, DefaultDebugRuns if zero
+	DebugRuns int
 }
 
-// getScriptPath returns the path to the t-SNE Python script
-func getScriptPath() string {
-	return filepath.Join(getProjectRoot(), "scripts", "tsne_compute.py")
+// python returns the configured interpreter, or python3 if unset
+func (rt Runtime) python() string {
+	if rt.PythonPath == "" {
+		return defaultPython
+	}
+	return rt.PythonPath
+}
+
+// getScriptPath returns a path to the named Python script and a cleanup
+// func to call once the script has run. Without a Root, the embedded script
+// is written to a temporary file that cleanup removes.
+func (rt Runtime) getScriptPath(name string) (path string, cleanup func(), err error) {
+	if rt.Root != "" {
+		return filepath.Join(rt.Root, "scripts", name), func() {}, nil
+	}
+
+	source, err := scripts.FS.ReadFile(name)
+	if err != nil {
+		return "", nil, err
+	}
+	f, err := os.CreateTemp("", "vecviz-*-"+name)
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup = func() { os.Remove(f.Name()) }
+	if _, err := f.Write(source); err != nil {
+		f.Close()
+		cleanup()
+		return "", nil, err
+	}
+	if err := f.Close(); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return f.Name(), cleanup, nil
 }
 
 // ComputeTSNE runs t-SNE on the given embeddings using Python subprocess
-func ComputeTSNE(embeddings []EmbeddingInput) (*TSNEOutput, error) {
+// with the default Runtime
+func ComputeTSNE(embeddings []EmbeddingInput, params TSNEParams) (*TSNEOutput, error) {
+	return ComputeTSNEWithProgress(embeddings, params, nil)
+}
+
+// Progress reports how far a running t-SNE has got
+type Progress struct {
+	Iteration    int     `json:"iteration"`
+	Iterations   int     `json:"iterations"`
+	KLDivergence float64 `json:"kl_divergence"`
+}
+
+// progressPrefix marks a progress line in the script's stdout
+var progressPrefix = []byte("PROGRESS ")
+
+// ComputeTSNEWithProgress is like ComputeTSNE but calls onProgress, if not
+// nil, for each iteration the script reports while it runs. A script that
+// reports no progress just never calls it.
+func ComputeTSNEWithProgress(embeddings []EmbeddingInput, params TSNEParams, onProgress func(Progress)) (*TSNEOutput, error) {
+	return Runtime{}.computeWithScript(context.Background(), "t-SNE", "tsne_compute.py", embeddings, params, onProgress)
+}
+
+// computeWithScript sends the embeddings and params to a Python reducer
+// script as JSON on stdin and parses its TSNEOutput from stdout. A script
+// that crashes is rerun once; one that runs past params.Timeout is killed and
+// ErrTimeout returned without a retry. Canceling ctx kills the script too,
+// returning an error that wraps ctx.Err(). name labels the reducer in errors
+// and logs.
+func (rt Runtime) computeWithScript(ctx context.Context, name, script string, embeddings []EmbeddingInput, params TSNEParams, onProgress func(Progress)) (*TSNEOutput, error) {
 	if len(embeddings) == 0 {
 		return &TSNEOutput{Projections: []ProjectionOutput{}}, nil
 	}
 
-	input := TSNEInput{Embeddings: embeddings}
+	// Catch mixed dimensions here rather than as a numpy traceback
+	dim, err := checkDimensions(embeddings)
+	if err != nil {
+		return nil, err
+	}
+
+	params.Dimensions = params.Dims()
+	params.RandomSeed = params.Seed()
+	input := TSNEInput{Embeddings: embeddings, Params: params}
 	inputJSON, err := json.Marshal(input)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal input: %w", err)
 	}
 
-	scriptPath := getScriptPath()
-	cmd := exec.Command("python3", scriptPath)
-	cmd.Stdin = bytes.NewReader(inputJSON)
+	writeInput := func(w io.Writer) error {
+		_, err := w.Write(inputJSON)
+		return err
+	}
+	return rt.runWithRestart(ctx, name, script, len(embeddings), func() int { return dim }, writeInput, params, onProgress)
+}
 
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+// computeStreamWithScript is computeWithScript for embeddings read from a
+// stream, which is encoded straight onto the script's stdin rather than
+// marshalled up front. count is how many embeddings the stream yields, used
+// for the timeout. A restarted script reads the stream again.
+func (rt Runtime) computeStreamWithScript(ctx context.Context, name, script string, count int, stream EmbeddingStream, params TSNEParams, onProgress func(Progress)) (*TSNEOutput, error) {
+	if count == 0 {
+		return &TSNEOutput{Projections: []ProjectionOutput{}}, nil
+	}
 
-	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("t-SNE failed: %v, stderr: %s", err, stderr.String())
+	params.Dimensions = params.Dims()
+	params.RandomSeed = params.Seed()
+	paramsJSON, err := json.Marshal(params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal input: %w", err)
+	}
+
+	dim := 0
+	writeInput := func(w io.Writer) error {
+		bw := bufio.NewWriter(w)
+		// The same object TSNEInput marshals to, written one embedding at a time
+		bw.WriteString(`{"params":`)
+		bw.Write(paramsJSON)
+		bw.WriteString(`,"embeddings":[`)
+		first := true
+		enc := json.NewEncoder(bw)
+		err := stream(func(e EmbeddingInput) error {
+			// Without the whole set there's no most common length to
+			// compare against, so the first embedding sets it
+			if first {
+				dim = len(e.Vector)
+			} else {
+				if len(e.Vector) != dim {
+					return fmt.Errorf("embeddings have mixed dimensions: expected %d, but id %d has %d", dim, e.ID, len(e.Vector))
+				}
+				bw.WriteByte(',')
+			}
+			first = false
+			return enc.Encode(e)
+		})
+		if err != nil {
+			return err
+		}
+		bw.WriteString("]}")
+		return bw.Flush()
+	}
+	return rt.runWithRestart(ctx, name, script, count, func() int { return dim }, writeInput, params, onProgress)
+}
+
+// runWithRestart runs a reducer script, rerunning it once if it crashes, and
+// parses its output. dim reports the input dimension for the crash log.
+func (rt Runtime) runWithRestart(ctx context.Context, name, script string, count int, dim func() int, writeInput func(io.Writer) error, params TSNEParams, onProgress func(Progress)) (*TSNEOutput, error) {
+	timeout := params.Timeout(count)
+	stdout, err := rt.runScript(ctx, name, script, writeInput, timeout, onProgress)
+	if err != nil {
+		// A script that exited with an error, such as a Python traceback, would
+		// only fail the same way again, so only one that died mid-computation
+		// gets one more try
+		if !crashed(err) {
+			return nil, err
+		}
+		restarts.Add(1)
+		slog.Warn("Reducer process crashed, restarting", "reducer", name, "points", count, "dimension", dim(), "err", err)
+
+		stdout, err = rt.runScript(ctx, name, script, writeInput, timeout, onProgress)
+		if err != nil {
+			return nil, fmt.Errorf("%s failed after restart: %w", name, err)
+		}
 	}
 
 	var output TSNEOutput
-	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
-		return nil, fmt.Errorf("failed to parse output: %w, stdout: %s", err, stdout.String())
+	if err := json.Unmarshal(stdout, &output); err != nil {
+		return nil, fmt.Errorf("failed to parse output: %w, stdout: %s", err, stdout)
+	}
+	if len(output.History) > MaxHistory {
+		output.History = output.History[len(output.History)-MaxHistory:]
 	}
 
 	return &output, nil
 }
+
+// crashed reports whether err is from a script process that was killed by a
+// signal, such as one that segfaulted or was killed for running out of
+// memory, rather than one that exited on its own.
+func crashed(err error) bool {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	return ok && status.Signaled()
+}
+
+// runScript runs a reducer's Python script once, with writeInput writing its
+// JSON input to stdin while it runs. stdout is read line by line as it
+// arrives: progress lines go to onProgress and everything else is returned as
+// the result. The process is killed if it is still running after timeout.
+// An error from writeInput is returned in place of the script's own failure,
+// which is only the truncated input.
+func (rt Runtime) runScript(parent context.Context, name, script string, writeInput func(io.Writer) error, timeout time.Duration, onProgress func(Progress)) ([]byte, error) {
+	path, cleanup, err := rt.getScriptPath(script)
+	if err != nil {
+		return nil, fmt.Errorf("%s script: %w", name, err)
+	}
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, rt.python(), path)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	// A child the script spawned could hold stdout open after the kill
+	cmd.WaitDelay = scriptWaitDelay
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("%s failed to start: %w", name, err)
+	}
+
+	debug := rt.startDebugRun(script)
+	defer func() { debug.finish(stderr.Bytes()) }()
+
+	// Write on the side so a large input can't fill the pipe while stdout,
+	// which the script may write to before it has read everything, backs up
+	inputErr := make(chan error, 1)
+	go func() {
+		err := writeInput(debug.teeInput(stdin))
+		stdin.Close()
+		inputErr <- err
+	}()
+
+	var result bytes.Buffer
+	reader := bufio.NewReader(stdout)
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		debug.writeStdout(line)
+		if rest, ok := bytes.CutPrefix(line, progressPrefix); ok {
+			var p Progress
+			if err := json.Unmarshal(rest, &p); err == nil && onProgress != nil {
+				onProgress(p)
+			}
+		} else {
+			result.Write(line)
+		}
+		if readErr != nil {
+			break
+		}
+	}
+
+	waitErr := cmd.Wait()
+	// A script that exits early leaves writes failing with a closed pipe,
+	// which says nothing its exit status doesn't
+	if err := <-inputErr; err != nil && !errors.Is(err, syscall.EPIPE) && !errors.Is(err, os.ErrClosed) {
+		return nil, fmt.Errorf("%s input: %w", name, err)
+	}
+	if err := waitErr; err != nil {
+		// A canceled script isn't rerun, so this mustn't look like a crash
+		if err := parent.Err(); err != nil {
+			return nil, fmt.Errorf("%s killed: %w", name, err)
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("%w: %s killed after %s, stderr: %s", ErrTimeout, name, timeout, stderr.String())
+		}
+		return nil, fmt.Errorf("%s failed: %w, stderr: %s", name, err, stderr.String())
+	}
+	return result.Bytes(), nil
+}
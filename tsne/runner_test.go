@@ -0,0 +1,105 @@
+package tsne
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scriptRuntime returns a Runtime that runs the shell script source, under
+// the name "test.sh", in place of a Python reducer
+func scriptRuntime(t *testing.T, source string) Runtime {
+	t.Helper()
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "scripts"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "scripts", "test.sh"), []byte(source), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return Runtime{PythonPath: "/bin/sh", Root: root}
+}
+
+// runTestScript runs the script as runWithRestart would run a reducer
+func runTestScript(rt Runtime, params TSNEParams) (*TSNEOutput, error) {
+	noInput := func(io.Writer) error { return nil }
+	return rt.runWithRestart(context.Background(), "test", "test.sh", 1, func() int { return 1 }, noInput, params, nil)
+}
+
+func TestRunWithRestartRerunsCrashedScript(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "crashed")
+	// Killed by a signal on the first run, successful on the second
+	rt := scriptRuntime(t, `
+if [ ! -e "`+marker+`" ]; then
+	touch "`+marker+`"
+	kill -KILL $$
+fi
+echo '{"projections": [{"id": 1, "x": 0.5, "y": 0, "z": 0}]}'
+`)
+
+	before := Restarts()
+	output, err := runTestScript(rt, TSNEParams{})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if len(output.Projections) != 1 {
+		t.Errorf("projections = %+v, want the rerun's one", output.Projections)
+	}
+	if got := Restarts() - before; got != 1 {
+		t.Errorf("Restarts went up by %d, want 1", got)
+	}
+}
+
+func TestRunWithRestartDoesNotRerunFailedScript(t *testing.T) {
+	runs := filepath.Join(t.TempDir(), "runs")
+	// Exits with an error, as a Python traceback does
+	rt := scriptRuntime(t, `
+echo run >> "`+runs+`"
+echo 'Traceback (most recent call last):' >&2
+exit 1
+`)
+
+	before := Restarts()
+	if _, err := runTestScript(rt, TSNEParams{}); err == nil {
+		t.Fatal("run succeeded, want the script's error")
+	}
+	log, err := os.ReadFile(runs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(log) / len("run\n"); n != 1 {
+		t.Errorf("script ran %d times, want 1", n)
+	}
+	if got := Restarts() - before; got != 0 {
+		t.Errorf("Restarts went up by %d, want 0", got)
+	}
+}
+
+func TestHungScriptIsKilled(t *testing.T) {
+	rt := scriptRuntime(t, `
+echo 'still going' >&2
+exec sleep 60
+`)
+
+	before := Restarts()
+	start := time.Now()
+	_, err := runTestScript(rt, TSNEParams{TimeoutSeconds: 1})
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("err = %v, want ErrTimeout", err)
+	}
+	if !strings.Contains(err.Error(), "still going") {
+		t.Errorf("err = %q, want it to include the script's stderr", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("killed after %s, want about the 1s timeout", elapsed)
+	}
+	// A script killed for its deadline isn't a crash
+	if got := Restarts() - before; got != 0 {
+		t.Errorf("Restarts went up by %d, want 0", got)
+	}
+}
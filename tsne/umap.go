@@ -0,0 +1,27 @@
+package tsne
+
+import "context"
+
+// UMAPReducer runs UMAP in a umap-learn subprocess. UMAP keeps more of the
+// global structure than t-SNE, so distances between clusters mean more.
+type UMAPReducer struct {
+	// Runtime picks the interpreter and script location
+	Runtime Runtime
+}
+
+// Reduce runs UMAP like ComputeUMAP, using r.Runtime
+func (r UMAPReducer) Reduce(ctx context.Context, inputs []EmbeddingInput, params TSNEParams) (*TSNEOutput, error) {
+	return r.Runtime.computeWithScript(ctx, "UMAP", "umap_compute.py", inputs, params, nil)
+}
+
+// ReduceStream runs UMAP on a stream of inputs, using r.Runtime
+func (r UMAPReducer) ReduceStream(ctx context.Context, count int, stream EmbeddingStream, params TSNEParams) (*TSNEOutput, error) {
+	return r.Runtime.computeStreamWithScript(ctx, "UMAP", "umap_compute.py", count, stream, params, nil)
+}
+
+// ComputeUMAP runs UMAP on the given embeddings using a Python subprocess.
+// It honours Dimensions, RandomSeed, NNeighbors, MinDist and Incremental;
+// the t-SNE specific parameters are ignored. It uses the default Runtime.
+func ComputeUMAP(embeddings []EmbeddingInput, params TSNEParams) (*TSNEOutput, error) {
+	return Runtime{}.computeWithScript(context.Background(), "UMAP", "umap_compute.py", embeddings, params, nil)
+}
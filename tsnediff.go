@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/tlehman/vecviz/db"
+)
+
+// defaultProjectionHistory is how many snapshots /tsne/compute?snapshot=true
+// keeps unless VECVIZ_PROJECTION_HISTORY says otherwise
+const defaultProjectionHistory = 10
+
+// GET /tsne/diff?from=3&to=4 - How far each point moved between two runs
+//
+// from and to are run IDs as /tsne/compute returns them; each must be the
+// current run or one snapshotted with ?snapshot=true, and to defaults to the
+// current run. For every point in both runs the response gives its
+// displacement (dx, dy, dz) and distance, largest first, along with the mean,
+// RMS and largest distance. Points in only one run are listed by ID. Runs are
+// compared as stored, without aligning them, so a layout that came out
+// rotated or mirrored shows as large movement.
+func (s *Server) handleTSNEDiff(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	from, err := strconv.ParseInt(r.URL.Query().Get("from"), 10, 64)
+	if err != nil || from < 1 {
+		writeJSONError(w, http.StatusBadRequest, "from must be a run ID")
+		return
+	}
+	var to int64
+	if v := r.URL.Query().Get("to"); v != "" {
+		to, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || to < 1 {
+			writeJSONError(w, http.StatusBadRequest, "to must be a run ID")
+			return
+		}
+	} else if to, err = s.store.ProjectionRunID(); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to get projection run ID: "+err.Error())
+		return
+	}
+
+	runs := make([]map[int64]db.Projection, 2)
+	for i, id := range []int64{from, to} {
+		runs[i], err = s.store.RunProjections(r.Context(), id)
+		if errors.Is(err, db.ErrRunNotFound) {
+			writeJSONError(w, http.StatusNotFound, "Run "+strconv.FormatInt(id, 10)+" is neither the current run nor snapshotted")
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to get projections: "+err.Error())
+			return
+		}
+	}
+	before, after := runs[0], runs[1]
+
+	type displacement struct {
+		ID       int64   `json:"id"`
+		DX       float64 `json:"dx"`
+		DY       float64 `json:"dy"`
+		DZ       float64 `json:"dz"`
+		Distance float64 `json:"distance"`
+	}
+	points := []displacement{}
+	onlyInFrom := []int64{}
+	var sum, sumSquares, largest float64
+	for id, p := range before {
+		q, ok := after[id]
+		if !ok {
+			onlyInFrom = append(onlyInFrom, id)
+			continue
+		}
+		d := displacement{ID: id, DX: q.X - p.X, DY: q.Y - p.Y, DZ: q.Z - p.Z}
+		d.Distance = math.Sqrt(d.DX*d.DX + d.DY*d.DY + d.DZ*d.DZ)
+		points = append(points, d)
+		sum += d.Distance
+		sumSquares += d.Distance * d.Distance
+		largest = math.Max(largest, d.Distance)
+	}
+	onlyInTo := []int64{}
+	for id := range after {
+		if _, ok := before[id]; !ok {
+			onlyInTo = append(onlyInTo, id)
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].Distance != points[j].Distance {
+			return points[i].Distance > points[j].Distance
+		}
+		return points[i].ID < points[j].ID
+	})
+	sort.Slice(onlyInFrom, func(i, j int) bool { return onlyInFrom[i] < onlyInFrom[j] })
+	sort.Slice(onlyInTo, func(i, j int) bool { return onlyInTo[i] < onlyInTo[j] })
+
+	resp := map[string]interface{}{
+		"from":              from,
+		"to":                to,
+		"points":            points,
+		"only_in_from":      onlyInFrom,
+		"only_in_to":        onlyInTo,
+		"mean_displacement": nil,
+		"rms_displacement":  nil,
+		"max_displacement":  nil,
+	}
+	if n := float64(len(points)); n > 0 {
+		resp["mean_displacement"] = sum / n
+		resp["rms_displacement"] = math.Sqrt(sumSquares / n)
+		resp["max_displacement"] = largest
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tlehman/vecviz/db"
+)
+
+const (
+	// defaultRunsLimit is how many runs /tsne/runs lists when limit is not given
+	defaultRunsLimit = 20
+	// maxRunsLimit bounds limit for /tsne/runs; the database keeps no more
+	maxRunsLimit = 100
+)
+
+// GET /tsne/runs?limit=20 - List recent projection runs, newest first
+//
+// Every /tsne/compute (and -watch update) that gets past the cache check is
+// recorded with the run_id it returned, so logs, /tsne/progress events,
+// /tsne/diff and /tsne/history can be matched up. Each run has its method
+// and effective params, its status (running, completed, failed, cancelled,
+// or interrupted by a restart), how many points it was given, when it
+// started and how long it took. "current_run_id" is the run the stored
+// projections came from.
+func (s *Server) handleTSNERuns(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	limit := defaultRunsLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxRunsLimit {
+			writeJSONError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxRunsLimit))
+			return
+		}
+	}
+
+	runs, err := s.store.ListProjectionRuns(r.Context(), limit)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to list runs: "+err.Error())
+		return
+	}
+	currentRunID, err := s.store.ProjectionRunID()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to get projection run ID: "+err.Error())
+		return
+	}
+
+	type runJSON struct {
+		RunID      int64           `json:"run_id"`
+		Method     string          `json:"method"`
+		Params     json.RawMessage `json:"params"`
+		Status     string          `json:"status"`
+		Points     int             `json:"points"`
+		StartedAt  string          `json:"started_at"`
+		DurationMS *int64          `json:"duration_ms"`
+		Error      string          `json:"error,omitempty"`
+	}
+	results := make([]runJSON, len(runs))
+	for i, run := range runs {
+		results[i] = runJSON{
+			RunID:     run.ID,
+			Method:    run.Method,
+			Params:    json.RawMessage(run.Params),
+			Status:    run.Status,
+			Points:    run.Points,
+			StartedAt: run.StartedAt.UTC().Format(time.RFC3339),
+			Error:     run.Error,
+		}
+		// A run that hasn't finished, or never will, has no duration
+		if run.Status != db.RunRunning && run.Status != db.RunInterrupted {
+			ms := run.Duration.Milliseconds()
+			results[i].DurationMS = &ms
+		}
+	}
+
+	resp := map[string]interface{}{
+		"runs":           results,
+		"current_run_id": nil,
+	}
+	if currentRunID != 0 {
+		resp["current_run_id"] = currentRunID
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,72 @@
+package vecmath
+
+import "math"
+
+// Cosine returns the cosine similarity of a and b, or 0 if either is a zero vector
+func Cosine(a, b []float32) float64 {
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// Dot returns the dot product of a and b
+func Dot(a, b []float32) float64 {
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
+
+// Mean returns the element-wise average of vectors, or nil if there are none
+func Mean(vectors [][]float32) []float32 {
+	if len(vectors) == 0 {
+		return nil
+	}
+	sum := make([]float64, len(vectors[0]))
+	for _, v := range vectors {
+		for i, x := range v {
+			sum[i] += float64(x)
+		}
+	}
+	mean := make([]float32, len(sum))
+	for i, s := range sum {
+		mean[i] = float32(s / float64(len(vectors)))
+	}
+	return mean
+}
+
+// L2Distance returns the Euclidean distance between a and b
+func L2Distance(a, b []float32) float64 {
+	var sum float64
+	for i := range a {
+		d := float64(a[i]) - float64(b[i])
+		sum += d * d
+	}
+	return math.Sqrt(sum)
+}
+
+// Normalize returns a copy of v scaled to unit L2 norm, or an unchanged copy if v is zero
+func Normalize(v []float32) []float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	out := make([]float32, len(v))
+	copy(out, v)
+	if sum == 0 {
+		return out
+	}
+	norm := math.Sqrt(sum)
+	for i := range out {
+		out[i] = float32(float64(out[i]) / norm)
+	}
+	return out
+}
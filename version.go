@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/tlehman/vecviz/db"
+)
+
+// version is the vecviz release, set at build time with
+// -ldflags "-X main.version=v1.2.3". Left empty, the module version from
+// the build info is used, "(devel)" for a build from a checkout.
+var version = ""
+
+// GET /version - Describe this build and what it runs on
+//
+// Gives the vecviz version, the git commit and commit time it was built
+// from (with "dirty" set if the checkout had uncommitted changes), the Go
+// version, the SQLite and sqlite-vec versions, and the embedding model and
+// dimension. Unlike /stats it says nothing about the stored data.
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	sqliteVersion, vecVersion, err := s.store.Versions(r.Context())
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to get database versions: "+err.Error())
+		return
+	}
+
+	resp := map[string]interface{}{
+		"version":        version,
+		"commit":         nil,
+		"commit_time":    nil,
+		"dirty":          false,
+		"go_version":     runtime.Version(),
+		"sqlite_version": sqliteVersion,
+		"sqlite_vec":     vecVersion,
+		"model":          s.store.Model,
+		"dimension":      db.Dimension,
+	}
+	// Go records the module version, and the VCS state of a build from a checkout
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if version == "" {
+			resp["version"] = info.Main.Version
+		}
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				resp["commit"] = setting.Value
+			case "vcs.time":
+				resp["commit_time"] = setting.Value
+			case "vcs.modified":
+				resp["dirty"] = setting.Value == "true"
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/tlehman/vecviz/db"
+	"github.com/tlehman/vecviz/tsne"
+)
+
+// watchDebounce is how long a watched file must stay quiet before new lines are processed
+const watchDebounce = 500 * time.Millisecond
+
+// fileWatcher tails a file of prompts, one per line, embedding new lines and
+// incrementally updating the projection as the file grows
+type fileWatcher struct {
+	s    *Server
+	path string
+	// offset is where the next unread line starts
+	offset int64
+}
+
+// watchPromptFile processes the lines already in path, then keeps embedding
+// lines appended to it until ctx is done. The parent directory is watched so
+// the file may be created later or replaced by log rotation.
+func (s *Server) watchPromptFile(ctx context.Context, path string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	path = filepath.Clean(path)
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		return err
+	}
+	slog.Info("Watching for new prompts", "path", path)
+
+	fw := &fileWatcher{s: s, path: path}
+	fw.process(ctx)
+
+	// Each event restarts the timer so a burst of writes is processed once
+	debounce := time.NewTimer(watchDebounce)
+	debounce.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if filepath.Clean(event.Name) == path {
+				debounce.Reset(watchDebounce)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			slog.Error("Watch error", "err", err)
+		case <-debounce.C:
+			fw.process(ctx)
+		}
+	}
+}
+
+// process embeds complete lines added since the last call and, if any were
+// new, runs an incremental projection update
+func (fw *fileWatcher) process(ctx context.Context) {
+	lines, err := fw.readNewLines()
+	if err != nil {
+		slog.Error("Watch: failed to read prompt file", "path", fw.path, "err", err)
+		return
+	}
+
+	embedded := 0
+	for _, line := range lines {
+		if ctx.Err() != nil {
+			return
+		}
+		id, err := fw.s.store.InsertPrompt(line)
+		if err != nil {
+			slog.Error("Watch: failed to store prompt", "err", err)
+			continue
+		}
+		exists, err := fw.s.store.HasEmbedding(ctx, id)
+		if err != nil {
+			slog.Error("Watch: failed to check embedding", "prompt_id", id, "err", err)
+			continue
+		}
+		if exists {
+			continue
+		}
+		embedding, err := fw.s.embedder.Embed(ctx, line)
+		if err != nil {
+			slog.Error("Watch: embed failed", "prompt_id", id, "chars", len(line), "err", err)
+			continue
+		}
+		err = fw.s.store.InsertEmbedding(id, embedding)
+		if err != nil && !errors.Is(err, db.ErrEmbeddingExists) {
+			slog.Error("Watch: failed to store embedding", "prompt_id", id, "dimension", len(embedding), "err", err)
+			continue
+		}
+		if err == nil {
+			publishPointAdded(fw.s.events, fw.s.store, id, line, embedding)
+		}
+		embedded++
+	}
+	if embedded == 0 {
+		return
+	}
+	slog.Info("Watch: embedded new prompts", "count", embedded, "path", fw.path)
+
+	// Reuse the last run's method and settings, keeping the existing layout
+	method := defaultReducer
+	var params tsne.TSNEParams
+	if run := fw.s.lastProjectionRun(); run != nil {
+		method, params = run.Method, run.TSNEParams
+	}
+	params.Incremental = true
+	reducer, ok := fw.s.reducers[method]
+	if !ok {
+		method, reducer = defaultReducer, fw.s.reducers[defaultReducer]
+	}
+
+	start := time.Now()
+	result, err := fw.s.computeProjections(method, reducer, params, false, nil)
+	if err != nil {
+		slog.Error("Watch: projection update failed", "method", method, "err", err)
+		return
+	}
+	slog.Info("Watch: projected points", "count", result.processed, "method", method, "run_id", result.runID, "duration", time.Since(start))
+}
+
+// readNewLines returns the non-empty lines completed since the last read. A
+// trailing line without a newline is left for later, and a file that shrank
+// is assumed to have been truncated or replaced and is read from the start.
+func (fw *fileWatcher) readNewLines() ([]string, error) {
+	f, err := os.Open(fw.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < fw.offset {
+		slog.Info("Watch: prompt file shrank, reading from the start", "path", fw.path)
+		fw.offset = 0
+	}
+	if _, err := f.Seek(fw.offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			return lines, nil
+		}
+		if err != nil {
+			return lines, err
+		}
+		fw.offset += int64(len(line))
+		if text := strings.TrimSpace(line); text != "" {
+			lines = append(lines, text)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/tlehman/vecviz/db"
+)
+
+const (
+	// wsWriteTimeout bounds each message write so a stalled client is dropped
+	wsWriteTimeout = 10 * time.Second
+	// wsPingInterval is how often idle connections are pinged
+	wsPingInterval = 30 * time.Second
+	// wsPongTimeout is how long a client may go without answering a ping
+	wsPongTimeout = 2 * wsPingInterval
+)
+
+// newUpgrader accepts WebSocket connections from the page's own origin and
+// from origins the read CORS policy allows
+func newUpgrader(readCORS *corsPolicy) *websocket.Upgrader {
+	return &websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			if origin == "" || readCORS.allows(origin) {
+				return true
+			}
+			u, err := url.Parse(origin)
+			return err == nil && strings.EqualFold(u.Host, r.Host)
+		},
+	}
+}
+
+// GET /ws - Push live updates over a WebSocket
+//
+// Each message is a JSON object {"type", "data"}:
+//   - "point_added" ({"id", "prompt", "x", "y", "z"}) when a prompt is
+//     embedded for the first time, with x/y/z its provisional position as
+//     from /embed?project=true, or null before anything is projected.
+//   - "projection_updated" ({"method", "points_processed"}) when a recompute
+//     or /tsne/backfill (method "backfill") stores new projections; cached
+//     runs send nothing.
+//
+// Imports send neither, so clients should reload /points after one. Messages
+// from the client are ignored, and a client too slow to keep up misses events.
+func (s *Server) handleWS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already responded with the error
+		return
+	}
+	defer conn.Close()
+
+	events := s.events.subscribe()
+	defer s.events.unsubscribe(events)
+
+	// Reading is what notices a client going away, and it answers pings
+	// and close frames
+	gone := make(chan struct{})
+	conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				var closeErr *websocket.CloseError
+				if !errors.As(err, &closeErr) {
+					slog.Debug("WebSocket client dropped", "err", err)
+				}
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-gone:
+			return
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				return
+			}
+		case event, ok := <-events:
+			if !ok {
+				// The server is shutting down
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"),
+					time.Now().Add(wsWriteTimeout))
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := conn.WriteJSON(map[string]interface{}{"type": event.Name, "data": event.Data}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// publishPointAdded tells /ws subscribers about a newly embedded prompt. The
+// provisional position costs a KNN query, so it is skipped with nobody listening.
+func publishPointAdded(events *progressHub, store *db.Store, promptID int64, text string, vector []float32) {
+	if !events.active() {
+		return
+	}
+	data := map[string]interface{}{"id": promptID, "prompt": text, "x": nil, "y": nil, "z": nil}
+	x, y, z, err := store.ProjectNewPoint(context.Background(), vector)
+	switch {
+	case errors.Is(err, db.ErrNoProjectedNeighbors):
+	case err != nil:
+		slog.Warn("Failed to place new point", "prompt_id", promptID, "err", err)
+	default:
+		data["x"], data["y"], data["z"] = x, y, z
+	}
+	events.publish("point_added", data)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/tlehman/vecviz/db"
+	"github.com/tlehman/vecviz/tsne"
+)
+
+// metadataFields are the numeric prompt fields z_from_metadata can read, keyed by name
+var metadataFields = map[string]func(context.Context, *db.Store) (map[int64]float64, error){
+	"created_at": func(ctx context.Context, store *db.Store) (map[int64]float64, error) {
+		return unixSeconds(store.GetPromptCreationTimes(ctx))
+	},
+	"embedded_at": func(ctx context.Context, store *db.Store) (map[int64]float64, error) {
+		return unixSeconds(store.GetEmbeddedTimes(ctx))
+	},
+}
+
+func unixSeconds(times map[int64]time.Time, err error) (map[int64]float64, error) {
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[int64]float64, len(times))
+	for id, t := range times {
+		values[id] = float64(t.Unix())
+	}
+	return values, nil
+}
+
+// setZFromMetadata replaces each projection's Z with the named metadata field,
+// min-max normalized to [-1, 1] to match the reducers' output range. Points
+// without a value, or a field where every value is equal, get Z = 0.
+func (s *Server) setZFromMetadata(ctx context.Context, output *tsne.TSNEOutput, field string) error {
+	values, err := metadataFields[field](ctx, s.store)
+	if err != nil {
+		return err
+	}
+
+	first := true
+	var lo, hi float64
+	for _, p := range output.Projections {
+		v, ok := values[p.ID]
+		if !ok {
+			continue
+		}
+		if first || v < lo {
+			lo = v
+		}
+		if first || v > hi {
+			hi = v
+		}
+		first = false
+	}
+
+	for i := range output.Projections {
+		p := &output.Projections[i]
+		v, ok := values[p.ID]
+		if !ok || hi == lo {
+			p.Z = 0
+			continue
+		}
+		p.Z = 2*(v-lo)/(hi-lo) - 1
+	}
+	return nil
+}